@@ -22,11 +22,26 @@ var (
 	PORT                        = getEnvDefault("PORT", "80")              //main, tenant
 
 	//only the tenant container needs these
-	TWITCH_CHANNEL         = os.Getenv("TWITCH_CHANNEL")                  //tenant
-	TWITCH_BOT_USERNAME    = os.Getenv("TWITCH_BOT_USERNAME")             //tenant
-	TWITCH_BOT_OAUTH_TOKEN = os.Getenv("TWITCH_BOT_OAUTH_TOKEN")          //tenant
-	DEFAULT_BOT_NICKNAME   = getEnvDefault("DEFAULT_BOT_NICKNAME", "🤖")   //tenant
-	CHAT_HISTORY_LENGTH    = getEnvDefaultInt("CHAT_HISTORY_LENGTH", 100) //tenant
+	TWITCH_CHANNEL                    = os.Getenv("TWITCH_CHANNEL")                       //tenant
+	TWITCH_BOT_USERNAME               = os.Getenv("TWITCH_BOT_USERNAME")                  //tenant
+	TWITCH_BOT_OAUTH_TOKEN            = os.Getenv("TWITCH_BOT_OAUTH_TOKEN")               //tenant
+	DEFAULT_BOT_NICKNAME              = getEnvDefault("DEFAULT_BOT_NICKNAME", "🤖")        //tenant
+	CHAT_HISTORY_LENGTH               = getEnvDefaultInt("CHAT_HISTORY_LENGTH", 100)      //tenant, default page size for /chat_history
+	CHAT_HISTORY_RETENTION            = getEnvDefaultInt("CHAT_HISTORY_RETENTION", 10000) //tenant, max messages kept in redis
+	CHAT_HISTORY_MAX_DAYS             = getEnvDefaultInt("CHAT_HISTORY_MAX_DAYS", 30)     //tenant, messages older than this are trimmed
+	YOUTUBE_CLIENT_ID                 = os.Getenv("YOUTUBE_CLIENT_ID")                    //tenant, google oauth app for posting to youtube chat
+	YOUTUBE_CLIENT_SECRET             = os.Getenv("YOUTUBE_CLIENT_SECRET")                //tenant
+	YOUTUBE_API_KEY                   = os.Getenv("YOUTUBE_API_KEY")                      //tenant, data API key for reading youtube chat in api mode without a login
+	KICK_CLIENT_ID                    = os.Getenv("KICK_CLIENT_ID")                       //tenant, kick oauth app for posting to kick chat
+	KICK_CLIENT_SECRET                = os.Getenv("KICK_CLIENT_SECRET")                   //tenant
+	KICK_PUSHER_KEY                   = os.Getenv("KICK_PUSHER_KEY")                      //tenant, only set if kick changes the pusher key its website reads chat with
+	TWITCH_EVENTSUB_WS_URL            = os.Getenv("TWITCH_EVENTSUB_WS_URL")               //tenant, only set to test against the twitch CLI mock server
+	TWITCH_EVENTSUB_SUBSCRIPTIONS_URL = os.Getenv("TWITCH_EVENTSUB_SUBSCRIPTIONS_URL")    //tenant
+	TWITCH_EVENTSUB_BROADCASTER_ID    = os.Getenv("TWITCH_EVENTSUB_BROADCASTER_ID")       //tenant, with the mock server, subscribe for this user ID instead of looking up TWITCH_CHANNEL's
+	DISCORD_GATEWAY_URL               = os.Getenv("DISCORD_GATEWAY_URL")                  //tenant, only set to test against a fake discord gateway
+	DISCORD_API_URL                   = os.Getenv("DISCORD_API_URL")                      //tenant
+	TIKTOK_WEBCAST_URL                = os.Getenv("TIKTOK_WEBCAST_URL")                   //tenant, only set to go through a signing proxy for webcast.tiktok.com
+	TIKTOK_RECORD_DIR                 = os.Getenv("TIKTOK_RECORD_DIR")                    //tenant, saves every raw tiktok websocket frame here, to replay with tools/tiktokDecode
 )
 
 func getEnvDefault(key string, defaultValue string) string {
@@ -50,6 +50,14 @@ func Get(ctx context.Context, key string) *redis.StringCmd {
 	return r.Get(c(ctx), PREDIS+key)
 }
 
+func Exists(ctx context.Context, keys ...string) *redis.IntCmd {
+	prefixedKeys := make([]string, len(keys))
+	for i, key := range keys {
+		prefixedKeys[i] = PREDIS + key
+	}
+	return r.Exists(c(ctx), prefixedKeys...)
+}
+
 func Incr(ctx context.Context, key string) *redis.IntCmd {
 	return r.Incr(c(ctx), PREDIS+key)
 }
@@ -102,3 +110,30 @@ func SRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
 func SIsMember(ctx context.Context, key string, member interface{}) *redis.BoolCmd {
 	return r.SIsMember(c(ctx), PREDIS+key, member)
 }
+
+// XAdd prefixes the stream name in a copy of the args, so the caller's args are left alone.
+func XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd {
+	args := *a
+	args.Stream = PREDIS + a.Stream
+	return r.XAdd(c(ctx), &args)
+}
+
+func XRangeN(ctx context.Context, key, start, stop string, count int64) *redis.XMessageSliceCmd {
+	return r.XRangeN(c(ctx), PREDIS+key, start, stop, count)
+}
+
+func XRevRangeN(ctx context.Context, key, start, stop string, count int64) *redis.XMessageSliceCmd {
+	return r.XRevRangeN(c(ctx), PREDIS+key, start, stop, count)
+}
+
+func XDel(ctx context.Context, key string, ids ...string) *redis.IntCmd {
+	return r.XDel(c(ctx), PREDIS+key, ids...)
+}
+
+func XLen(ctx context.Context, key string) *redis.IntCmd {
+	return r.XLen(c(ctx), PREDIS+key)
+}
+
+func XTrimMinIDApprox(ctx context.Context, key, minID string, limit int64) *redis.IntCmd {
+	return r.XTrimMinIDApprox(c(ctx), PREDIS+key, minID, limit)
+}
@@ -0,0 +1,721 @@
+package automod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	goaway "github.com/TwiN/go-away"
+	"github.com/redis/go-redis/v9"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	// kinds of rules
+	RULE_WORDS       = "words"       //Patterns are blocked words or phrases, matched as whole words ignoring case
+	RULE_PROFANITY   = "profanity"   //the built-in profanity list that nicknames are checked with
+	RULE_REGEX       = "regex"       //Patterns are regexes, matched ignoring case
+	RULE_LINKS       = "links"       //any link, except to the domains in Patterns and their subdomains
+	RULE_CAPS        = "caps"        //more than Max percent of the letters are capitals, in messages with at least MinLength letters
+	RULE_EMOTES      = "emotes"      //more than Max emotes
+	RULE_REPEAT      = "repeat"      //the same word more than Max times in a row, or the same message more than Max times in AUTOMOD_REPEAT_WINDOW
+	RULE_NEW_ACCOUNT = "new_account" //the account is less than Max days old, on platforms where the age can be looked up
+
+	// what a rule does with a message it matches
+	ACTION_MASK = multiChat.FILTER_MASK //replace what matched with *s, lowercase caps, or replace the whole message if nothing in particular matched
+	ACTION_HIDE = multiChat.FILTER_HIDE
+	ACTION_HOLD = multiChat.FILTER_HOLD
+
+	DEFAULT_CAPS_PERCENT    = 70
+	DEFAULT_CAPS_MIN_LENGTH = 10
+	DEFAULT_MAX_EMOTES      = 10
+	DEFAULT_MAX_REPEATS     = 3
+	DEFAULT_MIN_ACCOUNT_AGE = 7 //days
+
+	AUTOMOD_REPEAT_WINDOW     = 1 * time.Minute
+	AUTOMOD_MAX_CHAR_RUN      = 20 //a character repeated more than this in a row counts as repetition spam
+	AUTOMOD_LOG_SIZE          = 100
+	AUTOMOD_MASKED_TEXT       = "[removed by automod]"
+	ACCOUNT_AGE_TIMEOUT       = 10 * time.Second //how long a lookup can take, it runs after the message was sent or held
+	ACCOUNT_AGE_RETRY_TIME    = 5 * time.Minute  //how long a failed lookup is remembered
+	ACCOUNT_AGE_CACHE_ENTRIES = 10000
+)
+
+// Rule is one check from the automod rules, see Rules
+type Rule struct {
+	Kind      string   `json:"kind"`
+	Action    string   `json:"action"`
+	Patterns  []string `json:"patterns"` // the words, regexes or allowed domains
+	Max       int      `json:"max"`      // see the RULE_*s, 0 = the default
+	MinLength int      `json:"min_length"`
+}
+
+// Decision is a message a rule matched, kept for the bot page
+type Decision struct {
+	At       time.Time `json:"at"`
+	Source   string    `json:"source"`
+	Username string    `json:"username"`
+	Text     string    `json:"text"`
+	Rule     string    `json:"rule"`
+	Detail   string    `json:"detail"`
+	Action   string    `json:"action"`
+}
+
+// AccountAgeLookup finds when a platform account was made
+type AccountAgeLookup func(ctx context.Context, userID, username string) (time.Time, error)
+
+// match is what a rule found in a message. Spans are rune ranges to mask, if there are none the whole message is masked.
+type match struct {
+	rule   Rule
+	detail string
+	spans  [][2]int
+}
+
+type accountAge struct {
+	created time.Time
+	err     error
+	at      time.Time
+}
+
+// known is false if the lookup failed or the platform doesn't say when the account was made
+func (a accountAge) known() bool {
+	return a.err == nil && !a.created.IsZero()
+}
+
+var (
+	actionSeverity = map[string]int{ACTION_MASK: 1, ACTION_HOLD: 2, ACTION_HIDE: 3}
+
+	linkRegex = regexp.MustCompile(`(?i)(?:https?://)?(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,24}(?:[/?#:][^\s]*)?`)
+
+	regexCache     = make(map[string]*regexp.Regexp) // nil for patterns that don't compile
+	regexCacheLock sync.Mutex
+
+	// recent messages by chatter, for the repeat rule
+	recentMessages     = make(map[string][]sentMessage)
+	recentMessagesLock sync.Mutex
+
+	accountAgeLookups     = make(map[string]AccountAgeLookup)
+	accountAgeLookupsLock sync.Mutex
+	accountAges           = make(map[string]accountAge)
+	accountAgesPending    = make(map[string][]func(accountAge)) // lookups that are running, with what to do when each is done
+	accountAgesLock       sync.Mutex
+
+	// the rules that look at the words of a message, the only ones events are checked with
+	textRules = map[string]bool{RULE_WORDS: true, RULE_PROFANITY: true, RULE_REGEX: true, RULE_LINKS: true}
+
+	decisions     []Decision
+	decisionsLock sync.Mutex
+)
+
+type sentMessage struct {
+	text string
+	at   time.Time
+}
+
+// RegisterAccountAge lets the new account rule check chatters from source
+func RegisterAccountAge(source string, fn AccountAgeLookup) {
+	accountAgeLookupsLock.Lock()
+	defer accountAgeLookupsLock.Unlock()
+	accountAgeLookups[source] = fn
+}
+
+// the rules are kept out of the channel props since those are public, and the blocked words would show how to get around them
+func rulesKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/automod_rules"
+}
+
+// Rules returns the automod rules, in the order they are shown on the bot page
+func Rules(ctx context.Context) ([]Rule, error) {
+	raw, err := redisClient.Get(ctx, rulesKey()).Result()
+	if err == redis.Nil {
+		return []Rule{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func SetRules(ctx context.Context, rules []Rule) error {
+	if rules == nil {
+		rules = []Rule{}
+	}
+	raw, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return redisClient.Set(ctx, rulesKey(), raw, 0).Err()
+}
+
+// MoveRulesFromProps moves rules saved in the old automod_rules channel prop to where Rules reads them,
+// so they aren't public anymore
+func MoveRulesFromProps(ctx context.Context) {
+	var rules []Rule
+	if err := props.GetChannelPropJSON(ctx, "automod_rules", &rules); err != nil || rules == nil {
+		return
+	}
+	if n, _ := redisClient.Exists(ctx, rulesKey()).Result(); n == 0 {
+		if err := SetRules(ctx, rules); err != nil {
+			log.Println("[automod] error moving automod_rules out of the channel props:", err)
+			return
+		}
+		log.Printf("[automod] moved %d rules out of the channel props", len(rules))
+	}
+	props.SetChannelProp(ctx, "automod_rules", nil)
+}
+
+// Filter is the multiChat chat filter. It runs the rules on every message from chatters below automod_exempt_role,
+// masks the message if it needs to, and returns the strictest action of the rules that matched. Events are only
+// checked with the rules about words, on their text and their system message.
+//
+// The new account rule doesn't wait for an account age lookup: until the age is known the message is held if the
+// rule holds, or sent otherwise, and the returned func looks it up and then releases the message or purges the chatter.
+func Filter(msg *multiChat.ChatMessage) (string, func()) {
+	if enabled, _ := props.GetChannelProp(nil, "automod_enabled").(bool); !enabled {
+		return multiChat.FILTER_ALLOW, nil
+	}
+	if chatSource.IsFromBot(*msg) {
+		return multiChat.FILTER_ALLOW, nil
+	}
+	exempt, _ := props.GetChannelProp(nil, "automod_exempt_role").(string)
+	if exempt != "" && multiChat.RoleLevel(msg.Role) >= multiChat.RoleLevel(exempt) {
+		return multiChat.FILTER_ALLOW, nil
+	}
+	rules, err := Rules(nil)
+	if err != nil {
+		log.Println("[automod] error reading the rules:", err)
+		return multiChat.FILTER_ALLOW, nil
+	}
+
+	isEvent := msg.Event != nil
+	repeats := 0
+	if !isEvent {
+		repeats = countRepeats(*msg)
+	}
+	action := multiChat.FILTER_ALLOW
+	var matches, eventMatches []match // in the text, and in an event's system message
+	found := func(checked multiChat.ChatMessage, m match, into *[]match) {
+		logDecision(checked, m)
+		*into = append(*into, m)
+		if actionSeverity[m.rule.Action] > actionSeverity[action] {
+			action = m.rule.Action
+		}
+	}
+	var waiting []Rule // new account rules for a chatter whose account age isn't known yet
+	for _, rule := range rules {
+		if _, ok := actionSeverity[rule.Action]; !ok {
+			rule.Action = ACTION_HIDE
+		}
+		if isEvent && !textRules[rule.Kind] {
+			continue
+		}
+		if rule.Kind == RULE_NEW_ACCOUNT {
+			m, ok, known := checkAccountAge(rule, *msg)
+			if !known {
+				waiting = append(waiting, rule)
+			} else if ok {
+				found(*msg, m, &matches)
+			}
+			continue
+		}
+		if m, ok := check(rule, *msg, repeats); ok {
+			found(*msg, m, &matches)
+		}
+		if isEvent {
+			system := *msg
+			system.Text = msg.Event.SystemMessage
+			if m, ok := check(rule, system, 0); ok {
+				found(system, m, &eventMatches)
+			}
+		}
+	}
+	heldForAge := false
+	for _, rule := range waiting {
+		if rule.Action == ACTION_HOLD && actionSeverity[action] < actionSeverity[ACTION_HOLD] {
+			action, heldForAge = ACTION_HOLD, true
+		}
+	}
+
+	switch action {
+	case ACTION_MASK:
+		mask(msg, matches)
+		if isEvent {
+			maskEvent(msg, eventMatches)
+		}
+	case ACTION_HOLD:
+		details := make([]string, 0, len(matches)+len(eventMatches)+1)
+		for _, m := range append(matches, eventMatches...) {
+			details = append(details, m.rule.Kind+": "+m.detail)
+		}
+		if heldForAge {
+			details = append(details, RULE_NEW_ACCOUNT+": checking the account age")
+		}
+		msg.HeldFor = strings.Join(details, ", ")
+	}
+	if len(waiting) == 0 {
+		return action, nil
+	}
+	sent := *msg
+	return action, func() {
+		lookupAccountAge(sent, func(age accountAge) { settleAccountAge(sent, waiting, heldForAge, age) })
+	}
+}
+
+// settleAccountAge applies the new account rules to a message that was sent or held before the account age was known
+func settleAccountAge(msg multiChat.ChatMessage, rules []Rule, heldForAge bool, age accountAge) {
+	action := multiChat.FILTER_ALLOW
+	for _, rule := range rules {
+		if m, ok := newAccountMatch(rule, age); ok {
+			logDecision(msg, m)
+			if actionSeverity[rule.Action] > actionSeverity[action] {
+				action = rule.Action
+			}
+		}
+	}
+	switch action {
+	case multiChat.FILTER_ALLOW:
+		if heldForAge {
+			multiChat.ReleaseHeld(msg.ID)
+		}
+	case ACTION_HOLD:
+		// it's held already, or it went out before the age was known and it's too late to hold it
+	default:
+		// it went out already, so take it back along with whatever else the chatter sent meanwhile
+		multiChat.PurgeUser(msg.Source, msg.UserID, msg.Username)
+	}
+}
+
+// check returns what the rule found in the message, if anything
+func check(rule Rule, msg multiChat.ChatMessage, repeats int) (match, bool) {
+	m := match{rule: rule}
+	text := msg.Text
+	switch rule.Kind {
+	case RULE_WORDS:
+		for _, word := range rule.Patterns {
+			word = strings.TrimSpace(word)
+			if word == "" {
+				continue
+			}
+			if spans := findWord(text, word); len(spans) > 0 {
+				m.spans = append(m.spans, spans...)
+				m.detail = word
+			}
+		}
+	case RULE_PROFANITY:
+		censored := []rune(goaway.Censor(text))
+		original := []rune(text)
+		if len(censored) == len(original) {
+			for i := range original {
+				if censored[i] != original[i] {
+					m.spans = addSpan(m.spans, i)
+				}
+			}
+		}
+		if len(m.spans) > 0 {
+			m.detail = "profanity"
+		}
+	case RULE_REGEX:
+		for _, pattern := range rule.Patterns {
+			if pattern == "" {
+				continue
+			}
+			re := compile("(?i)" + pattern)
+			if re == nil {
+				continue
+			}
+			if spans := findSpans(re, text); len(spans) > 0 {
+				m.spans = append(m.spans, spans...)
+				m.detail = pattern
+			}
+		}
+	case RULE_LINKS:
+		for _, loc := range linkRegex.FindAllStringIndex(text, -1) {
+			link := text[loc[0]:loc[1]]
+			if allowedLink(link, rule.Patterns) {
+				continue
+			}
+			m.spans = append(m.spans, [2]int{runeIndex(text, loc[0]), runeIndex(text, loc[1])})
+			m.detail = link
+		}
+	case RULE_CAPS:
+		maxPercent := orDefault(rule.Max, DEFAULT_CAPS_PERCENT)
+		minLength := orDefault(rule.MinLength, DEFAULT_CAPS_MIN_LENGTH)
+		letters, upper := 0, 0
+		for _, r := range text {
+			if unicode.IsLetter(r) {
+				letters++
+				if unicode.IsUpper(r) {
+					upper++
+				}
+			}
+		}
+		if letters >= minLength && upper*100 > letters*maxPercent {
+			return match{rule: rule, detail: fmt.Sprintf("%d%% caps", upper*100/letters)}, true
+		}
+		return m, false
+	case RULE_EMOTES:
+		count := 0
+		for _, positions := range msg.Emotes {
+			count += len(positions)
+		}
+		if count > orDefault(rule.Max, DEFAULT_MAX_EMOTES) {
+			return match{rule: rule, detail: fmt.Sprintf("%d emotes", count)}, true
+		}
+		return m, false
+	case RULE_REPEAT:
+		maxRepeats := orDefault(rule.Max, DEFAULT_MAX_REPEATS)
+		if repeats > maxRepeats {
+			return match{rule: rule, detail: fmt.Sprintf("sent %d times", repeats)}, true
+		}
+		if word, n := longestRun(text); n > maxRepeats {
+			return match{rule: rule, detail: fmt.Sprintf("%q %d times in a row", word, n)}, true
+		}
+		if r, n := longestCharRun(text); n > AUTOMOD_MAX_CHAR_RUN {
+			return match{rule: rule, detail: fmt.Sprintf("%q %d times in a row", r, n)}, true
+		}
+		return m, false
+	default:
+		return m, false
+	}
+	return m, len(m.spans) > 0
+}
+
+func orDefault(n, def int) int {
+	if n <= 0 {
+		return def
+	}
+	return n
+}
+
+// compile caches regexes, since the rules are read for every message
+func compile(pattern string) *regexp.Regexp {
+	regexCacheLock.Lock()
+	defer regexCacheLock.Unlock()
+	re, ok := regexCache[pattern]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			log.Printf("[automod] bad regex %q: %v", pattern, err)
+		}
+		regexCache[pattern] = re
+	}
+	return re
+}
+
+// findSpans returns the rune ranges of every match
+func findSpans(re *regexp.Regexp, text string) [][2]int {
+	var spans [][2]int
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		spans = append(spans, [2]int{runeIndex(text, loc[0]), runeIndex(text, loc[1])})
+	}
+	return spans
+}
+
+// findWord finds word ignoring case where it isn't part of a longer word
+func findWord(text, word string) [][2]int {
+	re := compile("(?i)" + regexp.QuoteMeta(word))
+	runes := []rune(text)
+	var spans [][2]int
+	for _, span := range findSpans(re, text) {
+		if span[0] > 0 && isWordRune(runes[span[0]-1]) || span[1] < len(runes) && isWordRune(runes[span[1]]) {
+			continue
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+func runeIndex(text string, byteIndex int) int {
+	return len([]rune(text[:byteIndex]))
+}
+
+// addSpan adds rune i, extending the last span if it ends right before i
+func addSpan(spans [][2]int, i int) [][2]int {
+	if n := len(spans); n > 0 && spans[n-1][1] == i {
+		spans[n-1][1] = i + 1
+		return spans
+	}
+	return append(spans, [2]int{i, i + 1})
+}
+
+// allowedLink checks the link's host against the allowed domains, subdomains are allowed too
+func allowedLink(link string, allowed []string) bool {
+	host := strings.ToLower(link)
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?#:"); i >= 0 {
+		host = host[:i]
+	}
+	for _, domain := range allowed {
+		domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "www.")
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// longestRun finds the word repeated the most times in a row
+func longestRun(text string) (string, int) {
+	best, bestN := "", 0
+	prev, n := "", 0
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if word == prev {
+			n++
+		} else {
+			prev, n = word, 1
+		}
+		if n > bestN {
+			best, bestN = word, n
+		}
+	}
+	return best, bestN
+}
+
+func longestCharRun(text string) (string, int) {
+	var best, prev rune
+	bestN, n := 0, 0
+	for _, r := range text {
+		if r == prev {
+			n++
+		} else {
+			prev, n = r, 1
+		}
+		if n > bestN {
+			best, bestN = r, n
+		}
+	}
+	return string(best), bestN
+}
+
+// countRepeats records the message and returns how many times the chatter sent it in AUTOMOD_REPEAT_WINDOW
+func countRepeats(msg multiChat.ChatMessage) int {
+	key := msg.Source + "/" + msg.UserID + "/" + strings.ToLower(msg.Username)
+	text := strings.ToLower(strings.TrimSpace(msg.Text))
+	now := time.Now()
+	recentMessagesLock.Lock()
+	defer recentMessagesLock.Unlock()
+	for k, sent := range recentMessages {
+		if now.Sub(sent[len(sent)-1].at) >= AUTOMOD_REPEAT_WINDOW {
+			delete(recentMessages, k)
+		}
+	}
+	sent := recentMessages[key]
+	count := 1
+	kept := sent[:0]
+	for _, s := range sent {
+		if now.Sub(s.at) >= AUTOMOD_REPEAT_WINDOW {
+			continue
+		}
+		kept = append(kept, s)
+		if s.text == text {
+			count++
+		}
+	}
+	recentMessages[key] = append(kept, sentMessage{text, now})
+	return count
+}
+
+// newAccountMatch checks the rule against an account age that was looked up
+func newAccountMatch(rule Rule, age accountAge) (match, bool) {
+	if !age.known() {
+		return match{}, false
+	}
+	days := time.Since(age.created)
+	if days < time.Duration(orDefault(rule.Max, DEFAULT_MIN_ACCOUNT_AGE))*24*time.Hour {
+		return match{rule: rule, detail: fmt.Sprintf("account is %d days old", int(days.Hours()/24))}, true
+	}
+	return match{}, false
+}
+
+// checkAccountAge checks the rule if the chatter's account age is known already, known is false if it still has
+// to be looked up. Chatters from platforms where the age can't be looked up never match.
+func checkAccountAge(rule Rule, msg multiChat.ChatMessage) (m match, ok, known bool) {
+	if _, can := accountAgeLookupFor(msg); !can {
+		return m, false, true
+	}
+	accountAgesLock.Lock()
+	cached, found := accountAges[accountAgeKey(msg)]
+	accountAgesLock.Unlock()
+	if !found || (cached.err != nil && time.Since(cached.at) >= ACCOUNT_AGE_RETRY_TIME) {
+		return m, false, false
+	}
+	m, ok = newAccountMatch(rule, cached)
+	return m, ok, true
+}
+
+func accountAgeLookupFor(msg multiChat.ChatMessage) (AccountAgeLookup, bool) {
+	accountAgeLookupsLock.Lock()
+	defer accountAgeLookupsLock.Unlock()
+	lookup, ok := accountAgeLookups[msg.Source]
+	return lookup, ok && msg.UserID != ""
+}
+
+func accountAgeKey(msg multiChat.ChatMessage) string {
+	return msg.Source + "/" + msg.UserID
+}
+
+// lookupAccountAge looks up the chatter's account age in the background and calls done with it. A chatter who
+// sends several messages meanwhile is only looked up once, and the age is remembered for their later messages.
+func lookupAccountAge(msg multiChat.ChatMessage, done func(accountAge)) {
+	lookup, ok := accountAgeLookupFor(msg)
+	if !ok {
+		return
+	}
+	key := accountAgeKey(msg)
+	accountAgesLock.Lock()
+	waiting, running := accountAgesPending[key]
+	accountAgesPending[key] = append(waiting, done)
+	accountAgesLock.Unlock()
+	if running {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ACCOUNT_AGE_TIMEOUT)
+		created, err := lookup(ctx, msg.UserID, msg.Username)
+		cancel()
+		if err != nil {
+			log.Printf("[automod] [%s] account age lookup for %s failed: %v", msg.Source, msg.Username, err)
+		}
+		age := accountAge{created: created, err: err, at: time.Now()}
+		accountAgesLock.Lock()
+		if len(accountAges) >= ACCOUNT_AGE_CACHE_ENTRIES {
+			accountAges = make(map[string]accountAge)
+		}
+		accountAges[key] = age
+		callbacks := accountAgesPending[key]
+		delete(accountAgesPending, key)
+		accountAgesLock.Unlock()
+		for _, fn := range callbacks {
+			fn(age)
+		}
+	}()
+}
+
+// mask changes the message the way the rules with ACTION_MASK asked for
+func mask(msg *multiChat.ChatMessage, matches []match) {
+	runes := []rune(msg.Text)
+	var spans [][2]int
+	for _, m := range matches {
+		if m.rule.Action != ACTION_MASK {
+			continue
+		}
+		switch {
+		case m.rule.Kind == RULE_CAPS:
+			// rune by rune, strings.ToLower can change how many runes there are and the spans would be off
+			for i, r := range runes {
+				runes[i] = unicode.ToLower(r)
+			}
+		case m.rule.Kind == RULE_EMOTES:
+			msg.Emotes = make(map[string][]string)
+		case len(m.spans) > 0:
+			spans = append(spans, m.spans...)
+		default:
+			msg.Text = AUTOMOD_MASKED_TEXT
+			msg.Emotes = make(map[string][]string)
+			return
+		}
+	}
+	for _, span := range spans {
+		for i := span[0]; i < span[1] && i < len(runes); i++ {
+			if !unicode.IsSpace(runes[i]) {
+				runes[i] = '*'
+			}
+		}
+	}
+	msg.Text = string(runes)
+	// emotes that were masked would still show as images
+	for url, positions := range msg.Emotes {
+		kept := positions[:0]
+		for _, pos := range positions {
+			if !overlaps(pos, spans) {
+				kept = append(kept, pos)
+			}
+		}
+		if len(kept) == 0 {
+			delete(msg.Emotes, url)
+		} else {
+			msg.Emotes[url] = kept
+		}
+	}
+}
+
+// maskEvent masks an event's system message the way the rules with ACTION_MASK asked for
+func maskEvent(msg *multiChat.ChatMessage, matches []match) {
+	event := *msg.Event
+	system := multiChat.ChatMessage{Text: event.SystemMessage}
+	mask(&system, matches)
+	event.SystemMessage = system.Text
+	// the event can be shared with whoever sent it
+	msg.Event = &event
+}
+
+// overlaps checks an emote position like "3-7" (inclusive) against the masked spans
+func overlaps(pos string, spans [][2]int) bool {
+	startStr, endStr, _ := strings.Cut(pos, "-")
+	start, err1 := strconv.Atoi(startStr)
+	end, err2 := strconv.Atoi(endStr)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	for _, span := range spans {
+		if start < span[1] && end >= span[0] {
+			return true
+		}
+	}
+	return false
+}
+
+func logDecision(msg multiChat.ChatMessage, m match) {
+	log.Printf("[automod] [%s] %s %s: %s (%s) %q", msg.Source, m.rule.Action, msg.Username, m.rule.Kind, m.detail, msg.Text)
+	decisionsLock.Lock()
+	defer decisionsLock.Unlock()
+	decisions = append(decisions, Decision{
+		At:       time.Now(),
+		Source:   msg.Source,
+		Username: msg.Username,
+		Text:     msg.Text,
+		Rule:     m.rule.Kind,
+		Detail:   m.detail,
+		Action:   m.rule.Action,
+	})
+	if len(decisions) > AUTOMOD_LOG_SIZE {
+		decisions = decisions[len(decisions)-AUTOMOD_LOG_SIZE:]
+	}
+}
+
+// Decisions lists the latest messages the rules matched, newest first
+func Decisions() []Decision {
+	decisionsLock.Lock()
+	defer decisionsLock.Unlock()
+	out := make([]Decision, len(decisions))
+	for i, d := range decisions {
+		out[len(decisions)-1-i] = d
+	}
+	return out
+}
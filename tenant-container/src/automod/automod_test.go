@@ -0,0 +1,271 @@
+package automod
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   Rule
+		text   string
+		emotes map[string][]string
+		ok     bool
+		spans  [][2]int
+	}{
+		{"word", Rule{Kind: RULE_WORDS, Patterns: []string{"bad"}}, "that was BAD!", nil, true, [][2]int{{9, 12}}},
+		{"word inside a longer word", Rule{Kind: RULE_WORDS, Patterns: []string{"bad"}}, "a badger", nil, false, nil},
+		{"word after emoji", Rule{Kind: RULE_WORDS, Patterns: []string{"bad"}}, "😀 bad", nil, true, [][2]int{{2, 5}}},
+		{"regex", Rule{Kind: RULE_REGEX, Patterns: []string{"fo+"}}, "FOOO bar", nil, true, [][2]int{{0, 4}}},
+		{"bad regex", Rule{Kind: RULE_REGEX, Patterns: []string{"("}}, "(", nil, false, nil},
+		{"profanity", Rule{Kind: RULE_PROFANITY}, "what the fuck", nil, true, [][2]int{{9, 13}}},
+		{"allowed link", Rule{Kind: RULE_LINKS, Patterns: []string{"youtube.com"}}, "see https://www.youtube.com/watch?v=x", nil, false, nil},
+		{"link", Rule{Kind: RULE_LINKS, Patterns: []string{"youtube.com"}}, "go to evil.example.com/x now", nil, true, [][2]int{{6, 24}}},
+		{"caps", Rule{Kind: RULE_CAPS}, "HELLO THERE EVERYONE", nil, true, nil},
+		{"short caps", Rule{Kind: RULE_CAPS}, "HI THERE", nil, false, nil},
+		{"some caps", Rule{Kind: RULE_CAPS}, "Hello There Everyone", nil, false, nil},
+		{"emotes", Rule{Kind: RULE_EMOTES, Max: 2}, "a b c", map[string][]string{"x": {"0-0", "2-2"}, "y": {"4-4"}}, true, nil},
+		{"few emotes", Rule{Kind: RULE_EMOTES, Max: 3}, "a b c", map[string][]string{"x": {"0-0", "2-2"}, "y": {"4-4"}}, false, nil},
+		{"repeated word", Rule{Kind: RULE_REPEAT}, "spam Spam spam SPAM", nil, true, nil},
+		{"repeated character", Rule{Kind: RULE_REPEAT}, "n" + strings.Repeat("o", AUTOMOD_MAX_CHAR_RUN+1), nil, true, nil},
+		{"no repeats", Rule{Kind: RULE_REPEAT}, "spam spam spam", nil, false, nil},
+		{"new account isn't checked here", Rule{Kind: RULE_NEW_ACCOUNT}, "hi", nil, false, nil},
+	}
+	for _, test := range tests {
+		m, ok := check(test.rule, multiChat.ChatMessage{Text: test.text, Emotes: test.emotes}, 1)
+		if ok != test.ok {
+			t.Errorf("%s: matched %v (%q), want %v", test.name, ok, m.detail, test.ok)
+			continue
+		}
+		if !reflect.DeepEqual(m.spans, test.spans) {
+			t.Errorf("%s: spans %v, want %v", test.name, m.spans, test.spans)
+		}
+	}
+	if _, ok := check(Rule{Kind: RULE_REPEAT}, multiChat.ChatMessage{Text: "hi"}, DEFAULT_MAX_REPEATS+1); !ok {
+		t.Error("a message sent too many times didn't match")
+	}
+}
+
+func TestMask(t *testing.T) {
+	words := func(patterns ...string) Rule { return Rule{Kind: RULE_WORDS, Action: ACTION_MASK, Patterns: patterns} }
+	tests := []struct {
+		name       string
+		rules      []Rule
+		text       string
+		emotes     map[string][]string
+		wantText   string
+		wantEmotes map[string][]string
+	}{
+		{"word", []Rule{words("dummy")}, "you are a Dummy ok", nil, "you are a ***** ok", map[string][]string{}},
+		{"phrase keeps spaces", []Rule{words("go away")}, "just go away", nil, "just ** ****", map[string][]string{}},
+		// İ lowercases to two runes with strings.ToLower, the span after it must still land on "great"
+		{"caps and a word", []Rule{{Kind: RULE_CAPS, Action: ACTION_MASK}, words("great")}, "İSTANBUL İS GREAT", nil,
+			"istanbul is *****", map[string][]string{}},
+		{"emote in a masked word", []Rule{words("bad")}, "hi bad Kappa", map[string][]string{"bad.png": {"3-5"}, "kappa.png": {"7-11"}},
+			"hi *** Kappa", map[string][]string{"kappa.png": {"7-11"}}},
+		{"too many emotes", []Rule{{Kind: RULE_EMOTES, Action: ACTION_MASK, Max: 1}}, "Kappa Kappa", map[string][]string{"kappa.png": {"0-4", "6-10"}},
+			"Kappa Kappa", map[string][]string{}},
+		{"nothing in particular", []Rule{{Kind: RULE_REPEAT, Action: ACTION_MASK}}, "spam spam spam spam", map[string][]string{"x": {"0-3"}},
+			AUTOMOD_MASKED_TEXT, map[string][]string{}},
+		{"held words aren't masked", []Rule{{Kind: RULE_WORDS, Action: ACTION_HOLD, Patterns: []string{"bad"}}}, "bad", nil,
+			"bad", map[string][]string{}},
+	}
+	for _, test := range tests {
+		msg := multiChat.ChatMessage{Text: test.text, Emotes: test.emotes}
+		if msg.Emotes == nil {
+			msg.Emotes = make(map[string][]string)
+		}
+		var matches []match
+		for _, rule := range test.rules {
+			if m, ok := check(rule, msg, 1); ok {
+				matches = append(matches, m)
+			}
+		}
+		mask(&msg, matches)
+		if msg.Text != test.wantText || !reflect.DeepEqual(msg.Emotes, test.wantEmotes) {
+			t.Errorf("%s: got %q %v, want %q %v", test.name, msg.Text, msg.Emotes, test.wantText, test.wantEmotes)
+		}
+	}
+}
+
+func TestAllowedLink(t *testing.T) {
+	tests := []struct {
+		link    string
+		allowed []string
+		want    bool
+	}{
+		{"https://sub.example.com/path", []string{"example.com"}, true},
+		{"EXAMPLE.COM:8080", []string{" Example.com "}, true},
+		{"www.example.com", []string{"www.example.com"}, true},
+		{"example.com.evil.net", []string{"example.com"}, false},
+		{"notexample.com", []string{"example.com"}, false},
+		{"http://evil.net/?example.com", []string{"example.com"}, false},
+		{"example.com", []string{""}, false},
+	}
+	for _, test := range tests {
+		if got := allowedLink(test.link, test.allowed); got != test.want {
+			t.Errorf("allowedLink(%q, %q) = %v, want %v", test.link, test.allowed, got, test.want)
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	spans := [][2]int{{3, 6}}
+	tests := []struct {
+		pos  string
+		want bool
+	}{
+		{"0-2", false},
+		{"0-3", true},
+		{"5-9", true},
+		{"6-9", false},
+		{"4-4", true},
+		{"bad", false},
+	}
+	for _, test := range tests {
+		if got := overlaps(test.pos, spans); got != test.want {
+			t.Errorf("overlaps(%q) = %v, want %v", test.pos, got, test.want)
+		}
+	}
+}
+
+func setup(t *testing.T, rules ...Rule) {
+	mr := miniredis.RunT(t)
+	env.STATE_DB_URL = "redis://" + mr.Addr()
+	env.TWITCH_CHANNEL = "test"
+	redisClient.Init()
+	props.SetChannelProp(nil, "automod_enabled", true)
+	if err := SetRules(nil, rules); err != nil {
+		t.Fatal(err)
+	}
+	multiChat.SetChatFilter(Filter)
+	t.Cleanup(func() {
+		multiChat.SetChatFilter(nil)
+		for _, msg := range multiChat.HeldMessages() {
+			multiChat.RejectHeld(msg.ID)
+		}
+		accountAgesLock.Lock()
+		accountAges = make(map[string]accountAge)
+		accountAgesLock.Unlock()
+		recentMessagesLock.Lock()
+		recentMessages = make(map[string][]sentMessage)
+		recentMessagesLock.Unlock()
+	})
+}
+
+func history(t *testing.T) []string {
+	msgs, err := multiChat.GetChatHistory(context.Background(), "", "", multiChat.CHAT_HISTORY_MAX_PAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{}
+	for _, msg := range msgs {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+// eventually waits for cond, the account age lookups run in the background
+func eventually(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+	}
+}
+
+func TestFilterAccountAge(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  string
+		created time.Time
+		history []string // once the age is known
+	}{
+		{"hold old account", ACTION_HOLD, time.Now().AddDate(-1, 0, 0), []string{"hello"}},
+		{"hold new account", ACTION_HOLD, time.Now(), []string{}},
+		{"hide old account", ACTION_HIDE, time.Now().AddDate(-1, 0, 0), []string{"hello"}},
+		{"hide new account", ACTION_HIDE, time.Now(), []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setup(t, Rule{Kind: RULE_NEW_ACCOUNT, Action: test.action})
+			source := "agetest"
+			created := make(chan time.Time)
+			RegisterAccountAge(source, func(ctx context.Context, userID, username string) (time.Time, error) {
+				return <-created, nil
+			})
+			young := len(test.history) == 0
+
+			multiChat.SendChatMessage(multiChat.ChatMessage{Source: source, UserID: "42", Username: "newbie", Text: "hello"})
+			held := multiChat.HeldMessages()
+			if test.action == ACTION_HOLD {
+				if len(held) != 1 || !strings.Contains(held[0].HeldFor, "checking the account age") {
+					t.Fatalf("held = %+v, want it held for the age check", held)
+				}
+				if got := history(t); len(got) != 0 {
+					t.Fatalf("history = %q before the age is known", got)
+				}
+			} else {
+				if len(held) != 0 {
+					t.Fatalf("held = %+v, a hide rule shouldn't hold it", held)
+				}
+				if got := history(t); !slices.Equal(got, []string{"hello"}) {
+					t.Fatalf("history = %q, want it sent before the age is known", got)
+				}
+			}
+
+			created <- test.created
+			if test.action == ACTION_HOLD && young {
+				// it stays held for a mod, give a wrong release the time to happen
+				time.Sleep(50 * time.Millisecond)
+				if held := multiChat.HeldMessages(); len(held) != 1 {
+					t.Fatalf("held = %+v, want it still held", held)
+				}
+			}
+			eventually(t, "the age lookup didn't settle the message", func() bool {
+				return slices.Equal(history(t), test.history)
+			})
+			for _, msg := range multiChat.HeldMessages() {
+				multiChat.RejectHeld(msg.ID)
+			}
+
+			// the age is remembered, the next message is decided right away
+			multiChat.SendChatMessage(multiChat.ChatMessage{Source: source, UserID: "42", Username: "newbie", Text: "again"})
+			if got := history(t); slices.Contains(got, "again") == young {
+				t.Errorf("history = %q after the age was known", got)
+			}
+		})
+	}
+}
+
+func TestFilterEvent(t *testing.T) {
+	setup(t,
+		Rule{Kind: RULE_WORDS, Action: ACTION_MASK, Patterns: []string{"jerk"}},
+		Rule{Kind: RULE_CAPS, Action: ACTION_HIDE})
+	event := &multiChat.Event{Kind: multiChat.EVENT_FOLLOW, User: "jerk", SystemMessage: "jerk followed"}
+	msg := multiChat.ChatMessage{Source: "test", Username: "jerk", Text: "HELLO EVERYONE, A JERK IS HERE", Event: event}
+
+	action, after := Filter(&msg)
+	if action != ACTION_MASK || after != nil {
+		t.Fatalf("got %q, want %q with only the word rule checked", action, ACTION_MASK)
+	}
+	if msg.Event.SystemMessage != "**** followed" || msg.Text != "HELLO EVERYONE, A **** IS HERE" {
+		t.Errorf("got %q and %q", msg.Event.SystemMessage, msg.Text)
+	}
+	if event.SystemMessage != "jerk followed" {
+		t.Errorf("the original event was changed to %q", event.SystemMessage)
+	}
+}
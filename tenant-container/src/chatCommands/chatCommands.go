@@ -0,0 +1,214 @@
+package chatCommands
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	goaway "github.com/TwiN/go-away"
+
+	"multibot/common/src/env"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/greetz"
+	"multibot/tenant-container/src/identity"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	HELP_MAX_LENGTH = 450 //keep !help under the shortest platform message limit
+)
+
+// builtin is a command that is part of the bot, custom commands can't use these names
+type builtin struct {
+	name        string
+	description string
+	permission  string
+	run         func(msg multiChat.ChatMessage, args string, reply func(string)) bool // returns shouldReply
+}
+
+var builtins []builtin
+
+func init() {
+	// assigned in init since !help refers back to the list
+	builtins = []builtin{
+		{"help", "", multiChat.ROLE_EVERYONE, runHelp},
+		{"commands", "", multiChat.ROLE_EVERYONE, runHelp},
+		{"nick", "set your nickname", multiChat.ROLE_EVERYONE, runNick},
+		{"botpage", "link to the page with nicknames and other info", multiChat.ROLE_EVERYONE, func(msg multiChat.ChatMessage, args string, reply func(string)) bool {
+			reply(fmt.Sprintf("see the nicknames and other bot info at %s/%s", env.BASE_URL, env.TWITCH_CHANNEL))
+			return true
+		}},
+		{"multichat", "link to combined chat", multiChat.ROLE_EVERYONE, func(msg multiChat.ChatMessage, args string, reply func(string)) bool {
+			reply(fmt.Sprintf("see the multichat at %s/%s/chat (change font and show/hide options on !botpage)", env.BASE_URL, env.TWITCH_CHANNEL))
+			return true
+		}},
+		{"clear", "clear the multichat", multiChat.ROLE_MOD, func(msg multiChat.ChatMessage, args string, reply func(string)) bool {
+			multiChat.ClearChat()
+			return false
+		}},
+		{"link", "link your accounts, see !botpage", multiChat.ROLE_EVERYONE, runLink},
+		{"unlink", "", multiChat.ROLE_EVERYONE, func(msg multiChat.ChatMessage, args string, reply func(string)) bool {
+			if msg.Unverified {
+				reply(fmt.Sprintf("@%s %v", msg.Username, identity.ErrUnverified))
+			} else if identity.UnlinkChatter(nil, msg) {
+				reply(fmt.Sprintf("@%s unlinked from %s", msg.Username, msg.Viewer))
+			} else {
+				reply(fmt.Sprintf("@%s this account isn't linked", msg.Username))
+			}
+			return true
+		}},
+	}
+}
+
+func getBuiltin(name string) (builtin, bool) {
+	for _, b := range builtins {
+		if b.name == name {
+			return b, true
+		}
+	}
+	return builtin{}, false
+}
+
+// IsBuiltin reports whether name (without the "!") is one of the bot's own commands
+func IsBuiltin(name string) bool {
+	_, ok := getBuiltin(name)
+	return ok
+}
+
+// HandleChat is a multiChat chat listener that runs commands and then greets the chatter
+func HandleChat(msg multiChat.ChatMessage) {
+	// platforms that post as the channel owner echo the bot's messages back
+	if chatSource.IsFromBot(msg) {
+		return
+	}
+	validCommand, shouldReply := Handle(msg)
+	greetz.Greet(msg, validCommand, shouldReply)
+}
+
+// Handle runs the built-in and custom commands for a message from any platform and replies on the same platform.
+// It returns whether the message was a valid command and whether the bot replied to it (for greetz).
+func Handle(msg multiChat.ChatMessage) (bool, bool) {
+	command := strings.ReplaceAll(msg.Text, " 󠀀", " ")
+	command = strings.TrimSpace(command)
+	if !strings.HasPrefix(command, "!") {
+		return false, true
+	}
+	name, args, _ := strings.Cut(command[1:], " ")
+	name = strings.ToLower(name)
+	args = strings.TrimSpace(args)
+
+	reply := func(text string) {
+		if err := chatSource.Say(msg.Source, text); err != nil {
+			log.Printf("[%s] reply error: %v", msg.Source, err)
+		}
+	}
+
+	if b, ok := getBuiltin(name); ok {
+		if !HasPermission(msg, b.permission) {
+			reply(fmt.Sprintf("@%s you do not have permission to use !%s", msg.Username, name))
+			return true, true
+		}
+		return true, b.run(msg, args, reply)
+	}
+	return runCustom(msg, name, args, reply)
+}
+
+// HasPermission reports whether the user's role is at least the given one. On twitch,
+// the channel owner and the super admin count as the broadcaster even without the badge.
+func HasPermission(msg multiChat.ChatMessage, permission string) bool {
+	role := msg.Role
+	if msg.Source == "twitch" && (strings.EqualFold(msg.Username, env.TWITCH_CHANNEL) || strings.EqualFold(msg.Username, env.TWITCH_SUPER_ADMIN_USERNAME)) {
+		role = multiChat.ROLE_BROADCASTER
+	}
+	return multiChat.RoleLevel(role) >= multiChat.RoleLevel(permission)
+}
+
+// runHelp lists the commands the user is allowed to run, built-ins first
+func runHelp(msg multiChat.ChatMessage, args string, reply func(string)) bool {
+	parts := []string{}
+	for _, b := range builtins {
+		if b.description == "" || !HasPermission(msg, b.permission) {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("!%s - %s", b.name, b.description))
+	}
+	custom := []string{}
+	for _, c := range ListCommands() {
+		if HasPermission(msg, c.Permission) {
+			custom = append(custom, "!"+c.Name)
+		}
+	}
+	if len(custom) > 0 {
+		parts = append(parts, strings.Join(custom, ", "))
+	}
+	text := "commands: " + strings.Join(parts, "; ")
+	if runes := []rune(text); len(runes) > HELP_MAX_LENGTH {
+		text = string(runes[:HELP_MAX_LENGTH-3]) + "..."
+	}
+	reply(text)
+	return true
+}
+
+func runLink(msg multiChat.ChatMessage, code string, reply func(string)) bool {
+	if code == "" {
+		reply(fmt.Sprintf("@%s log in on %s/%s to get a code, then type !link CODE here", msg.Username, env.BASE_URL, env.TWITCH_CHANNEL))
+		return true
+	}
+	viewer, err := identity.Redeem(nil, code, msg)
+	if err != nil {
+		reply(fmt.Sprintf("@%s %v", msg.Username, err))
+		return true
+	}
+	reply(fmt.Sprintf("@%s linked to %s, your nickname and greetz will follow you here", msg.Username, viewer))
+	return true
+}
+
+// runNick sets the nickname on the chatter's viewer record, so it also applies to their linked accounts
+func runNick(msg multiChat.ChatMessage, nickname string, reply func(string)) bool {
+	username := msg.Username
+	// the nickname would stay with the name for whoever takes it next
+	if msg.Unverified {
+		reply(fmt.Sprintf("@%s anyone can take this name, register it first to set a nickname", username))
+		return true
+	}
+	viewer := msg.ViewerKey()
+	if nickname == "" {
+		curr := props.GetViewerProp(nil, viewer, "nickname")
+		if curr != nil {
+			props.SetViewerProp(nil, viewer, "nickname", nil)
+			reply(fmt.Sprintf("@%s removed nickname, sad to see you go", username))
+		} else {
+			reply(fmt.Sprintf("@%s please provide a nickname, e.g. !nick name", username))
+		}
+		return true
+	}
+	maxLen := props.GetChannelPropAs(nil, "max_nickname_length", 0)
+
+	if goaway.IsProfane(nickname) {
+		reply(fmt.Sprintf("@%s no profanity allowed in nickname, choose a different one", username))
+	} else if props.GetViewerProp(nil, viewer, "nickname") == nickname {
+		reply(fmt.Sprintf("@%s you already have that nickname", username))
+	} else if len(nickname) > maxLen {
+		reply(fmt.Sprintf("@%s nickname \"%s\" is too long, max length = %d", username, nickname, maxLen))
+	} else if isNicknameTaken(nickname) {
+		reply(fmt.Sprintf("@%s nickname \"%s\" is already taken, see !botpage for the list", username, nickname))
+	} else {
+		props.SetViewerProp(nil, viewer, "nickname", nickname)
+		reply(fmt.Sprintf("@%s set nickname to %s", username, nickname))
+	}
+	return true
+}
+
+// isNicknameTaken checks if any other user is using the given nickname
+func isNicknameTaken(nick string) bool {
+	viewers := props.ListViewers(nil)
+	for _, v := range viewers {
+		val := props.GetViewerProp(nil, v, "nickname")
+		if valStr, ok := val.(string); ok && valStr == nick {
+			return true
+		}
+	}
+	return false
+}
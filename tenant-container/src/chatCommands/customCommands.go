@@ -0,0 +1,177 @@
+package chatCommands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+
+	"multibot/tenant-container/src/multiChat"
+)
+
+const (
+	MAX_RESPONSE_LENGTH     = 500
+	COOLDOWN_PRUNE_INTERVAL = 1 * time.Minute //how often cooldowns that ran out are forgotten
+)
+
+var (
+	commandNameRegex = regexp.MustCompile(`^[a-z0-9_]{1,25}$`)
+
+	// when each command can run again, overall and per user (keyed by command + source + username)
+	cooldowns         = make(map[string]time.Time)
+	userCooldowns     = make(map[string]time.Time)
+	cooldownsLock     sync.Mutex
+	lastCooldownPrune time.Time
+)
+
+// Command is a per-channel command the streamer sets up on the bot page, e.g. !discord
+type Command struct {
+	Name               string `json:"name"`     // without the "!"
+	Response           string `json:"response"` // template with {user}, {nickname}, {channel}, {args} and {source}
+	Permission         string `json:"permission"`
+	GlobalCooldownSecs int    `json:"global_cooldown_secs"`
+	UserCooldownSecs   int    `json:"user_cooldown_secs"`
+}
+
+func commandsKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/commands"
+}
+
+// ListCommands returns the custom commands sorted by name
+func ListCommands() []Command {
+	raw, err := redisClient.HGetAll(nil, commandsKey()).Result()
+	if err != nil {
+		log.Println("[commands] list error:", err)
+		return nil
+	}
+	commands := make([]Command, 0, len(raw))
+	for name, val := range raw {
+		var c Command
+		if err := json.Unmarshal([]byte(val), &c); err != nil {
+			log.Printf("[commands] skipping bad command %s: %v", name, err)
+			continue
+		}
+		commands = append(commands, c)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+func GetCommand(ctx context.Context, name string) (Command, bool) {
+	val, err := redisClient.HGet(ctx, commandsKey(), name).Result()
+	if err != nil {
+		return Command{}, false
+	}
+	var c Command
+	if err := json.Unmarshal([]byte(val), &c); err != nil {
+		return Command{}, false
+	}
+	return c, true
+}
+
+// SaveCommand validates and stores a command, replacing any existing one with the same name
+func SaveCommand(ctx context.Context, c Command) (Command, error) {
+	c.Name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(c.Name), "!"))
+	c.Response = strings.TrimSpace(c.Response)
+	if c.Permission == "" {
+		c.Permission = multiChat.ROLE_EVERYONE
+	}
+	switch {
+	case !commandNameRegex.MatchString(c.Name):
+		return c, fmt.Errorf("command name must be 1-25 lowercase letters, numbers or _")
+	case IsBuiltin(c.Name):
+		return c, fmt.Errorf("!%s is a built-in command", c.Name)
+	case c.Response == "":
+		return c, fmt.Errorf("response is empty")
+	case len([]rune(c.Response)) > MAX_RESPONSE_LENGTH:
+		return c, fmt.Errorf("response is too long, max length = %d", MAX_RESPONSE_LENGTH)
+	case multiChat.RoleLevel(c.Permission) < 0:
+		return c, fmt.Errorf("unknown permission %q", c.Permission)
+	case c.GlobalCooldownSecs < 0 || c.UserCooldownSecs < 0:
+		return c, fmt.Errorf("cooldowns can't be negative")
+	}
+	raw, _ := json.Marshal(c)
+	if err := redisClient.HSet(ctx, commandsKey(), c.Name, string(raw)).Err(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func DeleteCommand(ctx context.Context, name string) {
+	redisClient.HDel(ctx, commandsKey(), strings.ToLower(name))
+}
+
+// runCustom runs a custom command if one exists with that name
+func runCustom(msg multiChat.ChatMessage, name string, args string, reply func(string)) (bool, bool) {
+	c, ok := GetCommand(nil, name)
+	if !ok {
+		return false, true
+	}
+	if !HasPermission(msg, c.Permission) {
+		reply(fmt.Sprintf("@%s you do not have permission to use !%s", msg.Username, name))
+		return true, true
+	}
+	// mods skip cooldowns, and cooldowns don't answer so they can't be used to spam
+	if !HasPermission(msg, multiChat.ROLE_MOD) && !checkCooldowns(c, msg) {
+		log.Printf("[commands] !%s is on cooldown for %s", name, msg.Username)
+		return true, false
+	}
+	reply(renderResponse(c.Response, msg, args))
+	return true, true
+}
+
+// checkCooldowns returns false if the command is on cooldown, otherwise it records this run
+func checkCooldowns(c Command, msg multiChat.ChatMessage) bool {
+	now := time.Now()
+	userKey := c.Name + "/" + msg.Source + "/" + strings.ToLower(msg.Username)
+	cooldownsLock.Lock()
+	defer cooldownsLock.Unlock()
+	if now.Sub(lastCooldownPrune) > COOLDOWN_PRUNE_INTERVAL {
+		pruneCooldowns(cooldowns, now)
+		pruneCooldowns(userCooldowns, now)
+		lastCooldownPrune = now
+	}
+	if until, ok := cooldowns[c.Name]; ok && now.Before(until) {
+		return false
+	}
+	if until, ok := userCooldowns[userKey]; ok && now.Before(until) {
+		return false
+	}
+	if c.GlobalCooldownSecs > 0 {
+		cooldowns[c.Name] = now.Add(time.Duration(c.GlobalCooldownSecs) * time.Second)
+	}
+	if c.UserCooldownSecs > 0 {
+		userCooldowns[userKey] = now.Add(time.Duration(c.UserCooldownSecs) * time.Second)
+	}
+	return true
+}
+
+func pruneCooldowns(m map[string]time.Time, now time.Time) {
+	for key, until := range m {
+		if !now.Before(until) {
+			delete(m, key)
+		}
+	}
+}
+
+func renderResponse(template string, msg multiChat.ChatMessage, args string) string {
+	nickname := msg.Nickname
+	if nickname == "" {
+		nickname = msg.Username
+	}
+	return strings.NewReplacer(
+		"{user}", msg.Username,
+		"{nickname}", nickname,
+		"{channel}", env.TWITCH_CHANNEL,
+		"{args}", args,
+		"{source}", msg.Source,
+	).Replace(template)
+}
@@ -0,0 +1,326 @@
+package chatSource
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"multibot/common/src/env"
+
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	SUPERVISOR_INTERVAL = 1 * time.Minute        //how often to check each source and reconnect it if needed
+	SAY_LATER_DELAY     = 500 * time.Millisecond //time to wait so a connect notice comes after the disconnect notice
+	ECHO_WINDOW         = 30 * time.Second       //how long a message the bot sent is recognized when the platform echoes it back
+)
+
+// ErrNotConfigured is returned from Start when the channel hasn't set up this source, it isn't logged as an error.
+var ErrNotConfigured = errors.New("not configured")
+
+// ChatSource is a platform connector (twitch, youtube, owncast, kick, ...) whose lifecycle is owned by the supervisor.
+// Start should connect and return once the connection is up (or has failed), leaving any read loop running in the background.
+// Stop should disconnect and be safe to call when already disconnected.
+type ChatSource interface {
+	Name() string
+	Start() error
+	Stop()
+	Status() Status
+}
+
+// Sayer is implemented by sources that can post messages into their own chat
+type Sayer interface {
+	Say(text string) error
+}
+
+// Moderator is implemented by sources that can delete messages and ban users on the platform, usually with the
+// channel owner's login. msg is the message being moderated, for a ban only its UserID, Username and Stream are needed.
+type Moderator interface {
+	DeleteMessage(msg multiChat.ChatMessage) error
+	// Ban bans the user for duration, or for good if duration is 0
+	Ban(msg multiChat.ChatMessage, duration time.Duration, reason string) error
+}
+
+// Status is what a source reports about itself, the supervisor adds its own bookkeeping on top.
+type Status struct {
+	Connected bool           `json:"connected"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+type supervised struct {
+	src         ChatSource
+	mu          sync.Mutex // serializes Start/Stop for this source
+	started     bool       // whether the supervisor has started this source and not stopped it since
+	lastError   string
+	lastErrorAt time.Time
+	lastStarted time.Time
+	starts      int
+}
+
+var (
+	sources     = make(map[string]*supervised)
+	sourceOrder []string
+	sourcesLock sync.Mutex
+
+	// recentlySaid stores when the bot last sent each message, keyed by source name + text
+	recentlySaid     = make(map[string]time.Time)
+	recentlySaidLock sync.Mutex
+)
+
+// Register adds a source to the supervisor. The source is restarted whenever
+// one of the given channel props changes, and all sources restart when "enabled" changes.
+func Register(src ChatSource, watchedProps ...string) {
+	s := &supervised{src: src}
+	sourcesLock.Lock()
+	sources[src.Name()] = s
+	sourceOrder = append(sourceOrder, src.Name())
+	sourcesLock.Unlock()
+
+	for _, propName := range watchedProps {
+		props.AddChannelPropListener(propName, func(oldValue, newValue interface{}) {
+			log.Printf("[%s] %s changed from %v to %v", src.Name(), propName, oldValue, newValue)
+			go s.restart()
+		})
+	}
+}
+
+// Run starts a background loop for each registered source that keeps it connected while the bot is enabled.
+func Run() {
+	props.AddChannelPropListener("enabled", func(oldValue, newValue interface{}) {
+		log.Printf("enabled changed from %v to %v", oldValue, newValue)
+		RestartAll()
+	})
+	for _, s := range list() {
+		go func(s *supervised) {
+			for {
+				s.ensure()
+				time.Sleep(SUPERVISOR_INTERVAL)
+			}
+		}(s)
+	}
+}
+
+// Restart stops and starts the named source, returns false if there is no such source.
+func Restart(name string) bool {
+	sourcesLock.Lock()
+	s, ok := sources[name]
+	sourcesLock.Unlock()
+	if !ok {
+		return false
+	}
+	go s.restart()
+	return true
+}
+
+func RestartAll() {
+	for _, s := range list() {
+		go s.restart()
+	}
+}
+
+// Get returns the named source, e.g. so other packages can check if it is connected.
+func Get(name string) (ChatSource, bool) {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+	s, ok := sources[name]
+	if !ok {
+		return nil, false
+	}
+	return s.src, true
+}
+
+// Say posts a message into the named source's chat
+func Say(name, text string) error {
+	src, ok := Get(name)
+	if !ok {
+		return fmt.Errorf("unknown source %q", name)
+	}
+	sayer, ok := src.(Sayer)
+	if !ok {
+		return fmt.Errorf("source %q can't send messages", name)
+	}
+	// record it first, the platform can echo it back before Say returns
+	at := recordSaid(name, text)
+	if err := sayer.Say(text); err != nil {
+		forgetSaid(name, text, at)
+		return err
+	}
+	return nil
+}
+
+// Moderate returns the named source if it can moderate its chat
+func Moderate(name string) (Moderator, error) {
+	src, ok := Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q", name)
+	}
+	mod, ok := src.(Moderator)
+	if !ok {
+		return nil, fmt.Errorf("can't moderate %s chat from here", name)
+	}
+	return mod, nil
+}
+
+// SayAll posts a message into every connected source that can send messages
+func SayAll(text string) {
+	for _, s := range list() {
+		name := s.src.Name()
+		if _, ok := s.src.(Sayer); !ok || !s.src.Status().Connected {
+			continue
+		}
+		if err := Say(name, text); err != nil {
+			log.Printf("[%s] say error: %v", name, err)
+		}
+	}
+}
+
+func SayAllLater(text string) {
+	go func() {
+		time.Sleep(SAY_LATER_DELAY)
+		SayAll(text)
+	}()
+}
+
+// IsFromBot is true for messages the bot sent itself, so they don't trigger commands, forwarding etc.
+func IsFromBot(msg multiChat.ChatMessage) bool {
+	return strings.EqualFold(msg.Username, env.TWITCH_BOT_USERNAME) || msg.Username == env.DEFAULT_BOT_NICKNAME || WasSaid(msg.Source, msg.Text)
+}
+
+// WasSaid reports whether the bot sent this text to the source recently, for platforms
+// that echo the bot's own messages back (and show them as coming from the channel owner)
+func WasSaid(name, text string) bool {
+	recentlySaidLock.Lock()
+	defer recentlySaidLock.Unlock()
+	at, ok := recentlySaid[name+"/"+text]
+	return ok && time.Since(at) < ECHO_WINDOW
+}
+
+func recordSaid(name, text string) time.Time {
+	now := time.Now()
+	recentlySaidLock.Lock()
+	defer recentlySaidLock.Unlock()
+	for key, at := range recentlySaid {
+		if now.Sub(at) >= ECHO_WINDOW {
+			delete(recentlySaid, key)
+		}
+	}
+	recentlySaid[name+"/"+text] = now
+	return now
+}
+
+// forgetSaid undoes recordSaid for a message that couldn't be sent, unless it was sent again since
+func forgetSaid(name, text string, at time.Time) {
+	recentlySaidLock.Lock()
+	defer recentlySaidLock.Unlock()
+	if recentlySaid[name+"/"+text] == at {
+		delete(recentlySaid, name+"/"+text)
+	}
+}
+
+// ModeratorNames lists the registered sources that can moderate their chat
+func ModeratorNames() []string {
+	names := []string{}
+	for _, s := range list() {
+		if _, ok := s.src.(Moderator); ok {
+			names = append(names, s.src.Name())
+		}
+	}
+	return names
+}
+
+// Names lists the registered sources in the order they were registered.
+func Names() []string {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+	return append([]string(nil), sourceOrder...)
+}
+
+func GetStatus(name string) (map[string]interface{}, bool) {
+	sourcesLock.Lock()
+	s, ok := sources[name]
+	sourcesLock.Unlock()
+	if !ok {
+		return nil, false
+	}
+	status := s.src.Status()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{
+		"name":          name,
+		"connected":     status.Connected,
+		"details":       status.Details,
+		"started":       s.started,
+		"starts":        s.starts,
+		"last_started":  s.lastStarted,
+		"last_error":    s.lastError,
+		"last_error_at": s.lastErrorAt,
+	}, true
+}
+
+func list() []*supervised {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+	out := make([]*supervised, 0, len(sourceOrder))
+	for _, name := range sourceOrder {
+		out = append(out, sources[name])
+	}
+	return out
+}
+
+func isEnabled() bool {
+	enabled, _ := props.GetChannelProp(nil, "enabled").(bool)
+	return enabled
+}
+
+// ensure connects the source if the bot is enabled and it isn't connected, or disconnects it if the bot is disabled
+func (s *supervised) ensure() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.src.Name()
+	if !isEnabled() {
+		if s.started {
+			log.Printf("[%s] bot is disabled, disconnecting", name)
+			s.src.Stop()
+			s.started = false
+		} else {
+			log.Printf("[%s] bot is disabled, will not connect", name)
+		}
+		return
+	}
+	if s.src.Status().Connected {
+		return
+	}
+	if s.started {
+		// it was started but dropped, clean up before reconnecting
+		log.Printf("[%s] connection dropped, reconnecting", name)
+		s.src.Stop()
+		s.started = false
+	}
+	s.starts++
+	s.lastStarted = time.Now()
+	if err := s.src.Start(); errors.Is(err, ErrNotConfigured) {
+		log.Printf("[%s] %v, skipping connect", name, err)
+		return
+	} else if err != nil {
+		log.Printf("[%s] start error: %v", name, err)
+		s.lastError = err.Error()
+		s.lastErrorAt = time.Now()
+		return
+	}
+	s.started = true
+}
+
+func (s *supervised) restart() {
+	s.mu.Lock()
+	if s.started {
+		s.src.Stop()
+		s.started = false
+	}
+	s.mu.Unlock()
+	s.ensure()
+}
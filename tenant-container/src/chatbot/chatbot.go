@@ -0,0 +1,183 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"multibot/common/src/env"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	RESPONSE_MAX_LENGTH = 400              //leave room for the @mention under the shortest platform message limit
+	RESPONSE_TIMEOUT    = 20 * time.Second //how long a backend gets to come up with a reply
+)
+
+// Responder comes up with a reply to a chatter who mentioned or replied to the bot
+type Responder interface {
+	Name() string
+	// Respond returns the reply, or "" to stay quiet. prompt is the message with the mention taken out.
+	Respond(ctx context.Context, msg multiChat.ChatMessage, prompt string) (string, error)
+}
+
+var (
+	responders = map[string]Responder{}
+
+	lastResponse     time.Time
+	lastResponseLock sync.Mutex
+)
+
+// Register adds a backend that can be picked with the chatbot_backend channel prop
+func Register(r Responder) {
+	responders[r.Name()] = r
+}
+
+func init() {
+	Register(local{})
+	Register(openAI{})
+}
+
+// HandleChat is a multiChat chat listener that answers messages that mention or reply to the bot
+func HandleChat(msg multiChat.ChatMessage) {
+	if chatSource.IsFromBot(msg) {
+		return
+	}
+	text := strings.TrimSpace(msg.Text)
+	if strings.HasPrefix(text, "!") {
+		return // commands are handled by chatCommands
+	}
+	ignoreBots, _ := props.GetChannelProp(nil, "chatbot_ignore_bots").(bool)
+	if ignoreBots && isOtherBot(msg.Username) {
+		return
+	}
+	if enabled, _ := props.GetChannelProp(nil, "enabled").(bool); !enabled {
+		return
+	}
+	if enabled, _ := props.GetChannelProp(nil, "chatbot_enabled").(bool); !enabled {
+		return
+	}
+	// the chatbot was turned on after startup
+	if !trainStarted.Load() {
+		go Train()
+	}
+	learn(text)
+	prompt, ok := mentionsBot(msg)
+	if !ok || !takeCooldown() {
+		return
+	}
+	// backends can be slow, don't hold up the other chat listeners
+	go respond(msg, prompt)
+}
+
+func respond(msg multiChat.ChatMessage, prompt string) {
+	ctx, cancel := context.WithTimeout(context.Background(), RESPONSE_TIMEOUT)
+	defer cancel()
+
+	backend, _ := props.GetChannelProp(ctx, "chatbot_backend").(string)
+	r, ok := responders[backend]
+	if !ok {
+		r = local{}
+	}
+	reply, err := r.Respond(ctx, msg, prompt)
+	if err != nil && r.Name() != "local" {
+		log.Printf("[chatbot] %s error, falling back to local: %v", r.Name(), err)
+		reply, err = local{}.Respond(ctx, msg, prompt)
+	}
+	if err != nil {
+		log.Println("[chatbot] error:", err)
+		return
+	}
+	reply = cleanReply(reply)
+	if reply == "" {
+		return
+	}
+	log.Printf("[chatbot] [%s] replying to %s: %s", msg.Source, msg.Username, reply)
+	if err := chatSource.Say(msg.Source, fmt.Sprintf("@%s %s", msg.Username, reply)); err != nil {
+		log.Printf("[chatbot] [%s] reply error: %v", msg.Source, err)
+	}
+}
+
+// mentionsBot checks for "@botname" anywhere in the message, or a reply to one of the bot's messages,
+// and returns the message without the mention
+func mentionsBot(msg multiChat.ChatMessage) (string, bool) {
+	botName := strings.ToLower(env.TWITCH_BOT_USERNAME)
+	if botName == "" {
+		return "", false
+	}
+	replied := strings.EqualFold(msg.ReplyTo, botName)
+	mentioned := false
+	words := strings.Fields(msg.Text)
+	prompt := make([]string, 0, len(words))
+	for _, w := range words {
+		name := strings.TrimRight(strings.ToLower(w), ",:.!?")
+		if name == "@"+botName {
+			mentioned = true
+			continue
+		}
+		prompt = append(prompt, w)
+	}
+	return strings.Join(prompt, " "), mentioned || replied
+}
+
+// isOtherBot is true for the well known chat bots in chatbot_bot_names, so two bots don't talk to each other forever
+func isOtherBot(username string) bool {
+	var names []string
+	if err := props.GetChannelPropJSON(nil, "chatbot_bot_names", &names); err != nil {
+		log.Println("[chatbot] error reading chatbot_bot_names:", err)
+		return false
+	}
+	for _, name := range names {
+		if strings.EqualFold(name, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// takeCooldown returns false if the bot replied too recently, otherwise it starts the cooldown
+func takeCooldown() bool {
+	secs := props.GetChannelPropAs(nil, "chatbot_cooldown_secs", 0)
+	now := time.Now()
+	lastResponseLock.Lock()
+	defer lastResponseLock.Unlock()
+	if now.Sub(lastResponse) < time.Duration(secs)*time.Second {
+		log.Println("[chatbot] on cooldown")
+		return false
+	}
+	lastResponse = now
+	return true
+}
+
+// cleanReply puts the reply on one line and cuts it to RESPONSE_MAX_LENGTH
+func cleanReply(reply string) string {
+	reply = strings.Join(strings.Fields(reply), " ")
+	if runes := []rune(reply); len(runes) > RESPONSE_MAX_LENGTH {
+		reply = string(runes[:RESPONSE_MAX_LENGTH-3]) + "..."
+	}
+	return reply
+}
+
+// Status is shown on the bot page, it never includes the API key itself
+func Status(ctx context.Context) map[string]any {
+	chainLock.RLock()
+	pairs := len(chain)
+	chainLock.RUnlock()
+	backends := []string{}
+	for name := range responders {
+		backends = append(backends, name)
+	}
+	sort.Strings(backends)
+	return map[string]any{
+		"backends":    backends,
+		"api_key_set": HasAPIKey(ctx),
+		"word_pairs":  pairs,
+	}
+}
@@ -0,0 +1,218 @@
+package chatbot
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"multibot/common/src/env"
+
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	MARKOV_MAX_STATES    = 50000  //stop learning new word pairs past this, to keep memory in check
+	MARKOV_MAX_NEXT      = 200000 //stop learning new words after a pair past this many in total, repeats only bump a count
+	MARKOV_MAX_WORDS     = 25     //longest reply the markov chain will make
+	MARKOV_TRAIN_HISTORY = 5000   //how many messages of chat history to learn from at startup
+)
+
+// Rule is a keyword reply, used before the markov chain
+type Rule struct {
+	Keywords []string `json:"keywords"` // any of these (case-insensitive) in the message triggers the rule
+	Response string   `json:"response"` // template with {user}, {nickname}, {channel} and {source}
+}
+
+// local is the built-in backend, keyword rules first and then a markov chain of the channel's own chat
+type local struct{}
+
+func (local) Name() string { return "local" }
+
+func (local) Respond(ctx context.Context, msg multiChat.ChatMessage, prompt string) (string, error) {
+	if reply, ok := matchRule(ctx, msg, prompt); ok {
+		return reply, nil
+	}
+	return generate(prompt), nil
+}
+
+func matchRule(ctx context.Context, msg multiChat.ChatMessage, prompt string) (string, bool) {
+	var rules []Rule
+	if err := props.GetChannelPropJSON(ctx, "chatbot_rules", &rules); err != nil {
+		log.Println("[chatbot] error reading chatbot_rules:", err)
+		return "", false
+	}
+	lower := strings.ToLower(prompt)
+	for _, rule := range rules {
+		for _, keyword := range rule.Keywords {
+			keyword = strings.ToLower(strings.TrimSpace(keyword))
+			if keyword != "" && strings.Contains(lower, keyword) {
+				return renderResponse(rule.Response, msg), true
+			}
+		}
+	}
+	return "", false
+}
+
+func renderResponse(template string, msg multiChat.ChatMessage) string {
+	nickname := msg.Nickname
+	if nickname == "" {
+		nickname = msg.Username
+	}
+	return strings.NewReplacer(
+		"{user}", msg.Username,
+		"{nickname}", nickname,
+		"{channel}", env.TWITCH_CHANNEL,
+		"{source}", msg.Source,
+	).Replace(template)
+}
+
+// -----------------------------------------------------------------------------
+//  Markov chain
+// -----------------------------------------------------------------------------
+
+// an order 2 chain, "word1 word2" => how often each word was seen after that pair. "" marks the start and end of a message.
+var (
+	chain     = make(map[[2]string]*successors)
+	nextCount int                            // words after a pair across the chain, see MARKOV_MAX_NEXT
+	byWord    = make(map[string][][2]string) // lowercase word => pairs it starts, to seed a reply with a word from the prompt
+	chainLock sync.RWMutex
+
+	trainStarted atomic.Bool
+)
+
+// successors are the words seen after a pair and how often, total is the sum of the counts
+type successors struct {
+	counts map[string]int
+	total  int
+}
+
+// pick chooses a word weighted by how often it was seen
+func (s *successors) pick() string {
+	n := rand.Intn(s.total)
+	for word, count := range s.counts {
+		if n < count {
+			return word
+		}
+		n -= count
+	}
+	return ""
+}
+
+// Train learns from the stored chat history. It does nothing until the chatbot is turned on, so a channel that
+// doesn't use it doesn't keep its chat in memory, and only runs once.
+func Train() {
+	if enabled, _ := props.GetChannelProp(nil, "chatbot_enabled").(bool); !enabled {
+		return
+	}
+	if !trainStarted.CompareAndSwap(false, true) {
+		return
+	}
+	ignoreBots, _ := props.GetChannelProp(nil, "chatbot_ignore_bots").(bool)
+	n := 0
+	before := ""
+	// page backwards from the newest message
+	for seen := 0; seen < MARKOV_TRAIN_HISTORY; {
+		msgs, err := multiChat.GetChatHistory(context.Background(), before, "", multiChat.CHAT_HISTORY_MAX_PAGE)
+		if err != nil {
+			log.Println("[chatbot] error loading chat history:", err)
+			return
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, msg := range msgs {
+			if msg.Username == env.TWITCH_BOT_USERNAME || strings.HasPrefix(msg.Text, "!") || (ignoreBots && isOtherBot(msg.Username)) {
+				continue
+			}
+			learn(msg.Text)
+			n++
+		}
+		seen += len(msgs)
+		before = msgs[0].HistoryID
+	}
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	log.Printf("[chatbot] learned from %d messages, %d word pairs, %d next words", n, len(chain), nextCount)
+}
+
+// learn adds a chat message to the markov chain
+func learn(text string) {
+	words := strings.Fields(text)
+	if len(words) < 2 {
+		return
+	}
+	// mentions are different every time and make for weird replies
+	filtered := words[:0]
+	for _, w := range words {
+		if !strings.HasPrefix(w, "@") {
+			filtered = append(filtered, w)
+		}
+	}
+	words = append([]string{"", ""}, append(filtered, "")...)
+
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	for i := 0; i+2 < len(words); i++ {
+		key, word := [2]string{words[i], words[i+1]}, words[i+2]
+		next := chain[key]
+		if next == nil {
+			if len(chain) >= MARKOV_MAX_STATES || nextCount >= MARKOV_MAX_NEXT {
+				continue
+			}
+			next = &successors{counts: make(map[string]int)}
+			chain[key] = next
+			if key[1] != "" {
+				lower := strings.ToLower(key[1])
+				byWord[lower] = append(byWord[lower], key)
+			}
+		}
+		if _, seen := next.counts[word]; !seen {
+			if nextCount >= MARKOV_MAX_NEXT {
+				continue
+			}
+			nextCount++
+		}
+		next.counts[word]++
+		next.total++
+	}
+}
+
+// generate makes a reply that starts from a word in the prompt if the chain knows one, otherwise from a random start
+func generate(prompt string) string {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	if len(chain) == 0 {
+		return ""
+	}
+
+	key := [2]string{"", ""}
+	candidates := [][2]string{}
+	for _, w := range strings.Fields(prompt) {
+		candidates = append(candidates, byWord[strings.ToLower(w)]...)
+	}
+	if len(candidates) > 0 {
+		key = candidates[rand.Intn(len(candidates))]
+	}
+
+	words := []string{}
+	if key[1] != "" {
+		words = append(words, key[1])
+	}
+	for len(words) < MARKOV_MAX_WORDS {
+		next := chain[key]
+		if next == nil || next.total == 0 {
+			break
+		}
+		word := next.pick()
+		if word == "" {
+			break
+		}
+		words = append(words, word)
+		key = [2]string{key[1], word}
+	}
+	return strings.Join(words, " ")
+}
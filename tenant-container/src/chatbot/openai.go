@@ -0,0 +1,115 @@
+package chatbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	OPENAI_CONTEXT_LINES = 10  //recent chat lines sent along so the model knows what's going on
+	OPENAI_MAX_TOKENS    = 150 //replies are cut to RESPONSE_MAX_LENGTH anyway
+)
+
+// the API key is kept out of the channel props since those are public
+func apiKeyKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/chatbot_api_key"
+}
+
+// SetAPIKey stores the key for the openai backend, "" removes it
+func SetAPIKey(ctx context.Context, key string) error {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return redisClient.Del(ctx, apiKeyKey()).Err()
+	}
+	return redisClient.Set(ctx, apiKeyKey(), key, 0).Err()
+}
+
+func HasAPIKey(ctx context.Context) bool {
+	n, _ := redisClient.Exists(ctx, apiKeyKey()).Result()
+	return n > 0
+}
+
+// openAI talks to any server with an OpenAI style /chat/completions endpoint, e.g. OpenAI, OpenRouter, ollama or llama.cpp
+type openAI struct{}
+
+func (openAI) Name() string { return "openai" }
+
+type chatCompletionMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+func (openAI) Respond(ctx context.Context, msg multiChat.ChatMessage, prompt string) (string, error) {
+	baseURL, _ := props.GetChannelProp(ctx, "chatbot_openai_url").(string)
+	model, _ := props.GetChannelProp(ctx, "chatbot_openai_model").(string)
+	systemPrompt, _ := props.GetChannelProp(ctx, "chatbot_system_prompt").(string)
+	if baseURL == "" {
+		return "", fmt.Errorf("chatbot_openai_url is not set")
+	}
+	systemPrompt = strings.NewReplacer(
+		"{bot}", env.TWITCH_BOT_USERNAME,
+		"{channel}", env.TWITCH_CHANNEL,
+	).Replace(systemPrompt)
+
+	body, _ := json.Marshal(map[string]any{
+		"model": model,
+		"messages": []chatCompletionMessage{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: recentChat(ctx) + fmt.Sprintf("\nreply to %s on %s: %s", msg.Username, msg.Source, prompt)},
+		},
+		"max_tokens": OPENAI_MAX_TOKENS,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(baseURL, "/")+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key, err := redisClient.Get(ctx, apiKeyKey()).Result(); err == nil && key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		return "", fmt.Errorf("chat completion failed: %s %s", resp.Status, b)
+	}
+	var result struct {
+		Choices []struct {
+			Message chatCompletionMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("chat completion had no choices")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+// recentChat formats the last few chat lines as "user: text"
+func recentChat(ctx context.Context) string {
+	msgs, err := multiChat.GetChatHistory(ctx, "", "", OPENAI_CONTEXT_LINES)
+	if err != nil || len(msgs) == 0 {
+		return ""
+	}
+	lines := []string{"recent chat:"}
+	for _, m := range msgs {
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", m.Source, m.Username, m.Text))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,133 @@
+package cmdForwarding
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	goaway "github.com/TwiN/go-away"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	COOLDOWN_PRUNE_INTERVAL = 1 * time.Minute //how often cooldowns that ran out are forgotten
+)
+
+// Rule forwards messages starting with one of Prefixes from any of the From platforms into each of the To platforms
+type Rule struct {
+	ID             string   `json:"id"` // keeps the cooldowns when rules are reordered, rules saved before IDs were added use their contents
+	From           []string `json:"from"`
+	Prefixes       []string `json:"prefixes"`
+	To             []string `json:"to"`
+	Censor         bool     `json:"censor"`
+	UserCooldownMs int64    `json:"user_cooldown_ms"` // per-user rate limit for this rule, 0 = no limit
+}
+
+var (
+	// forwardCooldowns stores when a user can trigger a rule again, keyed by rule ID + source + username
+	forwardCooldowns     = make(map[string]time.Time)
+	forwardCooldownsLock sync.Mutex
+	lastCooldownPrune    time.Time
+)
+
+// GetRules returns the forwarding rules, falling back to the old youtube => twitch list if fwd_rules was never saved
+func GetRules() []Rule {
+	if props.GetChannelProp(nil, "fwd_rules") == nil {
+		var legacy []string
+		if err := props.GetChannelPropJSON(nil, "fwd_cmds_yt_twitch", &legacy); err != nil {
+			log.Println("[forward] error reading fwd_cmds_yt_twitch:", err)
+			return nil
+		}
+		return []Rule{{From: []string{"youtube"}, Prefixes: legacy, To: []string{"twitch"}, Censor: true}}
+	}
+	var rules []Rule
+	if err := props.GetChannelPropJSON(nil, "fwd_rules", &rules); err != nil {
+		log.Println("[forward] error reading fwd_rules:", err)
+		return nil
+	}
+	return rules
+}
+
+// HandleChat is a multiChat chat listener that forwards matching commands
+func HandleChat(msg multiChat.ChatMessage) {
+	// don't forward the bot's own messages, or forwarded commands could bounce between platforms
+	if chatSource.IsFromBot(msg) {
+		return
+	}
+	for _, rule := range GetRules() {
+		if !contains(rule.From, msg.Source) || !hasAnyPrefix(msg.Text, rule.Prefixes) {
+			continue
+		}
+		if !allowUser(rule, msg) {
+			log.Printf("[forward] %s on %s is rate limited for rule %s", msg.Username, msg.Source, ruleID(rule))
+			continue
+		}
+		text := msg.Text
+		if rule.Censor {
+			text = goaway.Censor(text)
+		}
+		for _, dest := range rule.To {
+			if dest == msg.Source {
+				continue
+			}
+			log.Printf("[forward] %s => %s: %s", msg.Source, dest, text)
+			if err := chatSource.Say(dest, text); err != nil {
+				log.Printf("[forward] error forwarding to %s: %v", dest, err)
+			}
+		}
+	}
+}
+
+func allowUser(rule Rule, msg multiChat.ChatMessage) bool {
+	if rule.UserCooldownMs <= 0 {
+		return true
+	}
+	key := fmt.Sprintf("%s/%s/%s", ruleID(rule), msg.Source, strings.ToLower(msg.Username))
+	now := time.Now()
+	forwardCooldownsLock.Lock()
+	defer forwardCooldownsLock.Unlock()
+	if now.Sub(lastCooldownPrune) > COOLDOWN_PRUNE_INTERVAL {
+		for k, until := range forwardCooldowns {
+			if now.After(until) {
+				delete(forwardCooldowns, k)
+			}
+		}
+		lastCooldownPrune = now
+	}
+	if until, ok := forwardCooldowns[key]; ok && now.Before(until) {
+		return false
+	}
+	forwardCooldowns[key] = now.Add(time.Duration(rule.UserCooldownMs) * time.Millisecond)
+	return true
+}
+
+// ruleID is the rule's ID, or for rules saved without one, what it forwards
+func ruleID(rule Rule) string {
+	if rule.ID != "" {
+		return rule.ID
+	}
+	return strings.Join(rule.From, ",") + ">" + strings.Join(rule.To, ",") + ":" + strings.Join(rule.Prefixes, ",")
+}
+
+func hasAnyPrefix(text string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(slice []string, item string) bool {
+	for _, v := range slice {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,402 @@
+package discordChat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	DISCORD_API_URL           = "https://discord.com/api/v10"
+	DISCORD_EMOJI_URL         = "https://cdn.discordapp.com/emojis/%s.%s"
+	DISCORD_HTTP_TIMEOUT      = 10 * time.Second
+	DISCORD_CONNECT_TIMEOUT   = 15 * time.Second //how long Start waits for the gateway to be ready
+	DISCORD_MAX_MESSAGE_RUNES = 2000
+)
+
+var (
+	// custom emoji <:name:id> or <a:name:id>, user mentions <@id> or <@!id>, role mentions <@&id> and channels <#id>
+	contentRegex  = regexp.MustCompile(`<(a?):(\w+):(\d+)>|<@!?(\d+)>|<@&(\d+)>|<#(\d+)>`)
+	markdownRegex = regexp.MustCompile("([\\\\*_~|>`])")
+
+	discordConnected bool
+	discordCancel    context.CancelFunc
+	discordWG        sync.WaitGroup
+	discordChannelID string
+	discordToken     string
+	discordBotUser   discordUser
+	discordError     string
+	discordLock      sync.Mutex // guards the vars above except discordWG
+)
+
+type discordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type discordMessage struct {
+	ID          string        `json:"id"`
+	ChannelID   string        `json:"channel_id"`
+	Content     string        `json:"content"`
+	Author      discordUser   `json:"author"`
+	Mentions    []discordUser `json:"mentions"`
+	Attachments []struct {
+		Filename string `json:"filename"`
+	} `json:"attachments"`
+	ReferencedMessage *struct {
+		Author discordUser `json:"author"`
+	} `json:"referenced_message"`
+}
+
+type discordDelete struct {
+	ID        string   `json:"id"`
+	IDs       []string `json:"ids"` // MESSAGE_DELETE_BULK
+	ChannelID string   `json:"channel_id"`
+}
+
+// the bot token is kept out of the channel props since those are public
+func botTokenKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/discord_bot_token"
+}
+
+// SetBotToken stores the discord bot token, "" removes it. Restart the source afterwards.
+func SetBotToken(ctx context.Context, token string) error {
+	token = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(token), "Bot "))
+	if token == "" {
+		return redisClient.Del(ctx, botTokenKey()).Err()
+	}
+	return redisClient.Set(ctx, botTokenKey(), token, 0).Err()
+}
+
+func HasBotToken(ctx context.Context) bool {
+	n, _ := redisClient.Exists(ctx, botTokenKey()).Result()
+	return n > 0
+}
+
+func apiURL() string {
+	if env.DISCORD_API_URL != "" {
+		return env.DISCORD_API_URL
+	}
+	return DISCORD_API_URL
+}
+
+// Source is the discord ChatSource, registered with the supervisor in main
+var Source chatSource.ChatSource = source{}
+
+type source struct{}
+
+func (source) Name() string { return "discord" }
+
+func (source) Start() error {
+	channelID, _ := props.GetChannelProp(nil, "discord_channel_id").(string)
+	if channelID == "" {
+		return fmt.Errorf("no discord_channel_id: %w", chatSource.ErrNotConfigured)
+	}
+	token, err := redisClient.Get(context.Background(), botTokenKey()).Result()
+	if err != nil || token == "" {
+		return fmt.Errorf("no bot token: %w", chatSource.ErrNotConfigured)
+	}
+	gatewayURL := DISCORD_GATEWAY_URL
+	if env.DISCORD_GATEWAY_URL != "" {
+		gatewayURL = env.DISCORD_GATEWAY_URL
+	}
+	log.Println("[discord] connecting to", gatewayURL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{}, 1)
+	g := &gateway{
+		url:   gatewayURL,
+		token: token,
+		onReady: func(user discordUser) {
+			log.Printf("[discord] logged in as %s", user.Username)
+			discordLock.Lock()
+			discordBotUser = user
+			discordConnected = true
+			discordError = ""
+			discordLock.Unlock()
+			select {
+			case ready <- struct{}{}:
+			default:
+			}
+		},
+		onDispatch: func(eventType string, data json.RawMessage) {
+			handleDispatch(channelID, eventType, data)
+		},
+	}
+	discordLock.Lock()
+	discordCancel = cancel
+	discordChannelID = channelID
+	discordToken = token
+	discordLock.Unlock()
+
+	failed := make(chan error, 1)
+	discordWG.Add(1)
+	go func() {
+		defer discordWG.Done()
+		err := g.run(ctx)
+		discordLock.Lock()
+		wasConnected := discordConnected
+		discordConnected = false
+		if err != nil {
+			discordError = err.Error()
+		}
+		discordLock.Unlock()
+		failed <- err
+		if wasConnected {
+			log.Println("[discord] disconnected:", err)
+			chatSource.SayAll("disconnected from discord chat")
+		}
+	}()
+
+	select {
+	case <-ready:
+		//delay the message a bit to allow the disconnect message to come thru first
+		chatSource.SayAllLater("connected to discord chat")
+		return nil
+	case err := <-failed:
+		cancel()
+		return fmt.Errorf("gateway error: %w", err)
+	case <-time.After(DISCORD_CONNECT_TIMEOUT):
+		cancel()
+		discordWG.Wait()
+		return fmt.Errorf("gateway not ready after %v", DISCORD_CONNECT_TIMEOUT)
+	}
+}
+
+func handleDispatch(channelID, eventType string, data json.RawMessage) {
+	switch eventType {
+	case "MESSAGE_CREATE":
+		var m discordMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Println("[discord] message parse err:", err)
+			return
+		}
+		discordLock.Lock()
+		botID := discordBotUser.ID
+		discordLock.Unlock()
+		// the bot's own messages are relayed chat and command replies that are already in the multichat
+		if m.ChannelID != channelID || m.Author.ID == botID {
+			return
+		}
+		text, emotes := parseContent(m.Content, m.Mentions)
+		for _, a := range m.Attachments {
+			if text != "" {
+				text += " "
+			}
+			text += "[" + a.Filename + "]"
+		}
+		if text == "" {
+			return
+		}
+		chatMsg := multiChat.ChatMessage{
+			PlatformID: m.ID,
+			UserID:     m.Author.ID,
+			Source:     "discord",
+			Username:   m.Author.Username,
+			Emotes:     emotes,
+			Text:       text,
+		}
+		if m.ReferencedMessage != nil {
+			chatMsg.ReplyTo = m.ReferencedMessage.Author.Username
+		}
+		multiChat.SendChatMessage(chatMsg)
+	case "MESSAGE_DELETE", "MESSAGE_DELETE_BULK":
+		var m discordDelete
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Println("[discord] delete parse err:", err)
+			return
+		}
+		if m.ChannelID != channelID {
+			return
+		}
+		for _, id := range append(m.IDs, m.ID) {
+			if id != "" {
+				multiChat.DeleteMessage("discord", id)
+			}
+		}
+	}
+}
+
+// parseContent replaces discord's <...> markup with text, custom emoji become their :name: with an
+// entry in the emotes map (positions in runes) and mentions become @username
+func parseContent(content string, mentions []discordUser) (string, map[string][]string) {
+	names := make(map[string]string)
+	for _, u := range mentions {
+		names[u.ID] = u.Username
+	}
+	emotes := make(map[string][]string)
+	var sb strings.Builder
+	pos := 0
+	last := 0
+	for _, m := range contentRegex.FindAllStringSubmatchIndex(content, -1) {
+		sb.WriteString(content[last:m[0]])
+		pos += utf8.RuneCountInString(content[last:m[0]])
+		last = m[1]
+		group := func(i int) string {
+			if m[2*i] < 0 {
+				return ""
+			}
+			return content[m[2*i]:m[2*i+1]]
+		}
+		replacement := ""
+		switch {
+		case group(3) != "":
+			ext := "webp"
+			if group(1) == "a" {
+				ext = "gif"
+			}
+			replacement = ":" + group(2) + ":"
+			url := fmt.Sprintf(DISCORD_EMOJI_URL, group(3), ext)
+			length := utf8.RuneCountInString(replacement)
+			emotes[url] = append(emotes[url], fmt.Sprintf("%d-%d", pos, pos+length-1))
+		case group(4) != "":
+			replacement = "@" + names[group(4)]
+			if names[group(4)] == "" {
+				replacement = "@user"
+			}
+		case group(5) != "":
+			replacement = "@role"
+		case group(6) != "":
+			replacement = "#channel"
+		}
+		sb.WriteString(replacement)
+		pos += utf8.RuneCountInString(replacement)
+	}
+	sb.WriteString(content[last:])
+	return sb.String(), emotes
+}
+
+// HandleChat relays chat from the sources listed in discord_relay_sources into the discord channel
+func HandleChat(msg multiChat.ChatMessage) {
+	if msg.Source == "discord" || chatSource.IsFromBot(msg) || !Source.Status().Connected {
+		return
+	}
+	var relaySources []string
+	if err := props.GetChannelPropJSON(nil, "discord_relay_sources", &relaySources); err != nil {
+		log.Println("[discord] error reading discord_relay_sources:", err)
+		return
+	}
+	if !slices.Contains(relaySources, msg.Source) {
+		return
+	}
+	name := msg.Username
+	if msg.Nickname != "" {
+		name = msg.Nickname
+	}
+	text := fmt.Sprintf("**[%s] %s:** %s", msg.Source, escapeMarkdown(name), escapeMarkdown(msg.Text))
+	go func() {
+		if err := chatSource.Say("discord", text); err != nil {
+			log.Println("[discord] relay error:", err)
+		}
+	}()
+}
+
+func escapeMarkdown(s string) string {
+	return markdownRegex.ReplaceAllString(s, `\$1`)
+}
+
+func (source) Stop() {
+	discordLock.Lock()
+	cancel := discordCancel
+	discordCancel = nil
+	discordLock.Unlock()
+	if cancel != nil {
+		log.Println("[discord] disconnecting")
+		cancel()
+		discordWG.Wait()
+	}
+}
+
+// Say posts into the discord channel as the bot, mentions in the text don't ping anyone
+func (source) Say(text string) error {
+	discordLock.Lock()
+	connected, channelID, token := discordConnected, discordChannelID, discordToken
+	discordLock.Unlock()
+	if !connected {
+		return fmt.Errorf("not connected")
+	}
+	if utf8.RuneCountInString(text) > DISCORD_MAX_MESSAGE_RUNES {
+		text = string([]rune(text)[:DISCORD_MAX_MESSAGE_RUNES])
+	}
+	body, _ := json.Marshal(map[string]any{
+		"content":          text,
+		"allowed_mentions": map[string]any{"parse": []string{}},
+	})
+	err := postMessage(channelID, token, body)
+	if retryAfter, ok := err.(rateLimitError); ok {
+		// one retry, chat relays come in bursts
+		time.Sleep(time.Duration(retryAfter))
+		err = postMessage(channelID, token, body)
+	}
+	return err
+}
+
+// rateLimitError is how long discord wants us to wait
+type rateLimitError time.Duration
+
+func (e rateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %v", time.Duration(e))
+}
+
+func postMessage(channelID, token string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, apiURL()+"/channels/"+channelID+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bot "+token)
+	client := &http.Client{Timeout: DISCORD_HTTP_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		var limit struct {
+			RetryAfter float64 `json:"retry_after"`
+		}
+		json.NewDecoder(resp.Body).Decode(&limit)
+		return rateLimitError(time.Duration(limit.RetryAfter * float64(time.Second)))
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		return fmt.Errorf("status=%d body=%s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+func (source) Status() chatSource.Status {
+	discordLock.Lock()
+	defer discordLock.Unlock()
+	return chatSource.Status{
+		Connected: discordConnected,
+		Details: map[string]any{
+			"channel_id": discordChannelID,
+			"bot_user":   discordBotUser.Username,
+			"bot_id":     discordBotUser.ID,
+			"error":      discordError,
+		},
+	}
+}
+
+// Settings is what the bot page shows about discord, the token itself is never sent back
+func Settings(ctx context.Context) map[string]any {
+	return map[string]any{"bot_token_set": HasBotToken(ctx)}
+}
@@ -0,0 +1,235 @@
+package discordChat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	DISCORD_GATEWAY_URL        = "wss://gateway.discord.gg/?v=10&encoding=json"
+	DISCORD_RECONNECT_DELAY    = 2 * time.Second //wait before reconnecting after discord closed the connection
+	DISCORD_MAX_CONNECT_ERRORS = 5               //failed connects in a row before giving up and letting the supervisor retry
+
+	// GUILD_MESSAGES | MESSAGE_CONTENT, the content intent has to be switched on for the bot in the developer portal
+	DISCORD_INTENTS = 1<<9 | 1<<15
+)
+
+// gateway opcodes, see https://discord.com/developers/docs/topics/opcodes-and-status-codes
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opResume         = 6
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatACK   = 11
+)
+
+// close codes that reconnecting won't fix, e.g. a bad token or the message content intent not enabled
+var fatalCloseCodes = map[int]string{
+	4004: "authentication failed, check the bot token",
+	4010: "invalid shard",
+	4011: "sharding required",
+	4012: "invalid API version",
+	4013: "invalid intents",
+	4014: "disallowed intents, enable the message content intent for the bot in the discord developer portal",
+}
+
+var errReconnect = errors.New("discord asked to reconnect")
+
+type gatewayPayload struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d,omitempty"`
+	S  *int64          `json:"s,omitempty"`
+	T  string          `json:"t,omitempty"`
+}
+
+type readyEvent struct {
+	SessionID        string      `json:"session_id"`
+	ResumeGatewayURL string      `json:"resume_gateway_url"`
+	User             discordUser `json:"user"`
+}
+
+// gateway is a discord gateway connection that resumes after discord drops it
+type gateway struct {
+	url        string
+	token      string
+	onReady    func(user discordUser)
+	onDispatch func(eventType string, data json.RawMessage)
+
+	// kept across reconnects to resume the session
+	sessionID string
+	resumeURL string
+	seq       int64
+	seqLock   sync.Mutex
+}
+
+// run keeps the gateway connected until ctx is done (returns nil) or it can't reconnect (returns the error)
+func (g *gateway) run(ctx context.Context) error {
+	failures := 0
+	for {
+		connected, err := g.connect(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			if reason, ok := fatalCloseCodes[closeErr.Code]; ok {
+				return fmt.Errorf("discord closed the connection: %s", reason)
+			}
+			// 4007 invalid seq, 4009 session timed out: start a new session
+			if closeErr.Code == 4007 || closeErr.Code == 4009 {
+				g.sessionID = ""
+			}
+		}
+		if connected {
+			failures = 0
+		} else if failures++; failures >= DISCORD_MAX_CONNECT_ERRORS {
+			return err
+		}
+		log.Printf("[discord] %v, reconnecting", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(DISCORD_RECONNECT_DELAY):
+		}
+	}
+}
+
+// connect runs one connection, connected is true if discord said hello
+func (g *gateway) connect(ctx context.Context) (connected bool, err error) {
+	url := g.url
+	if g.sessionID != "" && g.resumeURL != "" {
+		url = g.resumeURL + "/?v=10&encoding=json"
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var writeLock sync.Mutex
+	send := func(op int, d any) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		return conn.WriteJSON(map[string]any{"op": op, "d": d})
+	}
+
+	var hello gatewayPayload
+	if err := conn.ReadJSON(&hello); err != nil {
+		return false, fmt.Errorf("hello: %w", err)
+	}
+	var helloData struct {
+		HeartbeatInterval int `json:"heartbeat_interval"`
+	}
+	if hello.Op != opHello || json.Unmarshal(hello.D, &helloData) != nil || helloData.HeartbeatInterval <= 0 {
+		return false, fmt.Errorf("expected hello, got op %d", hello.Op)
+	}
+
+	// heartbeat until the connection closes, and close it if discord stops acknowledging them
+	acked := make(chan struct{}, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		interval := time.Duration(helloData.HeartbeatInterval) * time.Millisecond
+		wait := time.Duration(rand.Int63n(int64(interval))) // discord asks for a random first beat
+		ack := true
+		for {
+			select {
+			case <-done:
+				return
+			case <-acked:
+				ack = true
+				continue
+			case <-time.After(wait):
+			}
+			if !ack {
+				log.Println("[discord] no heartbeat ACK, reconnecting")
+				conn.Close()
+				return
+			}
+			ack = false
+			wait = interval
+			if err := send(opHeartbeat, g.lastSeq()); err != nil {
+				return
+			}
+		}
+	}()
+
+	if g.sessionID != "" {
+		err = send(opResume, map[string]any{"token": g.token, "session_id": g.sessionID, "seq": g.lastSeq()})
+	} else {
+		err = send(opIdentify, map[string]any{
+			"token":      g.token,
+			"intents":    DISCORD_INTENTS,
+			"properties": map[string]string{"os": "linux", "browser": "multibot", "device": "multibot"},
+		})
+	}
+	if err != nil {
+		return true, err
+	}
+
+	for {
+		var p gatewayPayload
+		if err := conn.ReadJSON(&p); err != nil {
+			return true, err
+		}
+		switch p.Op {
+		case opDispatch:
+			if p.S != nil {
+				g.seqLock.Lock()
+				g.seq = *p.S
+				g.seqLock.Unlock()
+			}
+			switch p.T {
+			case "READY":
+				var ready readyEvent
+				if err := json.Unmarshal(p.D, &ready); err != nil {
+					return true, fmt.Errorf("bad READY: %w", err)
+				}
+				g.sessionID, g.resumeURL = ready.SessionID, ready.ResumeGatewayURL
+				g.onReady(ready.User)
+			case "RESUMED":
+				log.Println("[discord] resumed")
+			default:
+				g.onDispatch(p.T, p.D)
+			}
+		case opHeartbeat:
+			send(opHeartbeat, g.lastSeq())
+		case opHeartbeatACK:
+			select {
+			case acked <- struct{}{}:
+			default:
+			}
+		case opReconnect:
+			return true, errReconnect
+		case opInvalidSession:
+			var resumable bool
+			json.Unmarshal(p.D, &resumable)
+			if !resumable {
+				g.sessionID = ""
+			}
+			return true, fmt.Errorf("invalid session (resumable: %v)", resumable)
+		}
+	}
+}
+
+func (g *gateway) lastSeq() any {
+	g.seqLock.Lock()
+	defer g.seqLock.Unlock()
+	if g.seq == 0 {
+		return nil
+	}
+	return g.seq
+}
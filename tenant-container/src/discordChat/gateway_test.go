@@ -0,0 +1,136 @@
+package discordChat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// fakeGateway plays discord's side of a session: identify and READY, a message, a reconnect request,
+// then a resume and a message on the resume URL, then a close code that reconnecting won't fix
+func fakeGateway(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+	hello := func(c *websocket.Conn) {
+		c.WriteJSON(map[string]any{"op": opHello, "d": map[string]any{"heartbeat_interval": 45000}})
+	}
+	dispatch := func(c *websocket.Conn, seq int, eventType string, d any) {
+		c.WriteJSON(map[string]any{"op": opDispatch, "s": seq, "t": eventType, "d": d})
+	}
+	expect := func(c *websocket.Conn, op int) map[string]any {
+		var p struct {
+			Op int            `json:"op"`
+			D  map[string]any `json:"d"`
+		}
+		if err := c.ReadJSON(&p); err != nil {
+			t.Errorf("reading op %d: %v", op, err)
+			return nil
+		}
+		if p.Op != op {
+			t.Errorf("got op %d, want %d", p.Op, op)
+		}
+		return p.D
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gateway", func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		hello(c)
+		identify := expect(c, opIdentify)
+		if identify["token"] != "bot-token" || identify["intents"] != float64(DISCORD_INTENTS) {
+			t.Errorf("bad identify: %v", identify)
+		}
+		resumeURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/resume"
+		dispatch(c, 1, "READY", map[string]any{"session_id": "session1", "resume_gateway_url": resumeURL,
+			"user": map[string]any{"id": "99", "username": "multibot"}})
+		dispatch(c, 2, "MESSAGE_CREATE", map[string]any{"id": "m1", "channel_id": "c1", "content": "hello",
+			"author": map[string]any{"id": "1", "username": "alice"}})
+		c.WriteJSON(map[string]any{"op": opReconnect})
+		c.ReadMessage()
+	})
+	mux.HandleFunc("/resume/", func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		hello(c)
+		resume := expect(c, opResume)
+		if resume["session_id"] != "session1" || resume["seq"] != float64(2) || resume["token"] != "bot-token" {
+			t.Errorf("bad resume: %v", resume)
+		}
+		dispatch(c, 3, "RESUMED", nil)
+		dispatch(c, 4, "MESSAGE_CREATE", map[string]any{"id": "m2", "channel_id": "c1", "content": "welcome back",
+			"author": map[string]any{"id": "2", "username": "bob"}})
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4014, "Disallowed intent(s)."))
+		c.ReadMessage()
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGatewayResumeAndFatalClose(t *testing.T) {
+	srv := fakeGateway(t)
+	var lock sync.Mutex
+	var readies []discordUser
+	var messages []discordMessage
+	g := &gateway{
+		url:   "ws" + strings.TrimPrefix(srv.URL, "http") + "/gateway",
+		token: "bot-token",
+		onReady: func(user discordUser) {
+			lock.Lock()
+			readies = append(readies, user)
+			lock.Unlock()
+		},
+		onDispatch: func(eventType string, data json.RawMessage) {
+			if eventType != "MESSAGE_CREATE" {
+				t.Errorf("unexpected dispatch %s", eventType)
+				return
+			}
+			var m discordMessage
+			if err := json.Unmarshal(data, &m); err != nil {
+				t.Error(err)
+			}
+			lock.Lock()
+			messages = append(messages, m)
+			lock.Unlock()
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	err := g.run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "disallowed intents") {
+		t.Fatalf("run returned %v, want the 4014 disallowed intents error", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("timed out instead of giving up on the fatal close code")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(readies) != 1 || readies[0].Username != "multibot" {
+		t.Errorf("onReady calls = %+v, want one for multibot", readies)
+	}
+	if len(messages) != 2 || messages[0].Content != "hello" || messages[0].Author.Username != "alice" ||
+		messages[1].Content != "welcome back" || messages[1].Author.Username != "bob" {
+		t.Errorf("messages = %+v, want alice's then bob's", messages)
+	}
+	if g.sessionID != "session1" || g.lastSeq() != int64(4) {
+		t.Errorf("session %q seq %v, want session1 and 4", g.sessionID, g.lastSeq())
+	}
+}
@@ -0,0 +1,172 @@
+package greetz
+
+import (
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	GREETZ_DELAY_FOR_COMMAND = 2 * time.Second //wait to greet when the user ran a command
+)
+
+var (
+	GREETZ = []string{
+		"yo #",
+		"yo #",
+		"yo yo #",
+		"yo yo yo #",
+		"yo yo yo # whats up!",
+		"heyo #",
+		"yooo # good to see u",
+		"good to see u #",
+		"hi #",
+		"hello #",
+		"helo #",
+		"whats up #",
+		"hey #, whats up?",
+		"welcome #",
+		"welcome in, #",
+		"greetings #",
+		"hows it going #",
+		"hey whats new with you #",
+		"how have you been #",
+		"#!",
+	}
+	GREETZ_ALSO = []string{
+		"also hi #",
+		"also hi # whats up!",
+		"also its good to see u #",
+		"also whats up #",
+		"also, whats up #?",
+		"also welcome #",
+		"also welcome in, #",
+		"also welcome to chat, #",
+		"also welcome to the stream, #",
+		"also hows it going #",
+		"also how have you been #",
+	}
+	GREETZ_WELCOME_BACK = []string{
+		"welcome back #",
+		"welcome back in, #",
+		"welcome back to chat, #",
+		"good to see u again #",
+		"hello again #",
+		"hi again #",
+	}
+	GREETZ_WELCOME_BACK_ALSO = []string{
+		"also welcome back #",
+		"also welcome back in, #",
+		"also welcome back to chat, #",
+		"also good to see u again #",
+		"also hello again #",
+		"also hi again #",
+	}
+
+	// Greet tracking: lastSeens stores last time a viewer talked (in ms), keyed by the platform they are greeted on + viewer,
+	// so the thresholds count per platform: chatting on twitch and then kick gets a greeting on each, unless
+	// greetz_reply_on sends both greetings to the same platform.
+	lastSeens     = make(map[string]int64)
+	lastSeensLock sync.Mutex
+	lastSeenPrune int64
+)
+
+// Greet greets the chatter if they have a nickname and haven't been seen on this platform for a while.
+// validCommand and shouldReply come from the command handler, so the greeting can follow the reply.
+func Greet(msg multiChat.ChatMessage, validCommand, shouldReply bool) {
+	viewer := msg.ViewerKey()
+	// Only greet if the user has a nickname set (like in Node).
+	nick := props.GetViewerProp(nil, viewer, "nickname")
+	if nick == nil {
+		return
+	}
+
+	greetzThreshold := props.GetChannelPropAs(nil, "greetz_threshold", props.DEFAULT_CHANNEL_PROPS["greetz_threshold"].(int64))
+	wbThreshold := props.GetChannelPropAs(nil, "greetz_wb_threshold", props.DEFAULT_CHANNEL_PROPS["greetz_wb_threshold"].(int64))
+	dest := replyPlatform(msg)
+
+	// Retrieve the last time we saw this viewer, on the platform they would be greeted on
+	key := dest + "/" + strings.ToLower(viewer)
+	lastSeensLock.Lock()
+	lastSeen, hasSeen := lastSeens[key]
+	nowMs := time.Now().UnixMilli()
+	lastSeens[key] = nowMs
+	if nowMs-lastSeenPrune > greetzThreshold {
+		// anyone not seen for longer than the threshold gets the full greeting anyway
+		for k, t := range lastSeens {
+			if nowMs-t > greetzThreshold {
+				delete(lastSeens, k)
+			}
+		}
+		lastSeenPrune = nowMs
+	}
+	lastSeensLock.Unlock()
+
+	log.Println("[greetz]", msg.Source, "|", viewer, "|", lastSeen, "|", hasSeen, "|", nowMs, "|", greetzThreshold, "|", wbThreshold)
+
+	var stock []string
+	if !hasSeen || (nowMs-lastSeen > greetzThreshold) {
+		// They’ve been away a long time => use initial greet
+		stock = GREETZ
+		if shouldReply && validCommand {
+			stock = GREETZ_ALSO
+		}
+	} else if nowMs-lastSeen > wbThreshold {
+		// They’ve been away for a shorter threshold => welcome back
+		stock = GREETZ_WELCOME_BACK
+		if shouldReply && validCommand {
+			stock = GREETZ_WELCOME_BACK_ALSO
+		}
+	} else {
+		return
+	}
+
+	text := parseGreetz(stock, msg.Username, viewer)
+	if shouldReply && validCommand {
+		// If they typed a valid command, wait 2s, then greet with "also" variant
+		go func() {
+			time.Sleep(GREETZ_DELAY_FOR_COMMAND)
+			say(dest, text)
+		}()
+	} else {
+		say(dest, text)
+	}
+}
+
+// replyPlatform is where to greet, set with the greetz_reply_on channel prop:
+// "source" greets on the platform the viewer is chatting on, "twitch" always greets on twitch
+func replyPlatform(msg multiChat.ChatMessage) string {
+	replyOn, _ := props.GetChannelProp(nil, "greetz_reply_on").(string)
+	if replyOn == "" || replyOn == "source" {
+		return msg.Source
+	}
+	return replyOn
+}
+
+func say(dest, text string) {
+	if err := chatSource.Say(dest, text); err != nil {
+		log.Printf("[greetz] error greeting on %s: %v", dest, err)
+	}
+}
+
+func parseGreetz(stock []string, username, viewer string) string {
+	nickname, _ := props.GetViewerProp(nil, viewer, "nickname").(string)
+	custom, _ := props.GetViewerProp(nil, viewer, "custom_greetz").(string)
+
+	message := ""
+	if custom != "" {
+		message = custom
+	} else {
+		message = stock[rand.Intn(len(stock))]
+	}
+	// In Node code: message.replaceAll('@', '@'+username).replaceAll('#', nickname)
+	res := strings.ReplaceAll(message, "@", "@"+username)
+	res = strings.ReplaceAll(res, "#", nickname)
+	return res
+}
@@ -0,0 +1,81 @@
+package greetz
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	THANKS_COOLDOWN = 5 * time.Second //per event kind, so a follow bot or a burst of tiktok likes doesn't flood the chat
+)
+
+var (
+	lastThanks     = make(map[string]time.Time) // event kind => when the bot last thanked for one
+	lastThanksLock sync.Mutex
+)
+
+// ThankEvent is a multiChat event listener that posts the message the event_thanks channel prop has for the event's
+// kind, e.g. {"follow": "thanks for the follow #!"}. @ and # work like in greetz, and {count}, {amount}, {months},
+// {tier}, {reward} and {recipient} are filled in from the event.
+func ThankEvent(msg multiChat.ChatMessage) {
+	if enabled, _ := props.GetChannelProp(nil, "enabled").(bool); !enabled {
+		return
+	}
+	var thanks map[string]string
+	if err := props.GetChannelPropJSON(nil, "event_thanks", &thanks); err != nil {
+		log.Println("[greetz] error reading event_thanks:", err)
+		return
+	}
+	template := strings.TrimSpace(thanks[msg.Event.Kind])
+	if template == "" || !takeThanksCooldown(msg.Event.Kind) {
+		return
+	}
+	dest := replyPlatform(msg)
+	// a webhook isn't a platform the bot can post on
+	if _, ok := chatSource.Get(dest); !ok {
+		dest = "twitch"
+	}
+	say(dest, thanksText(template, msg))
+}
+
+func takeThanksCooldown(kind string) bool {
+	lastThanksLock.Lock()
+	defer lastThanksLock.Unlock()
+	now := time.Now()
+	if now.Sub(lastThanks[kind]) < THANKS_COOLDOWN {
+		return false
+	}
+	lastThanks[kind] = now
+	return true
+}
+
+func thanksText(template string, msg multiChat.ChatMessage) string {
+	ev := msg.Event
+	username := msg.Username
+	if username == "" {
+		username = ev.User
+	}
+	nickname := ev.User
+	if msg.Username != "" {
+		if n, _ := props.GetViewerProp(nil, msg.ViewerKey(), "nickname").(string); n != "" {
+			nickname = n
+		}
+	}
+	res := strings.ReplaceAll(template, "@", "@"+username)
+	res = strings.ReplaceAll(res, "#", nickname)
+	return strings.NewReplacer(
+		"{count}", strconv.Itoa(ev.Count),
+		"{amount}", ev.Amount,
+		"{months}", strconv.Itoa(ev.Months),
+		"{tier}", ev.Tier,
+		"{reward}", ev.Reward,
+		"{recipient}", ev.Recipient,
+	).Replace(res)
+}
@@ -0,0 +1,167 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	LINK_CODE_TTL     = 10 * time.Minute //how long a code from the bot page can be used
+	LINK_CODE_LENGTH  = 6
+	LINK_CODE_CHARSET = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" //no 0/O or 1/I so codes are easy to type
+)
+
+var (
+	ErrInvalidCode = errors.New("invalid or expired code")
+	ErrSameViewer  = errors.New("that code is for this account")
+	ErrUnverified  = errors.New("anyone can take this name, register it first to link it")
+)
+
+// links map a platform identity (see Key) to the viewer record it belongs to
+func linksKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/viewer_links"
+}
+
+// the platform username at the time of linking, so the bot page can show something readable
+func linkNamesKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/viewer_link_names"
+}
+
+func codeKey(code string) string {
+	return "channels/" + env.TWITCH_CHANNEL + "/link_codes/" + code
+}
+
+// Key identifies a chatter on a platform, see multiChat.ChatterKey
+func Key(source, userID, username string) string {
+	return multiChat.ChatterKey(source, userID, username)
+}
+
+// SplitKey is the reverse of Key, username is only set for chatters that have no user ID
+func SplitKey(key string) (source, userID, username string) {
+	source, rest, _ := strings.Cut(key, ":")
+	if name, ok := strings.CutPrefix(rest, "name:"); ok {
+		return source, "", name
+	}
+	return source, rest, ""
+}
+
+func msgKey(msg *multiChat.ChatMessage) string {
+	return Key(msg.Source, msg.UserID, msg.Username)
+}
+
+// Resolve is the multiChat identity resolver, it points linked chatters at their viewer record
+func Resolve(msg *multiChat.ChatMessage) {
+	// whoever has the name right now isn't necessarily who linked it
+	if msg.Unverified {
+		return
+	}
+	viewer, err := redisClient.HGet(nil, linksKey(), msgKey(msg)).Result()
+	if err == nil && viewer != "" {
+		msg.Viewer = viewer
+	}
+	if msg.Nickname == "" || msg.Viewer != "" {
+		msg.Nickname, _ = props.GetViewerProp(nil, msg.ViewerKey(), "nickname").(string)
+	}
+}
+
+// CreateCode makes a one-time code that links whoever types "!link CODE" to the viewer
+func CreateCode(ctx context.Context, viewer string) (string, error) {
+	b := make([]byte, LINK_CODE_LENGTH)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = LINK_CODE_CHARSET[int(b[i])%len(LINK_CODE_CHARSET)]
+	}
+	code := string(b)
+	if err := redisClient.Set(ctx, codeKey(code), viewer, LINK_CODE_TTL).Err(); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Redeem links the chatter who sent msg to the viewer the code was made for, and returns that viewer
+func Redeem(ctx context.Context, code string, msg multiChat.ChatMessage) (string, error) {
+	if msg.Unverified {
+		return "", ErrUnverified
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return "", ErrInvalidCode
+	}
+	viewer, err := redisClient.Get(ctx, codeKey(code)).Result()
+	if err != nil {
+		return "", ErrInvalidCode
+	}
+	if msg.Source == "twitch" && strings.EqualFold(msg.Username, viewer) {
+		return "", ErrSameViewer
+	}
+	redisClient.Del(ctx, codeKey(code))
+	key := msgKey(&msg)
+	if err := redisClient.HSet(ctx, linksKey(), key, viewer).Err(); err != nil {
+		return "", err
+	}
+	redisClient.HSet(ctx, linkNamesKey(), key, msg.Username)
+	log.Printf("[identity] linked %s (%s) to %s", msg.Username, key, viewer)
+	return viewer, nil
+}
+
+// Unlink removes a platform identity's link, it goes back to using its own name
+func Unlink(ctx context.Context, key string) {
+	redisClient.HDel(ctx, linksKey(), key)
+	redisClient.HDel(ctx, linkNamesKey(), key)
+}
+
+// UnlinkChatter removes the link for whoever sent msg, returns false if they weren't linked
+func UnlinkChatter(ctx context.Context, msg multiChat.ChatMessage) bool {
+	if msg.Unverified {
+		return false
+	}
+	key := msgKey(&msg)
+	n, _ := redisClient.HDel(ctx, linksKey(), key).Result()
+	redisClient.HDel(ctx, linkNamesKey(), key)
+	return n > 0
+}
+
+// LinkedTo returns the viewer a platform identity is linked to, or "" if it isn't
+func LinkedTo(ctx context.Context, key string) string {
+	viewer, _ := redisClient.HGet(ctx, linksKey(), key).Result()
+	return viewer
+}
+
+// Link is a platform identity linked to a viewer
+type Link struct {
+	Key      string `json:"key"`
+	Source   string `json:"source"`
+	Username string `json:"username"`
+}
+
+// Links lists the platform identities linked to the viewer
+func Links(ctx context.Context, viewer string) []Link {
+	all, err := redisClient.HGetAll(ctx, linksKey()).Result()
+	if err != nil {
+		log.Println("[identity] list error:", err)
+		return []Link{}
+	}
+	names, _ := redisClient.HGetAll(ctx, linkNamesKey()).Result()
+	links := []Link{}
+	for key, v := range all {
+		if strings.EqualFold(v, viewer) {
+			source, _, _ := strings.Cut(key, ":")
+			links = append(links, Link{Key: key, Source: source, Username: names[key]})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Key < links[j].Key })
+	return links
+}
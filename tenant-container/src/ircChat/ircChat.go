@@ -0,0 +1,471 @@
+package ircChat
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	IRC_CONNECT_TIMEOUT = 30 * time.Second //how long Start waits for the server to welcome us
+	IRC_READ_TIMEOUT    = 5 * time.Minute  //servers ping every few minutes, no line at all for this long means the connection is dead
+	IRC_MAX_LINE_BYTES  = 400              //the message part of a PRIVMSG, IRC lines are 512 bytes including the prefix the server adds
+)
+
+var (
+	// bold, color, reset, reverse, italic, underline, strikethrough and monospace codes
+	formattingRegex = regexp.MustCompile(`\x03(\d{1,2}(,\d{1,2})?)?|[\x02\x0f\x16\x1d\x1f\x1e\x11]`)
+
+	ircConnected bool
+	ircConn      net.Conn
+	ircNick      string   // can differ from irc_nick if that was taken
+	ircChannels  []string // the channels we joined
+	ircServer    string
+	ircError     string
+	ircWriteMu   sync.Mutex // to guard writes to ircConn
+	ircCloseMu   sync.Mutex // to guard the vars above
+)
+
+// config is the irc_* channel props
+type config struct {
+	server       string
+	port         int
+	tls          bool
+	nick         string
+	channels     []string
+	saslUsername string
+	saslPassword string
+}
+
+// ircMessage is one line from the server, e.g. ":nick!user@host PRIVMSG #channel :hello"
+type ircMessage struct {
+	Tags    map[string]string
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// Nick is the nick part of the prefix
+func (m ircMessage) Nick() string {
+	nick, _, _ := strings.Cut(m.Prefix, "!")
+	return nick
+}
+
+func (m ircMessage) Param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	return ""
+}
+
+func parseLine(line string) ircMessage {
+	var m ircMessage
+	// we only ask for account-tag, so tag values are account names and never need unescaping
+	if strings.HasPrefix(line, "@") {
+		var tags string
+		tags, line, _ = strings.Cut(line[1:], " ")
+		m.Tags = make(map[string]string)
+		for _, tag := range strings.Split(tags, ";") {
+			key, value, _ := strings.Cut(tag, "=")
+			m.Tags[key] = value
+		}
+	}
+	if strings.HasPrefix(line, ":") {
+		m.Prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if m.Command == "" {
+			m.Command = strings.ToUpper(param)
+		} else if param != "" {
+			m.Params = append(m.Params, param)
+		}
+	}
+	return m
+}
+
+// the SASL password is kept out of the channel props since those are public
+func saslPasswordKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/irc_sasl_password"
+}
+
+// SetSASLPassword stores the password for irc_sasl_username, "" removes it. Restart the source afterwards.
+func SetSASLPassword(ctx context.Context, password string) error {
+	if password == "" {
+		return redisClient.Del(ctx, saslPasswordKey()).Err()
+	}
+	return redisClient.Set(ctx, saslPasswordKey(), password, 0).Err()
+}
+
+func HasSASLPassword(ctx context.Context) bool {
+	n, _ := redisClient.Exists(ctx, saslPasswordKey()).Result()
+	return n > 0
+}
+
+// Settings is what the bot page shows about irc, the password itself is never sent back
+func Settings(ctx context.Context) map[string]any {
+	return map[string]any{"sasl_password_set": HasSASLPassword(ctx)}
+}
+
+func loadConfig() config {
+	cfg := config{
+		port: props.GetChannelPropAs(nil, "irc_port", 6697),
+	}
+	cfg.server, _ = props.GetChannelProp(nil, "irc_server").(string)
+	cfg.tls, _ = props.GetChannelProp(nil, "irc_tls").(bool)
+	cfg.nick, _ = props.GetChannelProp(nil, "irc_nick").(string)
+	cfg.saslUsername, _ = props.GetChannelProp(nil, "irc_sasl_username").(string)
+	if err := props.GetChannelPropJSON(nil, "irc_channels", &cfg.channels); err != nil {
+		log.Println("[irc] error reading irc_channels:", err)
+	}
+	for i, channel := range cfg.channels {
+		if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&") {
+			cfg.channels[i] = "#" + channel
+		}
+	}
+	if cfg.nick == "" {
+		cfg.nick = env.TWITCH_BOT_USERNAME
+	}
+	if cfg.saslUsername != "" {
+		cfg.saslPassword, _ = redisClient.Get(context.Background(), saslPasswordKey()).Result()
+	}
+	return cfg
+}
+
+// Source is the irc ChatSource, registered with the supervisor in main
+var Source chatSource.ChatSource = source{}
+
+type source struct{}
+
+func (source) Name() string { return "irc" }
+
+func (source) Start() error {
+	cfg := loadConfig()
+	if cfg.server == "" || len(cfg.channels) == 0 {
+		return fmt.Errorf("no irc_server or irc_channels: %w", chatSource.ErrNotConfigured)
+	}
+	addr := net.JoinHostPort(cfg.server, strconv.Itoa(cfg.port))
+	log.Println("[irc] connecting to", addr)
+	dialer := &net.Dialer{Timeout: IRC_CONNECT_TIMEOUT}
+	var c net.Conn
+	var err error
+	if cfg.tls {
+		c, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: cfg.server})
+	} else {
+		c, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("connect error: %w", err)
+	}
+	ircCloseMu.Lock()
+	ircConn = c
+	ircNick = cfg.nick
+	ircChannels = nil
+	ircServer = addr
+	ircError = ""
+	ircCloseMu.Unlock()
+
+	// account-tag says which account sent a message, so a chatter keeps their ID when their nick changes
+	send(c, "CAP REQ :account-tag")
+	if cfg.saslUsername != "" {
+		send(c, "CAP REQ :sasl")
+	}
+	send(c, "NICK "+cfg.nick)
+	send(c, "USER "+cfg.nick+" 0 * :"+cfg.nick)
+
+	welcomed := make(chan error, 2) // the welcome and then an error, so the read loop never blocks if Start stopped waiting
+	go func() {
+		defer disconnect(c)
+		reader := bufio.NewReader(c)
+		for {
+			c.SetReadDeadline(time.Now().Add(IRC_READ_TIMEOUT))
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				log.Println("[irc] read error:", err)
+				welcomed <- fmt.Errorf("read error: %w", err)
+				return
+			}
+			if err := handleLine(c, cfg, parseLine(strings.TrimRight(line, "\r\n")), welcomed); err != nil {
+				log.Println("[irc]", err)
+				setError(err)
+				welcomed <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-welcomed:
+		if err != nil {
+			disconnect(c)
+			return err
+		}
+		log.Printf("[irc] connected to %s as %s", addr, cfg.nick)
+		//delay the message a bit to allow the disconnect message to come thru first
+		chatSource.SayAllLater("connected to irc chat: " + strings.Join(cfg.channels, " ") + " on " + cfg.server)
+		return nil
+	case <-time.After(IRC_CONNECT_TIMEOUT):
+		disconnect(c)
+		return fmt.Errorf("no welcome from %s after %v", addr, IRC_CONNECT_TIMEOUT)
+	}
+}
+
+// handleLine handles one line from the server, an error ends the connection
+func handleLine(c net.Conn, cfg config, m ircMessage, welcomed chan<- error) error {
+	switch m.Command {
+	case "PING":
+		send(c, "PONG :"+m.Param(0))
+	case "CAP":
+		sasl := strings.Contains(m.Param(2), "sasl")
+		switch {
+		case m.Param(1) == "ACK" && sasl:
+			send(c, "AUTHENTICATE PLAIN")
+		case m.Param(1) == "NAK" && sasl:
+			return fmt.Errorf("the server doesn't support SASL")
+		case cfg.saslUsername == "":
+			// account-tag was answered, with SASL the CAP END waits for the login
+			send(c, "CAP END")
+		}
+	case "AUTHENTICATE":
+		if m.Param(0) == "+" {
+			// authzid \0 authcid \0 password, base64'd
+			plain := cfg.saslUsername + "\x00" + cfg.saslUsername + "\x00" + cfg.saslPassword
+			send(c, "AUTHENTICATE "+base64.StdEncoding.EncodeToString([]byte(plain)))
+		}
+	case "903": // RPL_SASLSUCCESS
+		send(c, "CAP END")
+	case "902", "904", "905", "906": // SASL failed
+		return fmt.Errorf("SASL login failed: %s", m.Param(len(m.Params)-1))
+	case "001": // RPL_WELCOME
+		ircCloseMu.Lock()
+		ircNick = m.Param(0)
+		ircConnected = true
+		ircCloseMu.Unlock()
+		send(c, "JOIN "+strings.Join(cfg.channels, ","))
+		welcomed <- nil
+	case "433": // ERR_NICKNAMEINUSE
+		ircCloseMu.Lock()
+		ircNick += "_"
+		nick := ircNick
+		ircCloseMu.Unlock()
+		log.Println("[irc] nick taken, trying", nick)
+		send(c, "NICK "+nick)
+	case "JOIN":
+		if m.Nick() == currentNick() {
+			ircCloseMu.Lock()
+			ircChannels = append(ircChannels, m.Param(0))
+			ircCloseMu.Unlock()
+			log.Println("[irc] joined", m.Param(0))
+		}
+	case "PART", "KICK":
+		channel := m.Param(0)
+		if (m.Command == "PART" && m.Nick() == currentNick()) || (m.Command == "KICK" && m.Param(1) == currentNick()) {
+			ircCloseMu.Lock()
+			ircChannels = slices.DeleteFunc(ircChannels, func(ch string) bool { return strings.EqualFold(ch, channel) })
+			ircCloseMu.Unlock()
+			log.Println("[irc] left", channel)
+		}
+	case "NICK":
+		if m.Nick() == currentNick() {
+			ircCloseMu.Lock()
+			ircNick = m.Param(0)
+			ircCloseMu.Unlock()
+		}
+	case "471", "473", "474", "475": // can't join: full, invite only, banned, needs a key
+		log.Printf("[irc] can't join %s: %s", m.Param(1), m.Param(2))
+	case "ERROR":
+		return fmt.Errorf("server closed the connection: %s", m.Param(0))
+	case "PRIVMSG":
+		handlePrivmsg(m)
+	}
+	return nil
+}
+
+func handlePrivmsg(m ircMessage) {
+	if msg, ok := privmsgChat(m); ok {
+		multiChat.SendChatMessage(msg)
+	}
+}
+
+// privmsgChat turns a PRIVMSG into a chat message, ok is false if it isn't channel chat
+func privmsgChat(m ircMessage) (msg multiChat.ChatMessage, ok bool) {
+	channel, text := m.Param(0), m.Param(1)
+	// private messages to the bot aren't part of the chat
+	if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&") {
+		return msg, false
+	}
+	// CTCP ACTION is /me, other CTCP like VERSION isn't chat
+	if strings.HasPrefix(text, "\x01") {
+		action, ok := strings.CutPrefix(strings.Trim(text, "\x01"), "ACTION ")
+		if !ok {
+			return msg, false
+		}
+		text = action
+	}
+	text = strings.TrimSpace(formattingRegex.ReplaceAllString(text, ""))
+	if text == "" || m.Nick() == "" {
+		return msg, false
+	}
+	// the account when the server tags it. Anyone can take a nick that isn't registered, so without an account the
+	// chatter is only known by name and can't be linked. The hostmask isn't an ID and shouldn't be shown anywhere.
+	account := m.Tags["account"]
+	if account == "*" {
+		account = ""
+	}
+	return multiChat.ChatMessage{
+		UserID:     account,
+		Source:     "irc",
+		Username:   m.Nick(),
+		Emotes:     make(map[string][]string),
+		Text:       text,
+		Stream:     channel,
+		Unverified: account == "",
+	}, true
+}
+
+func send(c net.Conn, line string) error {
+	ircWriteMu.Lock()
+	defer ircWriteMu.Unlock()
+	_, err := c.Write([]byte(line + "\r\n"))
+	return err
+}
+
+func currentNick() string {
+	ircCloseMu.Lock()
+	defer ircCloseMu.Unlock()
+	return ircNick
+}
+
+func setError(err error) {
+	ircCloseMu.Lock()
+	defer ircCloseMu.Unlock()
+	ircError = err.Error()
+}
+
+// HandleChat relays chat from the sources listed in irc_relay_sources into the irc channels
+func HandleChat(msg multiChat.ChatMessage) {
+	if msg.Source == "irc" || chatSource.IsFromBot(msg) || !Source.Status().Connected {
+		return
+	}
+	var relaySources []string
+	if err := props.GetChannelPropJSON(nil, "irc_relay_sources", &relaySources); err != nil {
+		log.Println("[irc] error reading irc_relay_sources:", err)
+		return
+	}
+	if !slices.Contains(relaySources, msg.Source) {
+		return
+	}
+	name := msg.Username
+	if msg.Nickname != "" {
+		name = msg.Nickname
+	}
+	if err := chatSource.Say("irc", fmt.Sprintf("[%s] %s: %s", msg.Source, name, msg.Text)); err != nil {
+		log.Println("[irc] relay error:", err)
+	}
+}
+
+func (source) Stop() {
+	ircCloseMu.Lock()
+	c := ircConn
+	ircCloseMu.Unlock()
+	disconnect(c)
+}
+
+// disconnect closes c if it is still the current connection, so an old read loop can't close a newer connection
+func disconnect(c net.Conn) {
+	ircCloseMu.Lock()
+	defer ircCloseMu.Unlock()
+	if ircConn != nil && ircConn == c {
+		log.Println("[irc] disconnecting")
+		wasConnected := ircConnected
+		send(c, "QUIT :bye")
+		ircConn.Close()
+		ircConn = nil
+		ircConnected = false
+		ircChannels = nil
+		if wasConnected {
+			go chatSource.SayAll("disconnected from irc chat")
+		}
+	}
+}
+
+// Say posts into every channel the bot joined, long messages are split into several lines
+func (source) Say(text string) error {
+	ircCloseMu.Lock()
+	c, channels := ircConn, slices.Clone(ircChannels)
+	ircCloseMu.Unlock()
+	if c == nil || len(channels) == 0 {
+		return fmt.Errorf("not connected")
+	}
+	for _, line := range splitText(text) {
+		for _, channel := range channels {
+			if err := send(c, "PRIVMSG "+channel+" :"+line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// splitText breaks text into lines of at most IRC_MAX_LINE_BYTES, without cutting a character in half
+func splitText(text string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		for len(line) > IRC_MAX_LINE_BYTES {
+			cut := IRC_MAX_LINE_BYTES
+			if space := strings.LastIndex(line[:cut], " "); space > 0 {
+				cut = space
+			} else {
+				for cut > 0 && !utf8RuneStart(line[cut]) {
+					cut--
+				}
+			}
+			lines = append(lines, line[:cut])
+			line = strings.TrimLeft(line[cut:], " ")
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func utf8RuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+func (source) Status() chatSource.Status {
+	ircCloseMu.Lock()
+	defer ircCloseMu.Unlock()
+	return chatSource.Status{
+		Connected: ircConnected,
+		Details: map[string]any{
+			"server":   ircServer,
+			"nick":     ircNick,
+			"channels": ircChannels,
+			"error":    ircError,
+		},
+	}
+}
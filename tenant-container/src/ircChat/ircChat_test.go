@@ -0,0 +1,192 @@
+package ircChat
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+
+	"multibot/tenant-container/src/identity"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want ircMessage
+	}{
+		{"PING :irc.example.net", ircMessage{Command: "PING", Params: []string{"irc.example.net"}}},
+		{":alice!al@host.example PRIVMSG #chan :hello there",
+			ircMessage{Prefix: "alice!al@host.example", Command: "PRIVMSG", Params: []string{"#chan", "hello there"}}},
+		{"@account=alice;time=2024-01-01T00:00:00Z :alice!al@host PRIVMSG #chan ::)",
+			ircMessage{Tags: map[string]string{"account": "alice", "time": "2024-01-01T00:00:00Z"},
+				Prefix: "alice!al@host", Command: "PRIVMSG", Params: []string{"#chan", ":)"}}},
+		{":server 001 bot :Welcome", ircMessage{Prefix: "server", Command: "001", Params: []string{"bot", "Welcome"}}},
+		{":server cap  *  ACK :sasl", ircMessage{Prefix: "server", Command: "CAP", Params: []string{"*", "ACK", "sasl"}}},
+	}
+	for _, test := range tests {
+		if got := parseLine(test.line); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseLine(%q) = %+v, want %+v", test.line, got, test.want)
+		}
+	}
+	if nick := parseLine(":alice!al@host PRIVMSG #chan :hi").Nick(); nick != "alice" {
+		t.Errorf("Nick() = %q, want alice", nick)
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	if got := splitText("one\r\ntwo\n\nthree"); !reflect.DeepEqual(got, []string{"one", "two", "three"}) {
+		t.Errorf("splitText with newlines = %q", got)
+	}
+	words := strings.Repeat("word ", 200)
+	for _, line := range splitText(words) {
+		if len(line) > IRC_MAX_LINE_BYTES || strings.HasPrefix(line, " ") || strings.HasSuffix(line, "word wo") {
+			t.Errorf("bad line of %d bytes: %q", len(line), line)
+		}
+	}
+	if got := strings.TrimSpace(strings.Join(splitText(words), " ")); got != strings.TrimSpace(words) {
+		t.Error("splitting at spaces lost text")
+	}
+	// no spaces to cut at, every line has to end on a whole character
+	emoji := strings.Repeat("😀", 300)
+	lines := splitText(emoji)
+	for _, line := range lines {
+		if len(line) > IRC_MAX_LINE_BYTES || !utf8.ValidString(line) {
+			t.Errorf("bad line of %d bytes, valid utf8 %v", len(line), utf8.ValidString(line))
+		}
+	}
+	if strings.Join(lines, "") != emoji {
+		t.Error("splitting emoji lost text")
+	}
+}
+
+// recordConn keeps what was sent instead of writing it anywhere
+type recordConn struct {
+	net.Conn
+	sent []string
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.sent = append(c.sent, strings.TrimSuffix(string(b), "\r\n"))
+	return len(b), nil
+}
+
+func TestSASL(t *testing.T) {
+	cfg := config{nick: "bot", channels: []string{"#chan"}, saslUsername: "botaccount", saslPassword: "hunter2"}
+	c := &recordConn{}
+	welcomed := make(chan error, 2)
+	lines := []string{
+		":server CAP * NAK :account-tag",
+		":server CAP * ACK :sasl",
+		"AUTHENTICATE +",
+		":server 903 bot :SASL authentication successful",
+		":server 001 bot :Welcome",
+	}
+	for _, line := range lines {
+		if err := handleLine(c, cfg, parseLine(line), welcomed); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+	}
+	plain := base64.StdEncoding.EncodeToString([]byte("botaccount\x00botaccount\x00hunter2"))
+	want := []string{"AUTHENTICATE PLAIN", "AUTHENTICATE " + plain, "CAP END", "JOIN #chan"}
+	if !reflect.DeepEqual(c.sent, want) {
+		t.Errorf("sent %q, want %q", c.sent, want)
+	}
+	if err := <-welcomed; err != nil {
+		t.Errorf("welcomed with %v", err)
+	}
+
+	err := handleLine(c, cfg, parseLine(":server 904 bot :SASL authentication failed"), welcomed)
+	if err == nil || !strings.Contains(err.Error(), "SASL authentication failed") {
+		t.Errorf("904 returned %v, want the SASL failure", err)
+	}
+	err = handleLine(c, cfg, parseLine(":server CAP * NAK :sasl"), welcomed)
+	if err == nil {
+		t.Error("no error when the server doesn't support SASL")
+	}
+}
+
+func TestCapEndWithoutSASL(t *testing.T) {
+	c := &recordConn{}
+	if err := handleLine(c, config{nick: "bot"}, parseLine(":server CAP * ACK :account-tag"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.sent, []string{"CAP END"}) {
+		t.Errorf("sent %q, want CAP END", c.sent)
+	}
+}
+
+func TestPrivmsgChat(t *testing.T) {
+	tests := []struct {
+		line       string
+		ok         bool
+		userID     string
+		username   string
+		text       string
+		unverified bool
+	}{
+		{":alice!al@host.example PRIVMSG #chan :\x02hello\x02 \x0304there", true, "", "alice", "hello there", true},
+		{":alice!al@host.example PRIVMSG #chan :\x01ACTION waves\x01", true, "", "alice", "waves", true},
+		{"@account=alice :alice_!al@host.example PRIVMSG #chan :hi", true, "alice", "alice_", "hi", false},
+		{"@account=* :guest!g@host.example PRIVMSG #chan :hi", true, "", "guest", "hi", true},
+		{":alice!al@host.example PRIVMSG #chan :\x01VERSION\x01", false, "", "", "", false},
+		{":alice!al@host.example PRIVMSG bot :psst", false, "", "", "", false},
+		{":alice!al@host.example PRIVMSG #chan :\x02\x02", false, "", "", "", false},
+	}
+	for _, test := range tests {
+		msg, ok := privmsgChat(parseLine(test.line))
+		if ok != test.ok {
+			t.Errorf("%q: ok = %v, want %v", test.line, ok, test.ok)
+			continue
+		}
+		if ok && (msg.UserID != test.userID || msg.Username != test.username || msg.Text != test.text ||
+			msg.Unverified != test.unverified || msg.Source != "irc" || msg.Stream != "#chan") {
+			t.Errorf("%q: got %+v", test.line, msg)
+		}
+	}
+}
+
+// an unregistered nick is only a name, it must not get the link of the account with that name
+func TestUnregisteredNickNotLinked(t *testing.T) {
+	mr := miniredis.RunT(t)
+	env.STATE_DB_URL = "redis://" + mr.Addr()
+	env.TWITCH_CHANNEL = "test"
+	redisClient.Init()
+	ctx := context.Background()
+
+	account, _ := privmsgChat(parseLine("@account=alice :alice!al@host.example PRIVMSG #chan :!link"))
+	code, err := identity.CreateCode(ctx, "alicetwitch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := identity.Redeem(ctx, code, account); err != nil {
+		t.Fatal(err)
+	}
+	identity.Resolve(&account)
+	if account.Viewer != "alicetwitch" {
+		t.Fatalf("the account resolved to %q, want alicetwitch", account.Viewer)
+	}
+
+	nick, _ := privmsgChat(parseLine(":alice!other@elsewhere.example PRIVMSG #chan :hi"))
+	identity.Resolve(&nick)
+	if nick.Viewer != "" {
+		t.Errorf("the unregistered nick resolved to %q", nick.Viewer)
+	}
+	code, _ = identity.CreateCode(ctx, "mallory")
+	if _, err := identity.Redeem(ctx, code, nick); err != identity.ErrUnverified {
+		t.Errorf("linking the unregistered nick returned %v, want ErrUnverified", err)
+	}
+	if identity.UnlinkChatter(ctx, nick) {
+		t.Error("the unregistered nick unlinked the account")
+	}
+	if identity.LinkedTo(ctx, identity.Key("irc", "alice", "")) != "alicetwitch" {
+		t.Error("the account's link is gone")
+	}
+}
@@ -1,117 +1,390 @@
 package kickChat
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
-	kickchatwrapper "github.com/johanvandegriff/kick-chat-wrapper"
+	"github.com/gorilla/websocket"
+	"golang.org/x/oauth2"
 
+	"multibot/common/src/env"
+
+	"multibot/tenant-container/src/chatSource"
 	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/platformAuth"
 	"multibot/tenant-container/src/props"
 )
 
+const (
+	// kick chat is served over pusher. Kick has no documented key for reading chat, so this is the one the kick
+	// website's own chat uses, if kick rotates it set KICK_PUSHER_KEY to the new one instead of rebuilding
+	KICK_PUSHER_KEY = "32cbd69e4b950bf97679"
+	KICK_PUSHER_URL = "wss://ws-us2.pusher.com/app/%s?protocol=7&client=js&version=8.4.0-rc2&flash=false"
+	// sending goes through the official API with the channel owner's token
+	KICK_CHAT_API_URL      = "https://api.kick.com/public/v1/chat"
+	KICK_BANS_API_URL      = "https://api.kick.com/public/v1/moderation/bans"
+	KICK_USERS_API_URL     = "https://api.kick.com/public/v1/users"
+	KICK_OAUTH_SCOPES      = "chat:write user:read moderation:ban moderation:chat_message:manage"
+	KICK_MAX_TIMEOUT_MINS  = 10080 //kick timeouts are at most a week
+	KICK_HTTP_TIMEOUT      = 10 * time.Second
+	KICK_MAX_MESSAGE_RUNES = 500
+	KICK_EMOTE_URL         = "https://files.kick.com/emotes/%s/fullsize"
+)
+
+// OAuthEndpoint is kick's oauth endpoint, kick requires PKCE
+var OAuthEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://id.kick.com/oauth/authorize",
+	TokenURL: "https://id.kick.com/oauth/token",
+}
+
 var (
-	kickConnected    bool
-	kickClient     *kickchatwrapper.Client
+	kickEmoteRegex = regexp.MustCompile(`\[emote:(\d+):([^\]]*)\]`)
+
+	kickConnected bool
+	kickConn      *websocket.Conn
+	kickWriteMu   sync.Mutex // to guard writes to kickConn
+	kickCloseMu   sync.Mutex // to guard kickConn and kickConnected
 )
 
-func ConnectToKickLoop() {
-	for {
-		ConnectToKick()
-		time.Sleep(1 * time.Minute)
-	}
+// pusherMessage is the envelope for everything on the pusher websocket, Data is usually a JSON string containing more JSON
+type pusherMessage struct {
+	Event   string          `json:"event"`
+	Data    json.RawMessage `json:"data"`
+	Channel string          `json:"channel,omitempty"`
 }
 
-func ConnectToKick() {
-	if !props.GetChannelProp(nil, "enabled").(bool) {
-		log.Println("[kick] bot is disabled, will not connect")
-		return
-	}
-	if kickConnected {
-		log.Println("[kick] already connected, will not connect")
-		return
-	}
+type kickUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Slug     string `json:"slug"`
+}
+
+type kickChatMessage struct {
+	ID         string `json:"id"`
+	ChatroomID int    `json:"chatroom_id"`
+	Content    string `json:"content"`
+	Type       string `json:"type"`
+	Sender     struct {
+		kickUser
+		Identity struct {
+			Color  string `json:"color"`
+			Badges []struct {
+				Type string `json:"type"`
+			} `json:"badges"`
+		} `json:"identity"`
+	} `json:"sender"`
+}
+
+type kickMessageDeleted struct {
+	ID      string `json:"id"`
+	Message struct {
+		ID string `json:"id"`
+	} `json:"message"`
+}
+
+type kickSubscription struct {
+	Username string `json:"username"`
+	Months   int    `json:"months"`
+}
+
+type kickGiftedSubscriptions struct {
+	GifterUsername  string   `json:"gifter_username"`
+	GiftedUsernames []string `json:"gifted_usernames"`
+}
+
+type kickStreamHost struct {
+	HostUsername    string `json:"host_username"`
+	NumberViewers   int    `json:"number_viewers"`
+	OptionalMessage string `json:"optional_message"`
+}
+
+type kickUserBanned struct {
+	ID        string   `json:"id"`
+	User      kickUser `json:"user"`
+	BannedBy  kickUser `json:"banned_by"`
+	Permanent bool     `json:"permanent"`
+}
+
+// Source is the kick ChatSource, registered with the supervisor in main
+var Source chatSource.ChatSource = source{}
+
+type source struct{}
+
+func (source) Name() string { return "kick" }
+
+func (source) Start() error {
 	kcRaw := props.GetChannelProp(nil, "kick_chatroom_id")
 	kickChatroomID, _ := kcRaw.(string)
 	if kickChatroomID == "" {
-		log.Println("[kick] no chatroom ID, skipping connect")
-		return
+		return fmt.Errorf("no chatroom ID: %w", chatSource.ErrNotConfigured)
 	}
-	log.Println("[kick] connecting...")
-
-	c, err := kickchatwrapper.NewClient()
+	channelID, err := strconv.Atoi(kickChatroomID)
 	if err != nil {
-		log.Println("[kick] new client error:", err)
-		return
+		return fmt.Errorf("invalid chatroom ID: %w", err)
 	}
-	channelID, err := parseChannelID(kickChatroomID)
+	log.Println("[kick] connecting...")
+
+	c, _, err := websocket.DefaultDialer.Dial(pusherURL(), nil)
 	if err != nil {
-		log.Println("[kick] invalid chatroom ID:", err)
-		return
+		return fmt.Errorf("ws connect error: %w", err)
 	}
-	err = c.JoinChannelByID(channelID)
-	if err != nil {
-		log.Println("[kick] join error:", err)
-		return
+	subscribe, _ := json.Marshal(map[string]any{
+		"event": "pusher:subscribe",
+		"data": map[string]any{
+			"channel": fmt.Sprintf("chatrooms.%d.v2", channelID),
+			"auth":    "",
+		},
+	})
+	if err := c.WriteMessage(websocket.TextMessage, subscribe); err != nil {
+		c.Close()
+		return fmt.Errorf("join error: %w", err)
 	}
-	msgChan := c.ListenForMessages()
-	kickClient = c
+	kickCloseMu.Lock()
+	kickConn = c
 	kickConnected = true
+	kickCloseMu.Unlock()
 	log.Println("[kick] connected to chatroom ID", channelID)
 
 	go func() {
-		defer DisconnectFromKick()
-		for m := range msgChan {
-			if !kickConnected {
-				log.Println("[kick] got message while not connected:", m)
-				continue
+		defer disconnect(c)
+		for {
+			_, raw, err := c.ReadMessage()
+			if err != nil {
+				log.Println("[kick] read error:", err)
+				return
 			}
-			// m.Sender.Username, m.Content, m.Sender.Identity.Color, etc.
-			if m.Sender.Username == "" {
+			var msg pusherMessage
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				log.Println("[kick] parse err:", err)
 				continue
 			}
-			multiChat.SendChat("kick", m.Sender.Username, "", m.Sender.Identity.Color, m.Content, nil)
+			handlePusherMessage(c, msg)
 		}
 	}()
+	return nil
 }
 
-func parseChannelID(s string) (int, error) {
-	var id int
-	_, err := fmt.Sscanf(s, "%d", &id)
-	return id, err
+func pusherURL() string {
+	key := KICK_PUSHER_KEY
+	if env.KICK_PUSHER_KEY != "" {
+		key = env.KICK_PUSHER_KEY
+	}
+	return fmt.Sprintf(KICK_PUSHER_URL, url.PathEscape(key))
 }
 
-func DisconnectFromKick() {
-	if kickConnected && kickClient != nil {
-		log.Println("[kick] disconnecting")
+func handlePusherMessage(c *websocket.Conn, msg pusherMessage) {
+	// app events double-encode their data as a JSON string
+	data := []byte(msg.Data)
+	var s string
+	if json.Unmarshal(msg.Data, &s) == nil {
+		data = []byte(s)
+	}
+
+	switch msg.Event {
+	case "pusher:ping":
+		kickWriteMu.Lock()
+		c.WriteMessage(websocket.TextMessage, []byte(`{"event":"pusher:pong","data":{}}`))
+		kickWriteMu.Unlock()
+	case `App\Events\ChatMessageEvent`:
+		var m kickChatMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Println("[kick] chat parse err:", err)
+			return
+		}
+		if m.Sender.Username == "" {
+			return
+		}
+		roles := []string{}
+		for _, badge := range m.Sender.Identity.Badges {
+			switch badge.Type {
+			case "broadcaster":
+				roles = append(roles, multiChat.ROLE_BROADCASTER)
+			case "moderator":
+				roles = append(roles, multiChat.ROLE_MOD)
+			case "vip", "og":
+				roles = append(roles, multiChat.ROLE_VIP)
+			case "subscriber", "founder", "sub_gifter":
+				roles = append(roles, multiChat.ROLE_SUB)
+			}
+		}
+		text, emotes := parseEmotes(m.Content)
+		chatMsg := multiChat.ChatMessage{
+			PlatformID: m.ID,
+			UserID:     strconv.Itoa(m.Sender.ID),
+			Source:     "kick",
+			Username:   m.Sender.Username,
+			Color:      m.Sender.Identity.Color,
+			Emotes:     emotes,
+			Text:       text,
+			Role:       multiChat.HighestRole(roles...),
+		}
+		multiChat.SendChatMessage(chatMsg)
+	case `App\Events\SubscriptionEvent`:
+		var m kickSubscription
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Println("[kick] subscription parse err:", err)
+			return
+		}
+		event := &multiChat.Event{Kind: multiChat.EVENT_SUB, User: m.Username, Months: m.Months,
+			SystemMessage: m.Username + " subscribed"}
+		if m.Months > 1 {
+			event.Kind = multiChat.EVENT_RESUB
+			event.SystemMessage = fmt.Sprintf("%s subscribed for %d months", m.Username, m.Months)
+		}
+		multiChat.SendEvent(multiChat.ChatMessage{Source: "kick", Username: m.Username, Event: event})
+	case `App\Events\GiftedSubscriptionsEvent`:
+		var m kickGiftedSubscriptions
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Println("[kick] gifted subscriptions parse err:", err)
+			return
+		}
+		event := &multiChat.Event{Kind: multiChat.EVENT_MYSTERY_GIFT, User: m.GifterUsername, Count: len(m.GiftedUsernames),
+			SystemMessage: fmt.Sprintf("%s gifted %d subs", m.GifterUsername, len(m.GiftedUsernames))}
+		if len(m.GiftedUsernames) == 1 {
+			event.Kind = multiChat.EVENT_SUB_GIFT
+			event.Recipient = m.GiftedUsernames[0]
+			event.SystemMessage = fmt.Sprintf("%s gifted a sub to %s", m.GifterUsername, m.GiftedUsernames[0])
+		}
+		multiChat.SendEvent(multiChat.ChatMessage{Source: "kick", Username: m.GifterUsername, Event: event})
+	case `App\Events\StreamHostEvent`:
+		var m kickStreamHost
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Println("[kick] host parse err:", err)
+			return
+		}
+		multiChat.SendEvent(multiChat.ChatMessage{
+			Source:   "kick",
+			Username: m.HostUsername,
+			Text:     m.OptionalMessage,
+			Event: &multiChat.Event{Kind: multiChat.EVENT_HOST, User: m.HostUsername, Count: m.NumberViewers,
+				SystemMessage: fmt.Sprintf("%s is hosting with %d viewers", m.HostUsername, m.NumberViewers)},
+		})
+	case `App\Events\MessageDeletedEvent`:
+		var m kickMessageDeleted
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Println("[kick] delete parse err:", err)
+			return
+		}
+		multiChat.DeleteMessage("kick", m.Message.ID)
+	case `App\Events\UserBannedEvent`:
+		var m kickUserBanned
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Println("[kick] ban parse err:", err)
+			return
+		}
+		userID := ""
+		if m.User.ID != 0 {
+			userID = strconv.Itoa(m.User.ID)
+		}
+		multiChat.PurgeUser("kick", userID, m.User.Username)
+	case `App\Events\ChatroomClearEvent`:
+		log.Println("[kick] chat was cleared on kick, use !clear to clear the multichat")
+	default:
+		log.Printf("[kick] unhandled event %s: %s", msg.Event, string(data))
+	}
+}
 
-		// Create a channel to signal when done
-		done := make(chan struct{})
-
-		// Attempt to close in a goroutine
-		go func() {
-			kickClient.Close()
-			close(done)
-		}()
-
-		// Wait for close or timeout
-		select {
-		case <-done:
-			log.Println("[kick] disconnected successfully")
-		case <-time.After(5 * time.Second): // Timeout duration
-			log.Println("[kick] disconnect timeout, forcing disconnect")
+// parseEmotes replaces kick's [emote:id:name] tokens with the name, and maps the emote URL to
+// where the name is in the text (in runes), the same way twitch emotes are sent
+func parseEmotes(content string) (string, map[string][]string) {
+	emotes := make(map[string][]string)
+	var sb strings.Builder
+	pos := 0
+	last := 0
+	for _, m := range kickEmoteRegex.FindAllStringSubmatchIndex(content, -1) {
+		before := content[last:m[0]]
+		sb.WriteString(before)
+		pos += utf8.RuneCountInString(before)
+		id, name := content[m[2]:m[3]], content[m[4]:m[5]]
+		if name == "" {
+			name = id
 		}
+		length := utf8.RuneCountInString(name)
+		url := fmt.Sprintf(KICK_EMOTE_URL, id)
+		emotes[url] = append(emotes[url], fmt.Sprintf("%d-%d", pos, pos+length-1))
+		sb.WriteString(name)
+		pos += length
+		last = m[1]
+	}
+	sb.WriteString(content[last:])
+	return sb.String(), emotes
+}
 
-		// Ensure cleanup
-		kickClient = nil
+func (source) Stop() {
+	kickCloseMu.Lock()
+	c := kickConn
+	kickCloseMu.Unlock()
+	disconnect(c)
+}
+
+// disconnect closes c if it is still the current connection, so an old read loop can't close a newer connection
+func disconnect(c *websocket.Conn) {
+	kickCloseMu.Lock()
+	defer kickCloseMu.Unlock()
+	if kickConnected && kickConn != nil && kickConn == c {
+		log.Println("[kick] disconnecting")
+		kickConn.Close()
+		kickConn = nil
 		kickConnected = false
 	}
 }
 
-func GetStatus() map[string]interface{} {
-	return map[string]interface{}{
-		"connected":  kickConnected,
-		"kickClient": fmt.Sprintf("%#v", kickClient),
+// Say posts into the chat with the channel owner's account, which they connect on the bot page
+func (source) Say(text string) error {
+	kickCloseMu.Lock()
+	connected := kickConnected && kickConn != nil
+	kickCloseMu.Unlock()
+	if !connected {
+		return fmt.Errorf("not connected")
+	}
+	tok, err := platformAuth.Token(nil, "kick")
+	if err != nil {
+		return err
+	}
+	if runes := []rune(text); len(runes) > KICK_MAX_MESSAGE_RUNES {
+		text = string(runes[:KICK_MAX_MESSAGE_RUNES])
+	}
+	// type "bot" posts to the channel the token belongs to
+	body, _ := json.Marshal(map[string]any{"type": "bot", "content": text})
+	req, err := http.NewRequest(http.MethodPost, KICK_CHAT_API_URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tok.SetAuthHeader(req)
+	client := &http.Client{Timeout: KICK_HTTP_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("send status %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+func (source) Status() chatSource.Status {
+	kickCloseMu.Lock()
+	defer kickCloseMu.Unlock()
+	details := map[string]any{"logged_in": platformAuth.IsLoggedIn(nil, "kick")}
+	if kickConn != nil {
+		details["remote_addr"] = kickConn.RemoteAddr().String()
+	}
+	return chatSource.Status{
+		Connected: kickConnected,
+		Details:   details,
 	}
 }
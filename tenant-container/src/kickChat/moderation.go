@@ -0,0 +1,94 @@
+package kickChat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/platformAuth"
+)
+
+// apiRequest calls the official API with the channel owner's token and decodes the response into out
+func apiRequest(method, u string, body, out any) error {
+	tok, err := platformAuth.Token(nil, "kick")
+	if err != nil {
+		return err
+	}
+	var reqBody io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tok.SetAuthHeader(req)
+	client := &http.Client{Timeout: KICK_HTTP_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kick api status %d: %s", resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// broadcasterID is the user ID of whoever the token belongs to, which is the channel owner
+func broadcasterID() (int, error) {
+	var data struct {
+		Data []struct {
+			UserID int `json:"user_id"`
+		} `json:"data"`
+	}
+	if err := apiRequest(http.MethodGet, KICK_USERS_API_URL, nil, &data); err != nil {
+		return 0, err
+	}
+	if len(data.Data) == 0 {
+		return 0, fmt.Errorf("could not get the channel's user ID")
+	}
+	return data.Data[0].UserID, nil
+}
+
+func (source) DeleteMessage(msg multiChat.ChatMessage) error {
+	if msg.PlatformID == "" {
+		return fmt.Errorf("no kick message ID")
+	}
+	return apiRequest(http.MethodDelete, KICK_CHAT_API_URL+"/"+url.PathEscape(msg.PlatformID), nil, nil)
+}
+
+func (source) Ban(msg multiChat.ChatMessage, duration time.Duration, reason string) error {
+	userID, err := strconv.Atoi(msg.UserID)
+	if err != nil {
+		return fmt.Errorf("no kick user ID")
+	}
+	broadcaster, err := broadcasterID()
+	if err != nil {
+		return err
+	}
+	body := map[string]any{"broadcaster_user_id": broadcaster, "user_id": userID}
+	if reason != "" {
+		body["reason"] = reason
+	}
+	if duration > 0 {
+		// kick timeouts are in whole minutes
+		body["duration"] = min(max(int((duration+time.Minute-1)/time.Minute), 1), KICK_MAX_TIMEOUT_MINS)
+	}
+	return apiRequest(http.MethodPost, KICK_BANS_API_URL, body, nil)
+}
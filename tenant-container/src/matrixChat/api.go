@@ -0,0 +1,158 @@
+package matrixChat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// client is the little of the matrix client-server API that we use, see https://spec.matrix.org/latest/client-server-api/
+type client struct {
+	homeserver  string // e.g. https://matrix.org
+	accessToken string
+	http        *http.Client
+}
+
+// apiError is the error body the homeserver sends, e.g. M_UNKNOWN_TOKEN
+type apiError struct {
+	Status  int    `json:"-"`
+	ErrCode string `json:"errcode"`
+	Message string `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("status %d %s: %s", e.Status, e.ErrCode, e.Message)
+}
+
+// do calls the API, body is sent as JSON if it isn't nil and the response is decoded into out
+func (c *client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	u := c.homeserver + "/_matrix/client/v3" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		e := &apiError{Status: resp.StatusCode}
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if json.Unmarshal(b, e) != nil || e.ErrCode == "" {
+			e.Message = string(b)
+		}
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *client) whoami(ctx context.Context) (string, error) {
+	var resp struct {
+		UserID string `json:"user_id"`
+	}
+	err := c.do(ctx, http.MethodGet, "/account/whoami", nil, nil, &resp)
+	return resp.UserID, err
+}
+
+// join joins a room by ID or alias (does nothing if already joined) and returns the room ID
+func (c *client) join(ctx context.Context, room string) (string, error) {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/join/"+url.PathEscape(room), nil, map[string]any{}, &resp)
+	return resp.RoomID, err
+}
+
+type matrixEvent struct {
+	Type           string          `json:"type"`
+	EventID        string          `json:"event_id"`
+	Sender         string          `json:"sender"`
+	OriginServerTS int64           `json:"origin_server_ts"`
+	StateKey       *string         `json:"state_key,omitempty"`
+	Redacts        string          `json:"redacts,omitempty"`
+	Content        json.RawMessage `json:"content"`
+}
+
+type messageContent struct {
+	MsgType string `json:"msgtype"`
+	Body    string `json:"body"`
+	// set when the message is a reply, the body then starts with a "> quote" of the message replied to
+	RelatesTo *struct {
+		InReplyTo *struct {
+			EventID string `json:"event_id"`
+		} `json:"m.in_reply_to"`
+	} `json:"m.relates_to"`
+	Redacts string `json:"redacts"` // newer room versions put it in the content
+}
+
+type memberContent struct {
+	Membership  string `json:"membership"`
+	DisplayName string `json:"displayname"`
+}
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			State struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"state"`
+			Timeline struct {
+				Events  []matrixEvent `json:"events"`
+				Limited bool          `json:"limited"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+// sync long polls for new events in roomID since the since token, "" for an initial sync
+func (c *client) sync(ctx context.Context, roomID, since string, timeoutMs int) (syncResponse, error) {
+	filter, _ := json.Marshal(map[string]any{
+		"presence":     map[string]any{"not_types": []string{"*"}},
+		"account_data": map[string]any{"not_types": []string{"*"}},
+		"room": map[string]any{
+			"rooms":        []string{roomID},
+			"timeline":     map[string]any{"types": []string{"m.room.message", "m.room.redaction", "m.room.member"}, "limit": MATRIX_TIMELINE_LIMIT},
+			"state":        map[string]any{"types": []string{"m.room.member"}, "lazy_load_members": true},
+			"ephemeral":    map[string]any{"not_types": []string{"*"}},
+			"account_data": map[string]any{"not_types": []string{"*"}},
+		},
+	})
+	query := url.Values{"filter": {string(filter)}, "timeout": {strconv.Itoa(timeoutMs)}}
+	if since != "" {
+		query.Set("since", since)
+	}
+	var resp syncResponse
+	err := c.do(ctx, http.MethodGet, "/sync", query, nil, &resp)
+	return resp, err
+}
+
+// send posts a message, txnID makes retries of the same message not post it twice
+func (c *client) send(ctx context.Context, roomID, txnID string, content any) error {
+	path := "/rooms/" + url.PathEscape(roomID) + "/send/m.room.message/" + url.PathEscape(txnID)
+	return c.do(ctx, http.MethodPut, path, nil, content, nil)
+}
@@ -0,0 +1,421 @@
+package matrixChat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	MATRIX_HTTP_TIMEOUT     = 10 * time.Second
+	MATRIX_SYNC_TIMEOUT_MS  = 30000           //how long the homeserver holds a sync open when there is nothing new
+	MATRIX_RETRY_DELAY      = 5 * time.Second //wait before syncing again after an error
+	MATRIX_MAX_SYNC_ERRORS  = 5               //failed syncs in a row before giving up and letting the supervisor retry
+	MATRIX_TIMELINE_LIMIT   = 50              //most events per sync, when resuming after a long time the older ones are skipped
+	MATRIX_MAX_BACKLOG      = 10 * time.Minute
+	MATRIX_MAX_MESSAGE_SIZE = 4000 //bytes, well under the homeserver's 64KiB event limit
+)
+
+var (
+	matrixConnected  bool
+	matrixCancel     context.CancelFunc
+	matrixWG         sync.WaitGroup
+	matrixClient     *client
+	matrixUserID     string // the bot's own account, its messages are skipped
+	matrixRoom       string // the matrix_room prop, an ID or alias
+	matrixRoomID     string
+	matrixError      string
+	matrixTxnCounter int
+	matrixLock       sync.Mutex // guards the vars above except matrixWG
+)
+
+// the access token is kept out of the channel props since those are public
+func accessTokenKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/matrix_access_token"
+}
+
+// the sync token changes with every sync, so it isn't a channel prop either. It's kept per room,
+// since the sync is filtered to the room and switching rooms shouldn't lose or reuse the other room's place.
+func syncTokenKey(roomID string) string {
+	return "channels/" + env.TWITCH_CHANNEL + "/matrix_sync_token/" + roomID
+}
+
+// syncToken is where to resume syncing, only valid for the same account on the same homeserver
+type syncToken struct {
+	Homeserver string `json:"homeserver"`
+	UserID     string `json:"user_id"`
+	NextBatch  string `json:"next_batch"`
+}
+
+// SetAccessToken stores the matrix access token, "" removes it. Restart the source afterwards.
+func SetAccessToken(ctx context.Context, token string) error {
+	token = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(token), "Bearer "))
+	if token == "" {
+		return redisClient.Del(ctx, accessTokenKey()).Err()
+	}
+	return redisClient.Set(ctx, accessTokenKey(), token, 0).Err()
+}
+
+func HasAccessToken(ctx context.Context) bool {
+	n, _ := redisClient.Exists(ctx, accessTokenKey()).Result()
+	return n > 0
+}
+
+// Settings is what the bot page shows about matrix, the token itself is never sent back
+func Settings(ctx context.Context) map[string]any {
+	return map[string]any{"access_token_set": HasAccessToken(ctx)}
+}
+
+func loadSyncToken(ctx context.Context, homeserver, userID, roomID string) string {
+	var tok syncToken
+	raw, err := redisClient.Get(ctx, syncTokenKey(roomID)).Result()
+	if err != nil || json.Unmarshal([]byte(raw), &tok) != nil {
+		return ""
+	}
+	if tok.Homeserver != homeserver || tok.UserID != userID {
+		return ""
+	}
+	return tok.NextBatch
+}
+
+func saveSyncToken(ctx context.Context, homeserver, userID, roomID, nextBatch string) {
+	b, _ := json.Marshal(syncToken{Homeserver: homeserver, UserID: userID, NextBatch: nextBatch})
+	if err := redisClient.Set(ctx, syncTokenKey(roomID), b, 0).Err(); err != nil {
+		log.Println("[matrix] error saving sync token:", err)
+	}
+}
+
+// homeserverURL adds https:// if there is no scheme, e.g. "matrix.org" or "http://localhost:6167"
+func homeserverURL(s string) string {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "/")
+	if s != "" && !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	return s
+}
+
+// Source is the matrix ChatSource, registered with the supervisor in main
+var Source chatSource.ChatSource = source{}
+
+type source struct{}
+
+func (source) Name() string { return "matrix" }
+
+func (source) Start() error {
+	homeserver, _ := props.GetChannelProp(nil, "matrix_homeserver").(string)
+	homeserver = homeserverURL(homeserver)
+	room, _ := props.GetChannelProp(nil, "matrix_room").(string)
+	room = strings.TrimSpace(room)
+	if homeserver == "" || room == "" {
+		return fmt.Errorf("no matrix_homeserver or matrix_room: %w", chatSource.ErrNotConfigured)
+	}
+	token, err := redisClient.Get(context.Background(), accessTokenKey()).Result()
+	if err != nil || token == "" {
+		return fmt.Errorf("no access token: %w", chatSource.ErrNotConfigured)
+	}
+	c := &client{
+		homeserver:  homeserver,
+		accessToken: token,
+		http:        &http.Client{Timeout: MATRIX_HTTP_TIMEOUT + MATRIX_SYNC_TIMEOUT_MS*time.Millisecond},
+	}
+	log.Println("[matrix] connecting to", homeserver)
+	matrixLock.Lock()
+	matrixRoom = room
+	matrixLock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fail := func(err error) error {
+		cancel()
+		matrixLock.Lock()
+		matrixError = err.Error()
+		matrixLock.Unlock()
+		return err
+	}
+	userID, err := c.whoami(ctx)
+	if err != nil {
+		return fail(fmt.Errorf("whoami: %w", err))
+	}
+	roomID, err := c.join(ctx, room)
+	if err != nil {
+		return fail(fmt.Errorf("join %s: %w", room, err))
+	}
+
+	r := &roomState{id: roomID, self: userID, names: make(map[string]string)}
+	resp, err := firstSync(ctx, c, r)
+	if err != nil {
+		return fail(fmt.Errorf("sync: %w", err))
+	}
+
+	matrixLock.Lock()
+	matrixCancel = cancel
+	matrixClient = c
+	matrixUserID = userID
+	matrixRoomID = roomID
+	matrixConnected = true
+	matrixError = ""
+	matrixLock.Unlock()
+	log.Printf("[matrix] logged in as %s, reading %s (%s)", userID, room, roomID)
+
+	matrixWG.Add(1)
+	go func() {
+		defer matrixWG.Done()
+		err := syncLoop(ctx, c, r, resp.NextBatch)
+		matrixLock.Lock()
+		matrixConnected = false
+		if err != nil {
+			matrixError = err.Error()
+		}
+		matrixLock.Unlock()
+		if err != nil {
+			log.Println("[matrix] disconnected:", err)
+			chatSource.SayAll("disconnected from matrix chat")
+		}
+	}()
+	//delay the message a bit to allow the disconnect message to come thru first
+	chatSource.SayAllLater("connected to matrix chat: " + room)
+	return nil
+}
+
+// firstSync resumes from where the last sync stopped, so messages sent while the bot was down still come thru,
+// or starts over if there is no stored sync token or the homeserver rejects it
+func firstSync(ctx context.Context, c *client, r *roomState) (syncResponse, error) {
+	since := loadSyncToken(ctx, c.homeserver, r.self, r.id)
+	resp, err := c.sync(ctx, r.id, since, 0)
+	if err != nil && since != "" {
+		log.Println("[matrix] can't resume from the stored sync token, starting over:", err)
+		since = ""
+		resp, err = c.sync(ctx, r.id, since, 0)
+	}
+	if err != nil {
+		return resp, err
+	}
+	// an initial sync has the last few messages from before, those are only read for the display names
+	r.handle(resp, since == "")
+	saveSyncToken(ctx, c.homeserver, r.self, r.id, resp.NextBatch)
+	return resp, nil
+}
+
+// syncLoop long polls until ctx is done (returns nil) or syncing keeps failing (returns the error)
+func syncLoop(ctx context.Context, c *client, r *roomState, since string) error {
+	failures := 0
+	for {
+		resp, err := c.sync(ctx, r.id, since, MATRIX_SYNC_TIMEOUT_MS)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			var merr *apiError
+			if errors.As(err, &merr) && merr.ErrCode == "M_UNKNOWN_TOKEN" {
+				return fmt.Errorf("the access token is no longer valid: %w", err)
+			}
+			if failures++; failures >= MATRIX_MAX_SYNC_ERRORS {
+				return err
+			}
+			log.Printf("[matrix] sync error, retrying: %v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(MATRIX_RETRY_DELAY):
+			}
+			continue
+		}
+		failures = 0
+		r.handle(resp, false)
+		since = resp.NextBatch
+		saveSyncToken(ctx, c.homeserver, r.self, r.id, since)
+	}
+}
+
+// roomState is what the sync loop knows about the room
+type roomState struct {
+	id    string
+	self  string
+	names map[string]string // user ID -> display name, from the member events
+}
+
+// handle reads a sync response, with skipMessages only the member events are used
+func (r *roomState) handle(resp syncResponse, skipMessages bool) {
+	joined, ok := resp.Rooms.Join[r.id]
+	if !ok {
+		return
+	}
+	for _, ev := range joined.State.Events {
+		r.handleMember(ev)
+	}
+	for _, ev := range joined.Timeline.Events {
+		switch ev.Type {
+		case "m.room.member":
+			r.handleMember(ev)
+		case "m.room.redaction":
+			if skipMessages {
+				continue
+			}
+			redacts := ev.Redacts
+			if redacts == "" {
+				var content messageContent
+				json.Unmarshal(ev.Content, &content)
+				redacts = content.Redacts
+			}
+			if redacts != "" {
+				multiChat.DeleteMessage("matrix", redacts)
+			}
+		case "m.room.message":
+			if skipMessages || ev.Sender == r.self {
+				continue
+			}
+			if time.Since(time.UnixMilli(ev.OriginServerTS)) > MATRIX_MAX_BACKLOG {
+				continue
+			}
+			r.handleMessage(ev)
+		}
+	}
+}
+
+func (r *roomState) handleMember(ev matrixEvent) {
+	if ev.StateKey == nil {
+		return
+	}
+	var content memberContent
+	if err := json.Unmarshal(ev.Content, &content); err != nil {
+		return
+	}
+	userID := *ev.StateKey
+	switch content.Membership {
+	case "join":
+		if content.DisplayName != "" {
+			r.names[userID] = content.DisplayName
+		}
+	case "ban":
+		multiChat.PurgeUser("matrix", userID, r.name(userID))
+	}
+}
+
+func (r *roomState) handleMessage(ev matrixEvent) {
+	var content messageContent
+	if err := json.Unmarshal(ev.Content, &content); err != nil {
+		log.Println("[matrix] message parse err:", err)
+		return
+	}
+	// m.notice is what bots post, including this one, so it's skipped to avoid relay loops
+	if content.MsgType != "m.text" && content.MsgType != "m.emote" {
+		return
+	}
+	text, replyTo := content.Body, ""
+	if content.RelatesTo != nil && content.RelatesTo.InReplyTo != nil {
+		text, replyTo = stripReplyFallback(text)
+		if replyTo == r.self {
+			// so the chatbot knows it was replied to
+			replyTo = env.TWITCH_BOT_USERNAME
+		} else if replyTo != "" {
+			replyTo = r.name(replyTo)
+		}
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	name := r.name(ev.Sender)
+	log.Printf("[matrix] %s: %s", name, text)
+	multiChat.SendChatMessage(multiChat.ChatMessage{
+		PlatformID: ev.EventID,
+		UserID:     ev.Sender,
+		Source:     "matrix",
+		Username:   name,
+		Emotes:     make(map[string][]string),
+		Text:       text,
+		ReplyTo:    replyTo,
+	})
+}
+
+// name is the display name of a user ID, or the part before the server, e.g. "alice" for @alice:matrix.org
+func (r *roomState) name(userID string) string {
+	if name := r.names[userID]; name != "" {
+		return name
+	}
+	localpart, _, _ := strings.Cut(strings.TrimPrefix(userID, "@"), ":")
+	return localpart
+}
+
+// stripReplyFallback removes the "> <@user:server> quoted message" lines clients put before a reply,
+// and returns the user ID from it
+func stripReplyFallback(body string) (text, replyTo string) {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], "> ") {
+		if i == 0 {
+			if start := strings.Index(lines[0], "<"); start >= 0 {
+				if end := strings.Index(lines[0][start:], ">"); end > 0 {
+					replyTo = lines[0][start+1 : start+end]
+				}
+			}
+		}
+		i++
+	}
+	return strings.Join(lines[i:], "\n"), replyTo
+}
+
+func (source) Stop() {
+	matrixLock.Lock()
+	cancel := matrixCancel
+	matrixCancel = nil
+	matrixLock.Unlock()
+	if cancel != nil {
+		log.Println("[matrix] disconnecting")
+		cancel()
+		matrixWG.Wait()
+	}
+}
+
+// Say posts into the room as a notice, the way bots are supposed to post in matrix
+func (source) Say(text string) error {
+	matrixLock.Lock()
+	connected, c, roomID := matrixConnected, matrixClient, matrixRoomID
+	matrixTxnCounter++
+	txnID := fmt.Sprintf("multibot-%d-%d", time.Now().UnixNano(), matrixTxnCounter)
+	matrixLock.Unlock()
+	if !connected {
+		return fmt.Errorf("not connected")
+	}
+	if len(text) > MATRIX_MAX_MESSAGE_SIZE {
+		cut := MATRIX_MAX_MESSAGE_SIZE
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), MATRIX_HTTP_TIMEOUT)
+	defer cancel()
+	return c.send(ctx, roomID, txnID, map[string]any{"msgtype": "m.notice", "body": text})
+}
+
+func (source) Status() chatSource.Status {
+	matrixLock.Lock()
+	defer matrixLock.Unlock()
+	details := map[string]any{
+		"room":    matrixRoom,
+		"room_id": matrixRoomID,
+		"user_id": matrixUserID,
+		"error":   matrixError,
+	}
+	if matrixClient != nil {
+		details["homeserver"] = matrixClient.homeserver
+	}
+	return chatSource.Status{
+		Connected: matrixConnected,
+		Details:   details,
+	}
+}
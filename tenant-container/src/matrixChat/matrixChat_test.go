@@ -0,0 +1,138 @@
+package matrixChat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+)
+
+const (
+	testRoom = "!room:example.org"
+	testBot  = "@bot:example.org"
+)
+
+func setupRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	env.STATE_DB_URL = "redis://" + mr.Addr()
+	env.TWITCH_CHANNEL = "test"
+	redisClient.Init()
+}
+
+// fakeHomeserver answers /sync, it rejects the "stale" token like a homeserver that forgot it
+// and records the since of every sync
+type fakeHomeserver struct {
+	*httptest.Server
+	lock   sync.Mutex
+	sinces []string
+}
+
+func newFakeHomeserver(t *testing.T) *fakeHomeserver {
+	f := &fakeHomeserver{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_matrix/client/v3/sync" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		since := r.URL.Query().Get("since")
+		f.lock.Lock()
+		f.sinces = append(f.sinces, since)
+		f.lock.Unlock()
+		if since == "stale" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errcode":"M_UNKNOWN_POS","error":"Unknown position"}`))
+			return
+		}
+		next := "fresh"
+		if since != "" {
+			next = since + "+1"
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"next_batch": next,
+			"rooms": map[string]any{"join": map[string]any{testRoom: map[string]any{
+				"state": map[string]any{"events": []any{
+					map[string]any{"type": "m.room.member", "state_key": "@alice:example.org", "sender": "@alice:example.org",
+						"content": map[string]any{"membership": "join", "displayname": "Alice"}},
+				}},
+			}}},
+		})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeHomeserver) syncs() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.sinces...)
+}
+
+func TestFirstSync(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		stored    string // the next_batch stored for the room, "" for none
+		otherRoom bool   // stored for another room
+		syncs     []string
+		saved     string
+	}{
+		{"resume", "batch1", false, []string{"batch1"}, "batch1+1"},
+		{"rejected token", "stale", false, []string{"stale", ""}, "fresh"},
+		{"no token", "", false, []string{""}, "fresh"},
+		{"token for another room", "batch1", true, []string{""}, "fresh"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakeHomeserver(t)
+			c := &client{homeserver: f.URL, accessToken: "token", http: f.Client()}
+			redisClient.Del(ctx, syncTokenKey(testRoom), syncTokenKey("!other:example.org"))
+			if test.stored != "" {
+				room := testRoom
+				if test.otherRoom {
+					room = "!other:example.org"
+				}
+				saveSyncToken(ctx, f.URL, testBot, room, test.stored)
+			}
+
+			r := &roomState{id: testRoom, self: testBot, names: make(map[string]string)}
+			resp, err := firstSync(ctx, c, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.syncs(); !slices.Equal(got, test.syncs) {
+				t.Errorf("synced since %q, want %q", got, test.syncs)
+			}
+			if resp.NextBatch != test.saved {
+				t.Errorf("next_batch %q, want %q", resp.NextBatch, test.saved)
+			}
+			if saved := loadSyncToken(ctx, f.URL, testBot, testRoom); saved != test.saved {
+				t.Errorf("saved sync token %q, want %q", saved, test.saved)
+			}
+			if r.names["@alice:example.org"] != "Alice" {
+				t.Errorf("display names %v, want Alice from the member event", r.names)
+			}
+		})
+	}
+}
+
+func TestSyncTokenForOtherAccount(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+	saveSyncToken(ctx, "https://matrix.example.org", "@someone:example.org", testRoom, "batch1")
+	if tok := loadSyncToken(ctx, "https://matrix.example.org", testBot, testRoom); tok != "" {
+		t.Errorf("got %q, a token saved by another account shouldn't be used", tok)
+	}
+	if tok := loadSyncToken(ctx, "https://other.example.org", "@someone:example.org", testRoom); tok != "" {
+		t.Errorf("got %q, a token from another homeserver shouldn't be used", tok)
+	}
+}
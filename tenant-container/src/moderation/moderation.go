@@ -0,0 +1,152 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/identity"
+	"multibot/tenant-container/src/multiChat"
+)
+
+const (
+	ACTION_DELETE  = "delete"
+	ACTION_TIMEOUT = "timeout"
+	ACTION_BAN     = "ban"
+
+	DEFAULT_TIMEOUT = 10 * time.Minute
+	MAX_TIMEOUT     = 14 * 24 * time.Hour
+	MAX_REASON_LEN  = 500
+)
+
+// Request is a moderation action on a message from the multichat
+type Request struct {
+	Action       string `json:"action"` // one of the ACTION_*s
+	Source       string `json:"source"`
+	PlatformID   string `json:"platform_id"` // the message to delete
+	UserID       string `json:"user_id"`
+	Username     string `json:"username"`
+	Viewer       string `json:"viewer"` // the viewer record the chatter is linked to, if the message had one
+	Stream       string `json:"stream"`
+	DurationSecs int    `json:"duration_secs"` // for timeouts, DEFAULT_TIMEOUT if 0
+	Reason       string `json:"reason"`
+	AllLinked    bool   `json:"all_linked"` // also timeout/ban every account linked to the same viewer
+}
+
+// Result is how the action went on one platform account
+type Result struct {
+	Source   string `json:"source"`
+	Username string `json:"username"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Run does the action on the platform the message came from, and with AllLinked on the chatter's linked accounts.
+// The messages are always removed from the multichat, even if the platform refused, so a mod can at least clean up the overlay.
+func Run(ctx context.Context, req Request, by string) ([]Result, error) {
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > MAX_REASON_LEN {
+		return nil, fmt.Errorf("reason is too long, max length = %d", MAX_REASON_LEN)
+	}
+	if req.Source == "" {
+		return nil, fmt.Errorf("source is required")
+	}
+	target := multiChat.ChatMessage{
+		Source:     req.Source,
+		PlatformID: req.PlatformID,
+		UserID:     req.UserID,
+		Username:   req.Username,
+		Stream:     req.Stream,
+	}
+
+	switch req.Action {
+	case ACTION_DELETE:
+		if req.PlatformID == "" {
+			return nil, fmt.Errorf("platform_id is required to delete a message")
+		}
+		log.Printf("[moderation] %s deleted %s message %s from %s", by, req.Source, req.PlatformID, req.Username)
+		return []Result{deleteMessage(target)}, nil
+	case ACTION_TIMEOUT, ACTION_BAN:
+	default:
+		return nil, fmt.Errorf("unknown action %q", req.Action)
+	}
+	if req.UserID == "" && req.Username == "" {
+		return nil, fmt.Errorf("user_id or username is required")
+	}
+	duration := time.Duration(0)
+	if req.Action == ACTION_TIMEOUT {
+		duration = DEFAULT_TIMEOUT
+		if req.DurationSecs > 0 {
+			duration = min(time.Duration(req.DurationSecs)*time.Second, MAX_TIMEOUT)
+		}
+	}
+
+	targets := []multiChat.ChatMessage{target}
+	if req.AllLinked {
+		targets = append(targets, linkedAccounts(ctx, target, req.Viewer)...)
+	}
+	results := make([]Result, 0, len(targets))
+	for _, t := range targets {
+		log.Printf("[moderation] %s %s %s %s (%s) for %v: %s", by, req.Action, t.Source, t.Username, t.UserID, duration, req.Reason)
+		results = append(results, ban(t, duration, req.Reason))
+	}
+	return results, nil
+}
+
+func deleteMessage(msg multiChat.ChatMessage) Result {
+	multiChat.DeleteMessage(msg.Source, msg.PlatformID)
+	res := Result{Source: msg.Source, Username: msg.Username}
+	mod, err := chatSource.Moderate(msg.Source)
+	if err == nil {
+		err = mod.DeleteMessage(msg)
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func ban(msg multiChat.ChatMessage, duration time.Duration, reason string) Result {
+	multiChat.PurgeUser(msg.Source, msg.UserID, msg.Username)
+	res := Result{Source: msg.Source, Username: msg.Username}
+	mod, err := chatSource.Moderate(msg.Source)
+	if err == nil {
+		err = mod.Ban(msg, duration, reason)
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// linkedAccounts finds the chatter's other accounts: the viewer record is the twitch user who made the
+// link code, and every platform account that typed "!link CODE" points at it
+func linkedAccounts(ctx context.Context, msg multiChat.ChatMessage, viewer string) []multiChat.ChatMessage {
+	key := identity.Key(msg.Source, msg.UserID, msg.Username)
+	if viewer == "" {
+		viewer = identity.LinkedTo(ctx, key)
+	}
+	if viewer == "" && msg.Source == "twitch" {
+		viewer = msg.Username
+	}
+	if viewer == "" {
+		return nil
+	}
+	accounts := []multiChat.ChatMessage{}
+	if !(msg.Source == "twitch" && strings.EqualFold(msg.Username, viewer)) {
+		accounts = append(accounts, multiChat.ChatMessage{Source: "twitch", Username: viewer})
+	}
+	for _, link := range identity.Links(ctx, viewer) {
+		if link.Key == key {
+			continue
+		}
+		source, userID, username := identity.SplitKey(link.Key)
+		if link.Username != "" {
+			username = link.Username
+		}
+		accounts = append(accounts, multiChat.ChatMessage{Source: source, UserID: userID, Username: username})
+	}
+	return accounts
+}
@@ -0,0 +1,175 @@
+package multiChat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+)
+
+const (
+	CHAT_HISTORY_MAX_PAGE      = 500           //the most messages /chat_history will return at once
+	CHAT_HISTORY_TRIM_INTERVAL = 1 * time.Hour //how often to drop messages older than CHAT_HISTORY_MAX_DAYS
+	CHAT_HISTORY_DELETE_SCAN   = 1000          //how many recent messages to search when a message or user is deleted
+)
+
+var (
+	streamIDRegex = regexp.MustCompile(`^\d+(-\d+)?$`)
+)
+
+// chat history is a redis stream, the stream entry ID doubles as the pagination cursor (history_id)
+func chatHistoryKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/chat_history"
+}
+
+// stream entries can't be edited, so nickname and pronoun changes are stored next to the stream and applied on read
+func chatHistoryNicknamesKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/chat_history_nicknames"
+}
+
+func chatHistoryPronounsKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/chat_history_pronouns"
+}
+
+// appendChatHistory stores the message and returns its stream ID, or "" if redis failed
+func appendChatHistory(msg ChatMessage) string {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("[history] marshal error:", err)
+		return ""
+	}
+	id, err := redisClient.XAdd(nil, &redis.XAddArgs{
+		Stream: chatHistoryKey(),
+		MaxLen: int64(env.CHAT_HISTORY_RETENTION),
+		Approx: true,
+		Values: map[string]any{"msg": string(raw)},
+	}).Result()
+	if err != nil {
+		log.Println("[history] append error:", err)
+		return ""
+	}
+	return id
+}
+
+// GetChatHistory returns up to limit messages in chronological order.
+// With no cursors it returns the newest messages, "before" pages backwards and "after" pages forwards (both exclusive).
+func GetChatHistory(ctx context.Context, before, after string, limit int) ([]ChatMessage, error) {
+	if before != "" && !streamIDRegex.MatchString(before) {
+		return nil, fmt.Errorf("invalid before cursor: %q", before)
+	}
+	if after != "" && !streamIDRegex.MatchString(after) {
+		return nil, fmt.Errorf("invalid after cursor: %q", after)
+	}
+	if limit <= 0 {
+		limit = env.CHAT_HISTORY_LENGTH
+	}
+	if limit > CHAT_HISTORY_MAX_PAGE {
+		limit = CHAT_HISTORY_MAX_PAGE
+	}
+
+	var entries []redis.XMessage
+	var err error
+	if after != "" {
+		stop := "+"
+		if before != "" {
+			stop = "(" + before
+		}
+		entries, err = redisClient.XRangeN(ctx, chatHistoryKey(), "("+after, stop, int64(limit)).Result()
+	} else {
+		start := "+"
+		if before != "" {
+			start = "(" + before
+		}
+		entries, err = redisClient.XRevRangeN(ctx, chatHistoryKey(), start, "-", int64(limit)).Result()
+		// newest first => oldest first
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nicknames, _ := redisClient.HGetAll(ctx, chatHistoryNicknamesKey()).Result()
+	pronouns, _ := redisClient.HGetAll(ctx, chatHistoryPronounsKey()).Result()
+
+	messages := make([]ChatMessage, 0, len(entries))
+	for _, entry := range entries {
+		raw, _ := entry.Values["msg"].(string)
+		var msg ChatMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			log.Printf("[history] skipping bad entry %s: %v", entry.ID, err)
+			continue
+		}
+		msg.HistoryID = entry.ID
+		if nickname, ok := nicknames[msg.ViewerKey()]; ok {
+			msg.Nickname = nickname
+		}
+		if p, ok := pronouns[msg.ViewerKey()]; ok {
+			msg.Pronouns = p
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// deleteFromChatHistory removes recent messages that match and returns their IDs
+func deleteFromChatHistory(match func(ChatMessage) bool) []string {
+	entries, err := redisClient.XRevRangeN(nil, chatHistoryKey(), "+", "-", CHAT_HISTORY_DELETE_SCAN).Result()
+	if err != nil {
+		log.Println("[history] delete scan error:", err)
+		return []string{}
+	}
+	ids := []string{}
+	historyIDs := []string{}
+	for _, entry := range entries {
+		raw, _ := entry.Values["msg"].(string)
+		var msg ChatMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			continue
+		}
+		if match(msg) {
+			ids = append(ids, msg.ID)
+			historyIDs = append(historyIDs, entry.ID)
+		}
+	}
+	if len(historyIDs) > 0 {
+		if err := redisClient.XDel(nil, chatHistoryKey(), historyIDs...).Err(); err != nil {
+			log.Println("[history] delete error:", err)
+		}
+	}
+	return ids
+}
+
+func clearChatHistory() {
+	redisClient.Del(nil, chatHistoryKey(), chatHistoryNicknamesKey(), chatHistoryPronounsKey())
+}
+
+func UpdateChatHistoryNickname(username string, nickname string) {
+	redisClient.HSet(nil, chatHistoryNicknamesKey(), username, nickname)
+}
+
+func UpdateChatHistoryPronouns(username string, pronouns string) {
+	redisClient.HSet(nil, chatHistoryPronounsKey(), username, pronouns)
+}
+
+// ChatHistoryTrimmer drops messages older than CHAT_HISTORY_MAX_DAYS, the count limit is applied on every append
+func ChatHistoryTrimmer() {
+	for {
+		cutoff := time.Now().Add(-time.Duration(env.CHAT_HISTORY_MAX_DAYS) * 24 * time.Hour)
+		minID := fmt.Sprintf("%d-0", cutoff.UnixMilli())
+		if n, err := redisClient.XTrimMinIDApprox(nil, chatHistoryKey(), minID, 0).Result(); err != nil {
+			log.Println("[history] trim error:", err)
+		} else if n > 0 {
+			log.Printf("[history] trimmed %d messages older than %d days", n, env.CHAT_HISTORY_MAX_DAYS)
+		}
+		time.Sleep(CHAT_HISTORY_TRIM_INTERVAL)
+	}
+}
@@ -0,0 +1,105 @@
+package multiChat
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// kinds of events, stored in Event.Kind and listed in the show_events channel prop
+	EVENT_SUB              = "sub"
+	EVENT_RESUB            = "resub"
+	EVENT_SUB_GIFT         = "subgift"
+	EVENT_MYSTERY_GIFT     = "submysterygift"  //someone gifted several subs at once
+	EVENT_GIFT_UPGRADE     = "giftpaidupgrade" //a gifted or prime sub was continued as a paid one
+	EVENT_RAID             = "raid"
+	EVENT_ANNOUNCEMENT     = "announcement"
+	EVENT_BITS_BADGE       = "bitsbadgetier"
+	EVENT_VIEWER_STREAK    = "viewermilestone"
+	EVENT_FOLLOW           = "follow"
+	EVENT_CHEER            = "cheer"
+	EVENT_REDEMPTION       = "redemption" //channel points
+	EVENT_STREAM_ONLINE    = "stream_online"
+	EVENT_STREAM_OFFLINE   = "stream_offline"
+	EVENT_SUPER_CHAT       = "superchat"
+	EVENT_SUPER_STICKER    = "supersticker"
+	EVENT_MEMBERSHIP       = "membership"      //new youtube member
+	EVENT_MEMBER_MILESTONE = "membermilestone" //youtube member for N months
+	EVENT_MEMBER_GIFT      = "membergift"      //someone gifted youtube memberships
+	EVENT_HOST             = "host"            //another kick channel hosted this one
+	EVENT_USER_JOINED      = "join"            //someone joined the owncast chat
+	EVENT_NAME_CHANGE      = "namechange"      //an owncast user changed their name
+	EVENT_ACTION           = "action"          //owncast system action, e.g. "x is now a moderator"
+	EVENT_FEDI_LIKE        = "like"            //a fediverse account liked the owncast stream
+	EVENT_FEDI_BOOST       = "boost"           //a fediverse account boosted the owncast stream
+	EVENT_TIKTOK_GIFT      = "tiktokgift"
+	EVENT_TIKTOK_LIKE      = "tiktoklike" //tiktok sends likes in batches of taps, often several a second
+	EVENT_DONATION         = "donation"   //posted by an outside tool thru a webhook, e.g. ko-fi
+)
+
+// EVENT_KINDS are the events the overlay knows about, in the order they are listed on the bot page
+var EVENT_KINDS = []string{EVENT_SUB, EVENT_RESUB, EVENT_SUB_GIFT, EVENT_MYSTERY_GIFT, EVENT_GIFT_UPGRADE, EVENT_RAID, EVENT_ANNOUNCEMENT, EVENT_BITS_BADGE, EVENT_VIEWER_STREAK,
+	EVENT_FOLLOW, EVENT_CHEER, EVENT_REDEMPTION, EVENT_STREAM_ONLINE, EVENT_STREAM_OFFLINE,
+	EVENT_SUPER_CHAT, EVENT_SUPER_STICKER, EVENT_MEMBERSHIP, EVENT_MEMBER_MILESTONE, EVENT_MEMBER_GIFT,
+	EVENT_HOST, EVENT_USER_JOINED, EVENT_NAME_CHANGE, EVENT_ACTION, EVENT_FEDI_LIKE, EVENT_FEDI_BOOST,
+	EVENT_TIKTOK_GIFT, EVENT_TIKTOK_LIKE, EVENT_DONATION}
+
+// DefaultShownEvents is EVENT_KINDS without the noisy ones, the default for the show_events channel prop
+func DefaultShownEvents() []string {
+	kinds := []string{}
+	for _, kind := range EVENT_KINDS {
+		if kind != EVENT_USER_JOINED && kind != EVENT_NAME_CHANGE && kind != EVENT_TIKTOK_LIKE {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+var eventListeners []chan ChatMessage
+
+// Event is a sub, raid etc. that shows in the chat next to the regular messages. The message's Text is
+// whatever the user wrote along with it, e.g. a resub message, and can be empty.
+type Event struct {
+	Kind          string `json:"kind"`
+	User          string `json:"user"`                // who subbed, gifted, raided etc.
+	Recipient     string `json:"recipient,omitempty"` // who got a gift sub
+	Tier          string `json:"tier,omitempty"`      // "1", "2", "3" or "prime"
+	Months        int    `json:"months,omitempty"`    // total months subscribed
+	Count         int    `json:"count,omitempty"`     // subs gifted, raiders, bits etc.
+	Reward        string `json:"reward,omitempty"`    // the channel points reward that was redeemed
+	Amount        string `json:"amount,omitempty"`    // what was paid as the platform shows it, e.g. "$5.00"
+	Currency      string `json:"currency,omitempty"`  // the currency part of Amount, e.g. "$" or "CA$"
+	Color         string `json:"color,omitempty"`     // "#rrggbb" for the tier of a paid message
+	Image         string `json:"image,omitempty"`     // e.g. the super sticker
+	SystemMessage string `json:"system_message"`      // the platform's own description, e.g. "x subscribed for 3 months"
+}
+
+// SendEvent stores and broadcasts a message that has an Event, once it passes the chat filter and the overlay mode
+// lets it out. It goes to the event listeners instead of the chat listeners, so commands etc. don't run.
+func SendEvent(msg ChatMessage) {
+	if msg.Event == nil {
+		log.Printf("[websocket] [%s] SendEvent called without an event", msg.Source)
+		return
+	}
+	msg.ID = uuid.New().String()
+	msg.ReceivedAt = time.Now()
+	if identityResolver != nil && msg.Username != "" && !msg.Webhook {
+		identityResolver(&msg)
+	}
+	if msg.Emotes == nil {
+		msg.Emotes = make(map[string][]string)
+	}
+	// what the user wrote with it, and names in the system message, can need filtering as much as chat does
+	passed, after := filter(&msg)
+	if passed && !queue(msg) {
+		publish(msg)
+	}
+	after()
+}
+
+// AddEventListener registers fn to be called with every event from every source after it is broadcast
+func AddEventListener(fn func(msg ChatMessage)) {
+	eventListeners = append(eventListeners, startListener(fn))
+}
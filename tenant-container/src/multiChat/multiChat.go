@@ -9,10 +9,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
-	"multibot/common/src/env"
-
 	"multibot/tenant-container/src/emotes"
 	"multibot/tenant-container/src/frontend"
 )
@@ -20,6 +19,21 @@ import (
 const (
 	PRONOUN_CACHE_TIME = 24 * time.Hour
 	PRONOUN_RETRY_TIME = 30 * time.Second
+
+	// roles a chatter can have on their platform, used for command permissions
+	ROLE_EVERYONE    = "everyone"
+	ROLE_SUB         = "sub" //subscriber, or member on youtube
+	ROLE_VIP         = "vip"
+	ROLE_MOD         = "mod"
+	ROLE_BROADCASTER = "broadcaster"
+
+	// what the chat filter decided to do with a message
+	FILTER_ALLOW = ""
+	FILTER_MASK  = "mask" //the filter already changed the message, send it
+	FILTER_HIDE  = "hide" //drop it, it isn't shown, stored or seen by the chat listeners
+	FILTER_HOLD  = "hold" //keep it out of the chat until a mod approves it, see HeldMessages
+
+	LISTENER_QUEUE_SIZE = 500 //messages a slow listener can fall behind by before it misses some
 )
 
 var (
@@ -28,8 +42,15 @@ var (
 		WriteBufferSize: 1024,
 		CheckOrigin:     func(r *http.Request) bool { return true },
 	}
-	wsClients   sync.Map // map[*WSConn]bool
-	chatHistory = make([]ChatMessage, 0, env.CHAT_HISTORY_LENGTH)
+	wsClients    sync.Map // map[*WSConn]bool
+	modWsClients sync.Map // map[*WSConn]bool, the bot pages of mods, which get the held messages
+
+	// ROLES goes from least to most privileged
+	ROLES = []string{ROLE_EVERYONE, ROLE_SUB, ROLE_VIP, ROLE_MOD, ROLE_BROADCASTER}
+
+	chatListeners    []chan ChatMessage
+	identityResolver func(msg *ChatMessage)
+	chatFilter       func(msg *ChatMessage) (string, func())
 
 	pronounCache = make(map[string]*pronounEntry)
 	pronounLock  sync.Mutex
@@ -63,13 +84,71 @@ type pronounEntry struct {
 }
 
 type ChatMessage struct {
-	Source   string              `json:"source"`
-	Username string              `json:"username"`
-	Nickname string              `json:"nickname"`
-	Pronouns string              `json:"pronouns"`
-	Color    string              `json:"color"`
-	Emotes   map[string][]string `json:"emotes"`
-	Text     string              `json:"text"`
+	ID         string              `json:"id"`                    // our own ID, unique across platforms
+	PlatformID string              `json:"platform_id,omitempty"` // the platform's native message ID, used to handle deletions
+	UserID     string              `json:"user_id,omitempty"`     // the platform's native user ID, used to handle bans/timeouts
+	HistoryID  string              `json:"history_id,omitempty"`  // redis stream ID, set once the message is stored
+	ReceivedAt time.Time           `json:"received_at"`
+	Source     string              `json:"source"`
+	Username   string              `json:"username"`
+	Nickname   string              `json:"nickname"`
+	Pronouns   string              `json:"pronouns"`
+	Color      string              `json:"color"`
+	Emotes     map[string][]string `json:"emotes"`
+	Text       string              `json:"text"`
+	Role       string              `json:"role,omitempty"`       // the highest of ROLES the user has on the source platform, "" = everyone
+	Viewer     string              `json:"viewer,omitempty"`     // the viewer record (props key) the chatter is linked to, "" = same as Username
+	ReplyTo    string              `json:"reply_to,omitempty"`   // the username this message replies to, on platforms that have replies
+	Stream     string              `json:"stream,omitempty"`     // which stream it came from when a source reads several, e.g. the youtube video ID
+	HeldFor    string              `json:"held_for,omitempty"`   // why it is held for review, only set while it is held
+	ReleaseAt  *time.Time          `json:"release_at,omitempty"` // when a held message goes out on its own in the delay overlay mode
+	Event      *Event              `json:"event,omitempty"`      // set for subs, raids etc., see SendEvent
+	Webhook    bool                `json:"webhook,omitempty"`    // posted by an outside tool, the chat listeners don't run for it
+	Unverified bool                `json:"unverified,omitempty"` // the name isn't tied to an account anyone has to log in to, e.g. an unregistered irc nick, so it can't be linked
+}
+
+// ViewerKey is the name the chatter's nickname, pronouns etc. are stored under. That is the twitch login for twitch
+// chatters and for accounts linked to one, other chatters get their own ChatterKey so they can't share a twitch viewer's record by name.
+func (m ChatMessage) ViewerKey() string {
+	if m.Viewer != "" {
+		return m.Viewer
+	}
+	if m.Source == "twitch" || m.Source == "" {
+		return m.Username
+	}
+	return ChatterKey(m.Source, m.UserID, m.Username)
+}
+
+// ChatterKey identifies a chatter on a platform, by their platform user ID when there is one since names can change
+func ChatterKey(source, userID, username string) string {
+	if userID != "" {
+		return source + ":" + userID
+	}
+	return source + ":name:" + strings.ToLower(username)
+}
+
+// RoleLevel is the index of the role in ROLES, or -1 if it isn't a role
+func RoleLevel(role string) int {
+	if role == "" {
+		return 0
+	}
+	for i, r := range ROLES {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// HighestRole picks the most privileged of the given roles, for users with several badges
+func HighestRole(roles ...string) string {
+	highest := ""
+	for _, role := range roles {
+		if RoleLevel(role) > RoleLevel(highest) {
+			highest = role
+		}
+	}
+	return highest
 }
 
 type WSConn struct {
@@ -78,16 +157,10 @@ type WSConn struct {
 }
 
 func WsHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := wsUpgrader.Upgrade(w, r, nil)
-	if err != nil {
-		http.Error(w, "websocket upgrade failed", http.StatusInternalServerError)
+	wsConn := serveWs(w, r, &wsClients)
+	if wsConn == nil {
 		return
 	}
-	log.Println("[websocket] client connected")
-
-	wsConn := &WSConn{Conn: conn}
-	wsClients.Store(wsConn, true)
-
 	// Immediately send the page_hash
 	sendJSONWrapped(wsConn, map[string]any{
 		"type": "page_hash",
@@ -95,10 +168,33 @@ func WsHandler(w http.ResponseWriter, r *http.Request) {
 			"page_hash": frontend.IndexPageHash,
 		},
 	})
+}
+
+// ModWsHandler is the websocket for mods' bot pages, it must only be reachable thru the mod auth middleware.
+// It starts with every held message and then gets "held" and "unheld" as messages come and go.
+func ModWsHandler(w http.ResponseWriter, r *http.Request) {
+	wsConn := serveWs(w, r, &modWsClients)
+	if wsConn == nil {
+		return
+	}
+	sendJSONWrapped(wsConn, map[string]any{"type": "held_messages", "content": HeldMessages()})
+}
+
+// serveWs upgrades the connection and keeps it in clients until it closes
+func serveWs(w http.ResponseWriter, r *http.Request, clients *sync.Map) *WSConn {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, "websocket upgrade failed", http.StatusInternalServerError)
+		return nil
+	}
+	log.Println("[websocket] client connected")
+
+	wsConn := &WSConn{Conn: conn}
+	clients.Store(wsConn, true)
 
 	go func() {
 		defer func() {
-			wsClients.Delete(wsConn)
+			clients.Delete(wsConn)
 			conn.Close()
 			log.Println("[websocket] client disconnected")
 		}()
@@ -112,6 +208,7 @@ func WsHandler(w http.ResponseWriter, r *http.Request) {
 			// could parse incoming messages from the client here if needed.
 		}
 	}()
+	return wsConn
 }
 
 func WsNumClientsHandler(w http.ResponseWriter, r *http.Request) {
@@ -130,7 +227,16 @@ func sendJSONWrapped(wsConn *WSConn, data any) {
 }
 
 func Broadcast(msgType string, content any) {
-	wsClients.Range(func(key, _ any) bool {
+	broadcastTo(&wsClients, msgType, content)
+}
+
+// BroadcastMods sends to the mods' bot pages only, never to the overlays
+func BroadcastMods(msgType string, content any) {
+	broadcastTo(&modWsClients, msgType, content)
+}
+
+func broadcastTo(clients *sync.Map, msgType string, content any) {
+	clients.Range(func(key, _ any) bool {
 		wsConn, ok := key.(*WSConn)
 		if !ok {
 			return true
@@ -141,40 +247,166 @@ func Broadcast(msgType string, content any) {
 }
 
 func ClearChat() {
-	chatHistory = chatHistory[:0]
+	clearChatHistory()
+	dropHeld(func(ChatMessage) bool { return true })
 	log.Println("CLEAR CHAT")
 	Broadcast("command", map[string]any{"command": "clear"})
 }
 
 // attach 3rd-party emotes, attach pronouns, broadcast out
 func SendChat(source, username, nickname, color, text string, emotesMap map[string][]string) {
+	SendChatMessage(ChatMessage{
+		Source:   source,
+		Username: username,
+		Nickname: nickname,
+		Color:    color,
+		Emotes:   emotesMap,
+		Text:     text,
+	})
+}
+
+// SendChatMessage is SendChat for connectors that have more to say about a message, e.g. its PlatformID and UserID
+func SendChatMessage(msg ChatMessage) {
+	msg.ID = uuid.New().String()
+	msg.ReceivedAt = time.Now()
+	// find the linked viewer record and its nickname, a webhook only says who it's posting for so it can't be linked
+	if identityResolver != nil && !msg.Webhook {
+		identityResolver(&msg)
+	}
 	// find or attach pronouns
-	pronouns := getUserPronouns(username)
-	if emotesMap == nil {
-		emotesMap = make(map[string][]string)
+	// pronouns.alejo.io only knows twitch logins
+	if viewer := msg.ViewerKey(); !strings.Contains(viewer, ":") {
+		msg.Pronouns = getUserPronouns(viewer)
+	}
+	if msg.Emotes == nil {
+		msg.Emotes = make(map[string][]string)
 	}
 	// also merge any found 3rd-party emotes in the text
 	go emotes.UpdateEmoteCacheIfNeeded()
-	thirdParty := emotes.Find3rdPartyEmotes(text)
+	thirdParty := emotes.Find3rdPartyEmotes(msg.Text)
 	for url, positions := range thirdParty {
-		emotesMap[url] = positions
+		msg.Emotes[url] = positions
 	}
 
-	msg := ChatMessage{
-		Source:   source,
-		Username: username,
-		Nickname: nickname,
-		Pronouns: pronouns,
-		Color:    color,
-		Emotes:   emotesMap,
-		Text:     text,
+	passed, after := filter(&msg)
+	if passed && !queue(msg) {
+		publish(msg)
+	}
+	after()
+}
+
+// filter runs the chat filter and holds the message if it says to. It returns false if the message was hidden
+// or held, and what the filter wants to do once the message was sent or held, which is never nil.
+func filter(msg *ChatMessage) (bool, func()) {
+	if chatFilter == nil {
+		return true, func() {}
 	}
-	chatHistory = append(chatHistory, msg)
-	if len(chatHistory) > env.CHAT_HISTORY_LENGTH {
-		chatHistory = chatHistory[1:]
+	action, after := chatFilter(msg)
+	if after == nil {
+		after = func() {}
+	}
+	switch action {
+	case FILTER_HIDE:
+		return false, after
+	case FILTER_HOLD:
+		hold(*msg, false)
+		return false, after
+	}
+	return true, after
+}
+
+// publish stores and broadcasts a message that made it past the filter, and runs the chat listeners unless a webhook
+// posted it. Events go to the event listeners instead.
+func publish(msg ChatMessage) {
+	msg.HistoryID = appendChatHistory(msg)
+	if msg.Event != nil {
+		log.Printf("[websocket] [%s] SEND EVENT %s %s: %s", msg.Source, msg.Event.Kind, msg.Event.User, msg.Event.SystemMessage)
+		Broadcast("event", msg)
+		notify(eventListeners, msg)
+		return
 	}
-	log.Printf("[websocket] [%s] SEND CHAT %s (nickname: %s pronouns: %s color: %s emotes: %v): %s", source, username, nickname, pronouns, color, emotesMap, text)
+	log.Printf("[websocket] [%s] SEND CHAT %s (nickname: %s pronouns: %s color: %s emotes: %v): %s", msg.Source, msg.Username, msg.Nickname, msg.Pronouns, msg.Color, msg.Emotes, msg.Text)
 	Broadcast("chat", msg)
+
+	// commands, !link etc. would run as whoever the tool named
+	if msg.Webhook {
+		return
+	}
+	notify(chatListeners, msg)
+}
+
+// SetIdentityResolver sets fn to fill in Viewer and Nickname on every message before it is sent
+func SetIdentityResolver(fn func(msg *ChatMessage)) {
+	identityResolver = fn
+}
+
+// SetChatFilter sets fn to check every chat message and event once it has its emotes, it can change the message
+// and returns one of the FILTER_*s. It can also return a func to run once the message was sent or held,
+// e.g. to finish a slow check and then hide or release it.
+func SetChatFilter(fn func(msg *ChatMessage) (string, func())) {
+	chatFilter = fn
+}
+
+// AddChatListener registers fn to be called with every chat message from every source after it is broadcast.
+// Each listener runs on its own goroutine, so one that makes slow calls doesn't hold up reading chat.
+func AddChatListener(fn func(msg ChatMessage)) {
+	chatListeners = append(chatListeners, startListener(fn))
+}
+
+// startListener runs fn for each message sent on the returned channel, in order
+func startListener(fn func(msg ChatMessage)) chan ChatMessage {
+	ch := make(chan ChatMessage, LISTENER_QUEUE_SIZE)
+	go func() {
+		for msg := range ch {
+			fn(msg)
+		}
+	}()
+	return ch
+}
+
+// notify hands msg to each listener without waiting, a listener that is too far behind misses it
+func notify(listeners []chan ChatMessage, msg ChatMessage) {
+	for _, ch := range listeners {
+		select {
+		case ch <- msg:
+		default:
+			log.Printf("[websocket] [%s] a listener is too far behind, skipping %s: %s", msg.Source, msg.Username, msg.Text)
+		}
+	}
+}
+
+// DeleteMessage removes a message that was deleted on its platform from the history and the connected overlays
+func DeleteMessage(source, platformID string) {
+	if platformID == "" {
+		return
+	}
+	match := func(msg ChatMessage) bool { return msg.Source == source && msg.PlatformID == platformID }
+	dropHeld(match)
+	ids := deleteFromChatHistory(match)
+	log.Printf("[websocket] [%s] DELETE MESSAGE %s (%d in history)", source, platformID, len(ids))
+	Broadcast("delete_message", map[string]any{
+		"source":      source,
+		"platform_id": platformID,
+		"ids":         ids,
+	})
+}
+
+// PurgeUser removes every message from a user who was banned or timed out on a platform.
+// userID is preferred, username (case-insensitive) is used when the platform doesn't give an ID.
+func PurgeUser(source, userID, username string) {
+	if userID == "" && username == "" {
+		return
+	}
+	match := func(msg ChatMessage) bool { return sameUser(msg, source, userID, username) }
+	dropHeld(match)
+	ids := deleteFromChatHistory(match)
+	log.Printf("[websocket] [%s] PURGE USER %s %s (%d in history)", source, userID, username, len(ids))
+	Broadcast("purge_user", map[string]any{
+		"source":   source,
+		"user_id":  userID,
+		"username": username,
+		"ids":      ids,
+	})
 }
 
 func getUserPronouns(username string) string {
@@ -285,24 +517,3 @@ func LoadPronounMapOnce() {
 	}
 	log.Printf("[pronouns] fetched pronoun list of length %d\n", count)
 }
-
-func UpdateChatHistoryNickname(username string, nickname string) {
-	for i, msg := range chatHistory {
-		if msg.Username == username {
-			chatHistory[i].Nickname = nickname
-		}
-	}
-}
-
-func UpdateChatHistoryPronouns(username string, pronouns string) {
-	for i, msg := range chatHistory {
-		if msg.Username == username {
-			chatHistory[i].Pronouns = pronouns
-		}
-	}
-}
-
-func GetChatHistoryJSON() []byte {
-	b, _ := json.Marshal(chatHistory)
-	return b
-}
@@ -0,0 +1,265 @@
+package multiChat
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HELD_MAX_MESSAGES = 200 //past this the oldest held message is dropped, or sent early in the delay mode, so an unattended queue can't grow forever
+
+	// values of the overlay_mode channel prop
+	OVERLAY_MODE_LIVE     = "live"     //messages go out as soon as they pass the chat filter
+	OVERLAY_MODE_DELAY    = "delay"    //messages wait overlay_delay_secs, mods can approve or reject them meanwhile
+	OVERLAY_MODE_APPROVAL = "approval" //messages wait until a mod approves them
+)
+
+// heldMessage is a message waiting for review, byMode is false when the chat filter held it,
+// those always wait for a mod even in the delay mode
+type heldMessage struct {
+	msg    ChatMessage
+	byMode bool
+}
+
+var (
+	// messages held for a mod to review, oldest first
+	heldMessages []heldMessage
+	heldLock     sync.Mutex
+
+	overlayMode     = OVERLAY_MODE_LIVE
+	overlayDelay    time.Duration
+	overlayModeLock sync.Mutex
+)
+
+// SetOverlayMode sets whether chat goes to the overlays right away, after delay, or once a mod approves it.
+// Going back to live sends out everything the old mode was holding, the other modes take over what it was holding.
+func SetOverlayMode(mode string, delay time.Duration) {
+	if mode != OVERLAY_MODE_DELAY && mode != OVERLAY_MODE_APPROVAL {
+		mode = OVERLAY_MODE_LIVE
+	}
+	overlayModeLock.Lock()
+	overlayMode, overlayDelay = mode, delay
+	overlayModeLock.Unlock()
+	log.Printf("[websocket] overlay mode is %s (delay %v)", mode, delay)
+	switch mode {
+	case OVERLAY_MODE_LIVE:
+		for _, h := range takeAllHeld(func(h heldMessage) bool { return h.byMode }) {
+			publishHeld(h.msg)
+		}
+	case OVERLAY_MODE_APPROVAL:
+		// e.g. a mod tightening it during a raid, the delayed messages mustn't slip out on their own anymore
+		rehold(func(h heldMessage) bool { return h.byMode && h.msg.HeldFor != "approval" }, waitForApproval)
+	case OVERLAY_MODE_DELAY:
+		ids := rehold(func(h heldMessage) bool { return h.byMode && h.msg.HeldFor != "delay" }, func(msg *ChatMessage) { waitForDelay(msg, delay) })
+		for _, id := range ids {
+			time.AfterFunc(delay, func() { release(id) })
+		}
+	}
+}
+
+func waitForApproval(msg *ChatMessage) {
+	msg.HeldFor = "approval"
+	msg.ReleaseAt = nil
+}
+
+// waitForDelay marks the message to go out after delay, the caller starts the timer once it is held
+func waitForDelay(msg *ChatMessage, delay time.Duration) {
+	releaseAt := time.Now().Add(delay)
+	msg.ReleaseAt = &releaseAt
+	msg.HeldFor = "delay"
+}
+
+// rehold changes the held messages that match, sends the mods the new list and returns the IDs it changed
+func rehold(match func(heldMessage) bool, change func(msg *ChatMessage)) []string {
+	heldLock.Lock()
+	var ids []string
+	for i := range heldMessages {
+		if match(heldMessages[i]) {
+			change(&heldMessages[i].msg)
+			ids = append(ids, heldMessages[i].msg.ID)
+		}
+	}
+	heldLock.Unlock()
+	if len(ids) > 0 {
+		BroadcastMods("held_messages", HeldMessages())
+	}
+	return ids
+}
+
+// queue holds the message if the overlay mode says to, it returns false if the message should go out now.
+// Mods and the broadcaster are never held.
+func queue(msg ChatMessage) bool {
+	overlayModeLock.Lock()
+	mode, delay := overlayMode, overlayDelay
+	overlayModeLock.Unlock()
+	if mode == OVERLAY_MODE_LIVE || RoleLevel(msg.Role) >= RoleLevel(ROLE_MOD) {
+		return false
+	}
+	if mode == OVERLAY_MODE_DELAY {
+		waitForDelay(&msg, delay)
+		hold(msg, true)
+		time.AfterFunc(delay, func() { release(msg.ID) })
+	} else {
+		waitForApproval(&msg)
+		hold(msg, true)
+	}
+	return true
+}
+
+func hold(msg ChatMessage, byMode bool) {
+	overlayModeLock.Lock()
+	delayed := overlayMode == OVERLAY_MODE_DELAY
+	overlayModeLock.Unlock()
+
+	heldLock.Lock()
+	log.Printf("[websocket] [%s] HOLD CHAT %s (%s): %s", msg.Source, msg.Username, msg.HeldFor, msg.Text)
+	heldMessages = append(heldMessages, heldMessage{msg, byMode})
+	var overflow *heldMessage
+	if len(heldMessages) > HELD_MAX_MESSAGES {
+		oldest := heldMessages[0]
+		overflow = &oldest
+		heldMessages = slices.Delete(heldMessages, 0, 1)
+	}
+	heldLock.Unlock()
+
+	BroadcastMods("held", msg)
+	if overflow == nil {
+		return
+	}
+	BroadcastMods("unheld", map[string]any{"id": overflow.msg.ID})
+	// in the delay mode it was going out soon anyway, dropping it would reject it without a mod ever saying so
+	if overflow.byMode && delayed {
+		log.Printf("[websocket] [%s] too many held messages, releasing %s early: %s", overflow.msg.Source, overflow.msg.Username, overflow.msg.Text)
+		publishHeld(overflow.msg)
+		return
+	}
+	log.Printf("[websocket] [%s] too many held messages, dropping %s: %s", overflow.msg.Source, overflow.msg.Username, overflow.msg.Text)
+}
+
+// HeldMessages lists the messages waiting for a mod, oldest first
+func HeldMessages() []ChatMessage {
+	heldLock.Lock()
+	defer heldLock.Unlock()
+	msgs := make([]ChatMessage, 0, len(heldMessages))
+	for _, h := range heldMessages {
+		msgs = append(msgs, h.msg)
+	}
+	return msgs
+}
+
+// takeAllHeld removes the held messages that match from the queue and tells the mods
+func takeAllHeld(match func(heldMessage) bool) []heldMessage {
+	heldLock.Lock()
+	var taken []heldMessage
+	heldMessages = slices.DeleteFunc(heldMessages, func(h heldMessage) bool {
+		if match(h) {
+			taken = append(taken, h)
+			return true
+		}
+		return false
+	})
+	heldLock.Unlock()
+	for _, h := range taken {
+		BroadcastMods("unheld", map[string]any{"id": h.msg.ID})
+	}
+	return taken
+}
+
+// takeHeld removes a held message from the queue, it returns false if there is no such message,
+// e.g. because another mod already reviewed it
+func takeHeld(id string) (ChatMessage, bool) {
+	taken := takeAllHeld(func(h heldMessage) bool { return h.msg.ID == id })
+	if len(taken) == 0 {
+		return ChatMessage{}, false
+	}
+	return taken[0].msg, true
+}
+
+// ApproveHeld sends a held message to the chat as if it just arrived
+func ApproveHeld(id string) bool {
+	msg, ok := takeHeld(id)
+	if !ok {
+		return false
+	}
+	log.Printf("[websocket] [%s] APPROVE CHAT %s: %s", msg.Source, msg.Username, msg.Text)
+	publishHeld(msg)
+	return true
+}
+
+// ApproveAllHeld sends every held message to the chat, e.g. once a raid calms down, and returns how many there were
+func ApproveAllHeld() int {
+	taken := takeAllHeld(func(heldMessage) bool { return true })
+	for _, h := range taken {
+		publishHeld(h.msg)
+	}
+	log.Printf("[websocket] APPROVE ALL %d held messages", len(taken))
+	return len(taken)
+}
+
+// ReleaseHeld sends on a message the chat filter held, as if it had passed the filter, e.g. once a slow check
+// cleared it. The overlay mode can still hold it. It returns false if the message isn't held anymore.
+func ReleaseHeld(id string) bool {
+	msg, ok := takeHeld(id)
+	if !ok {
+		return false
+	}
+	msg.HeldFor = ""
+	if !queue(msg) {
+		publish(msg)
+	}
+	return true
+}
+
+// release sends a message out once its delay is up, unless a mod already reviewed it or the overlay mode changed
+// since. A message that was waiting for its delay when a mod switched to approval keeps waiting for a mod.
+func release(id string) {
+	overlayModeLock.Lock()
+	delayed := overlayMode == OVERLAY_MODE_DELAY
+	overlayModeLock.Unlock()
+	waiting := func(h heldMessage) bool { return h.msg.ID == id && h.byMode && h.msg.HeldFor == "delay" }
+	if !delayed {
+		rehold(waiting, waitForApproval)
+		return
+	}
+	now := time.Now()
+	taken := takeAllHeld(func(h heldMessage) bool {
+		// the timer of an older delay, the mode changed back and forth since
+		return waiting(h) && h.msg.ReleaseAt != nil && !h.msg.ReleaseAt.After(now)
+	})
+	for _, h := range taken {
+		publishHeld(h.msg)
+	}
+}
+
+// RejectHeld drops a held message, it is never shown or stored
+func RejectHeld(id string) bool {
+	msg, ok := takeHeld(id)
+	if ok {
+		log.Printf("[websocket] [%s] REJECT CHAT %s: %s", msg.Source, msg.Username, msg.Text)
+	}
+	return ok
+}
+
+func publishHeld(msg ChatMessage) {
+	msg.HeldFor = ""
+	msg.ReleaseAt = nil
+	publish(msg)
+}
+
+// dropHeld removes the held messages that match, e.g. from a user who was just banned
+func dropHeld(match func(ChatMessage) bool) {
+	takeAllHeld(func(h heldMessage) bool { return match(h.msg) })
+}
+
+func sameUser(msg ChatMessage, source, userID, username string) bool {
+	if msg.Source != source {
+		return false
+	}
+	if userID != "" {
+		return msg.UserID == userID
+	}
+	return strings.EqualFold(msg.Username, username)
+}
@@ -0,0 +1,187 @@
+package multiChat
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+)
+
+func setupRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	env.STATE_DB_URL = "redis://" + mr.Addr()
+	env.TWITCH_CHANNEL = "test"
+	redisClient.Init()
+	t.Cleanup(func() {
+		SetChatFilter(nil)
+		SetOverlayMode(OVERLAY_MODE_LIVE, 0)
+		takeAllHeld(func(heldMessage) bool { return true })
+	})
+}
+
+// historyTexts lists the stored messages, an event by its system message
+func historyTexts(t *testing.T) []string {
+	msgs, err := GetChatHistory(context.Background(), "", "", CHAT_HISTORY_MAX_PAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{}
+	for _, msg := range msgs {
+		if msg.Event != nil {
+			texts = append(texts, msg.Event.SystemMessage)
+		} else {
+			texts = append(texts, msg.Text)
+		}
+	}
+	return texts
+}
+
+// heldID finds a held message by its text or an event by its system message
+func heldID(t *testing.T, text string) string {
+	for _, msg := range HeldMessages() {
+		if msg.Text == text || (msg.Event != nil && msg.Event.SystemMessage == text) {
+			return msg.ID
+		}
+	}
+	t.Fatalf("%q isn't held", text)
+	return ""
+}
+
+func chat(text string) ChatMessage {
+	return ChatMessage{Source: "owncast", UserID: "1", Username: "alice", Text: text}
+}
+
+func event(systemMessage, text string) ChatMessage {
+	return ChatMessage{Source: "owncast", UserID: "1", Username: "alice", Text: text,
+		Event: &Event{Kind: EVENT_FOLLOW, User: "alice", SystemMessage: systemMessage}}
+}
+
+func TestRejectedNeverStored(t *testing.T) {
+	setupRedis(t)
+	SetOverlayMode(OVERLAY_MODE_APPROVAL, 0)
+	SetChatFilter(func(msg *ChatMessage) (string, func()) {
+		text := msg.Text
+		if msg.Event != nil {
+			text += " " + msg.Event.SystemMessage
+		}
+		switch {
+		case strings.Contains(text, "spam"):
+			return FILTER_HIDE, nil
+		case strings.Contains(text, "sus"):
+			return FILTER_HOLD, nil
+		}
+		return FILTER_ALLOW, nil
+	})
+
+	SendChatMessage(chat("hello"))
+	SendChatMessage(chat("buy spam"))
+	SendChatMessage(chat("sus link"))
+	SendChatMessage(chat("rejected"))
+	SendEvent(event("alice followed", ""))
+	SendEvent(event("alice followed again", "spam"))
+	SendEvent(event("sus followed", ""))
+	SendEvent(event("rejected follow", ""))
+
+	if got := historyTexts(t); len(got) != 0 {
+		t.Fatalf("history before any review = %q, want nothing", got)
+	}
+	if n := len(HeldMessages()); n != 6 {
+		t.Fatalf("%d held messages, want 6 without the hidden ones", n)
+	}
+
+	for _, text := range []string{"hello", "sus link", "alice followed", "sus followed"} {
+		if !ApproveHeld(heldID(t, text)) {
+			t.Errorf("couldn't approve %q", text)
+		}
+	}
+	for _, text := range []string{"rejected", "rejected follow"} {
+		if !RejectHeld(heldID(t, text)) {
+			t.Errorf("couldn't reject %q", text)
+		}
+	}
+
+	want := []string{"hello", "sus link", "alice followed", "sus followed"}
+	if got := historyTexts(t); !slices.Equal(got, want) {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+	if held := HeldMessages(); len(held) != 0 {
+		t.Errorf("still holding %+v", held)
+	}
+}
+
+func TestReleaseHeldWaitsForMode(t *testing.T) {
+	setupRedis(t)
+	SetOverlayMode(OVERLAY_MODE_APPROVAL, 0)
+	SetChatFilter(func(msg *ChatMessage) (string, func()) { return FILTER_HOLD, nil })
+	SendChatMessage(chat("checking"))
+	SetChatFilter(nil)
+
+	if !ReleaseHeld(heldID(t, "checking")) {
+		t.Fatal("couldn't release it")
+	}
+	held := HeldMessages()
+	if len(held) != 1 || held[0].HeldFor != "approval" {
+		t.Fatalf("held = %+v, want it waiting for approval", held)
+	}
+	if got := historyTexts(t); len(got) != 0 {
+		t.Errorf("history = %q, the approval mode should still hold it", got)
+	}
+}
+
+func TestHeldOverflow(t *testing.T) {
+	tests := []struct {
+		mode    string
+		history []string
+	}{
+		{OVERLAY_MODE_DELAY, []string{"0"}},
+		{OVERLAY_MODE_APPROVAL, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			setupRedis(t)
+			SetOverlayMode(test.mode, time.Hour)
+			for i := 0; i <= HELD_MAX_MESSAGES; i++ {
+				SendEvent(event(strconv.Itoa(i), ""))
+			}
+			if n := len(HeldMessages()); n != HELD_MAX_MESSAGES {
+				t.Errorf("%d held messages, want %d", n, HELD_MAX_MESSAGES)
+			}
+			if got := historyTexts(t); !slices.Equal(got, test.history) {
+				t.Errorf("history = %q, want %q", got, test.history)
+			}
+		})
+	}
+}
+
+func TestOverlayModeChange(t *testing.T) {
+	setupRedis(t)
+	SetOverlayMode(OVERLAY_MODE_DELAY, 50*time.Millisecond)
+	SendChatMessage(chat("raid message"))
+	SetOverlayMode(OVERLAY_MODE_APPROVAL, 0)
+	time.Sleep(150 * time.Millisecond)
+
+	held := HeldMessages()
+	if len(held) != 1 || held[0].HeldFor != "approval" || held[0].ReleaseAt != nil {
+		t.Fatalf("held = %+v, want it waiting for approval", held)
+	}
+	if got := historyTexts(t); len(got) != 0 {
+		t.Fatalf("history = %q, the delay shouldn't release it after switching to approval", got)
+	}
+
+	// and back, it goes out after the new delay
+	SetOverlayMode(OVERLAY_MODE_DELAY, 20*time.Millisecond)
+	if held := HeldMessages(); len(held) != 1 || held[0].HeldFor != "delay" || held[0].ReleaseAt == nil {
+		t.Fatalf("held = %+v, want it waiting for the delay", held)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := historyTexts(t); !slices.Equal(got, []string{"raid message"}) {
+		t.Errorf("history = %q, want the message once the delay is up", got)
+	}
+}
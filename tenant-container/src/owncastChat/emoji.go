@@ -0,0 +1,119 @@
+package owncastChat
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	OWNCAST_HTTP_TIMEOUT = 10 * time.Second
+)
+
+var (
+	imgTagRegex    = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	attrRegex      = regexp.MustCompile(`(?i)\b(src|alt|title)="([^"]*)"`)
+	tagRegex       = regexp.MustCompile(`<[^>]*>`)
+	shortcodeRegex = regexp.MustCompile(`:[A-Za-z0-9_\-]+:`)
+
+	// the server's custom emoji, ":name:" => absolute image URL
+	owncastEmoji     = make(map[string]string)
+	owncastEmojiLock sync.Mutex
+)
+
+// loadEmoji fetches the server's custom emoji list from /api/emoji
+func loadEmoji(baseURL string) {
+	client := &http.Client{Timeout: OWNCAST_HTTP_TIMEOUT}
+	resp, err := client.Get(baseURL + "/api/emoji")
+	if err != nil {
+		log.Println("[owncast] emoji error:", err)
+		return
+	}
+	defer resp.Body.Close()
+	var list []struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		log.Println("[owncast] emoji decode error:", err)
+		return
+	}
+	emoji := make(map[string]string, len(list))
+	for _, e := range list {
+		emoji[":"+e.Name+":"] = absoluteURL(baseURL, e.URL)
+	}
+	owncastEmojiLock.Lock()
+	owncastEmoji = emoji
+	owncastEmojiLock.Unlock()
+	log.Printf("[owncast] loaded %d custom emoji", len(emoji))
+}
+
+func absoluteURL(baseURL, src string) string {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return src
+	}
+	return baseURL + "/" + strings.TrimPrefix(src, "/")
+}
+
+// parseBody turns owncast's HTML message body into plain text. Emoji <img> tags become their
+// :name: and typed :name: codes are matched against the custom emoji, both go in the emotes map (positions in runes).
+func parseBody(baseURL, body string) (string, map[string][]string) {
+	emotes := make(map[string][]string)
+	var sb strings.Builder
+	pos := 0
+	addEmote := func(code, url string) {
+		length := utf8.RuneCountInString(code)
+		emotes[url] = append(emotes[url], fmt.Sprintf("%d-%d", pos, pos+length-1))
+		sb.WriteString(code)
+		pos += length
+	}
+	addText := func(raw string) {
+		text := strings.ReplaceAll(raw, "\n", " ")
+		text = html.UnescapeString(tagRegex.ReplaceAllString(text, ""))
+		owncastEmojiLock.Lock()
+		defer owncastEmojiLock.Unlock()
+		last := 0
+		for _, m := range shortcodeRegex.FindAllStringIndex(text, -1) {
+			url, ok := owncastEmoji[text[m[0]:m[1]]]
+			if !ok {
+				continue
+			}
+			sb.WriteString(text[last:m[0]])
+			pos += utf8.RuneCountInString(text[last:m[0]])
+			addEmote(text[m[0]:m[1]], url)
+			last = m[1]
+		}
+		sb.WriteString(text[last:])
+		pos += utf8.RuneCountInString(text[last:])
+	}
+
+	last := 0
+	for _, m := range imgTagRegex.FindAllStringIndex(body, -1) {
+		addText(body[last:m[0]])
+		attrs := map[string]string{}
+		for _, a := range attrRegex.FindAllStringSubmatch(body[m[0]:m[1]], -1) {
+			attrs[strings.ToLower(a[1])] = html.UnescapeString(a[2])
+		}
+		code := attrs["alt"]
+		if code == "" {
+			code = attrs["title"]
+		}
+		if code == "" {
+			code = ":emoji:"
+		}
+		if attrs["src"] != "" {
+			addEmote(code, absoluteURL(baseURL, attrs["src"]))
+		}
+		last = m[1]
+	}
+	addText(body[last:])
+	// only trim the end, trimming the start would shift the emote positions
+	return strings.TrimRight(sb.String(), " "), emotes
+}
@@ -5,81 +5,78 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/gorilla/websocket"
 
 	"multibot/common/src/env"
 
+	"multibot/tenant-container/src/chatSource"
 	"multibot/tenant-container/src/multiChat"
 	"multibot/tenant-container/src/props"
-	"multibot/tenant-container/src/twitchChat"
 )
 
 var (
 	owncastConnected bool
 	owncastConn      *websocket.Conn
+	owncastBaseURL   string
 	owncastCloseMu   sync.Mutex // to guard owncastConn
+	owncastWriteMu   sync.Mutex // to guard writes to owncastConn
 )
 
-func ConnectToOwncastLoop() {
-	for {
-		ConnectToOwncast()
-		time.Sleep(1 * time.Minute)
-	}
-}
+// Source is the owncast ChatSource, registered with the supervisor in main
+var Source chatSource.ChatSource = source{}
 
-func ConnectToOwncast() {
-	if !props.GetChannelProp(nil, "enabled").(bool) {
-		log.Println("[owncast] bot is disabled, will not connect")
-		return
-	}
-	if owncastConnected {
-		log.Println("[owncast] already connected, will not connect")
-		return
-	}
+type source struct{}
+
+func (source) Name() string { return "owncast" }
+
+func (source) Start() error {
 	ocURLRaw := props.GetChannelProp(nil, "owncast_url")
 	owncastURL, _ := ocURLRaw.(string)
 	if owncastURL == "" {
-		log.Println("[owncast] no owncast_url, skipping connect")
-		return
+		return fmt.Errorf("no owncast_url: %w", chatSource.ErrNotConfigured)
+	}
+	baseURL, wsBaseURL, err := serverURLs(owncastURL)
+	if err != nil {
+		return err
 	}
 	regBody := map[string]any{"displayName": env.DEFAULT_BOT_NICKNAME}
 	regBytes, _ := json.Marshal(regBody)
-	apiURL := "https://" + owncastURL + "/api/chat/register"
+	apiURL := baseURL + "/api/chat/register"
 	resp, err := http.Post(apiURL, "application/json", strings.NewReader(string(regBytes)))
 	if err != nil {
-		log.Println("[owncast] register error:", err)
-		return
+		return fmt.Errorf("register error: %w", err)
 	}
 	defer resp.Body.Close()
 	var reg map[string]any
 	if err := json.NewDecoder(resp.Body).Decode(&reg); err != nil {
-		log.Println("[owncast] register decode error:", err)
-		return
+		return fmt.Errorf("register decode error: %w", err)
 	}
 	token, _ := reg["accessToken"].(string)
 	if token == "" {
-		log.Println("[owncast] no accessToken returned")
-		return
+		return fmt.Errorf("no accessToken returned")
 	}
 	log.Printf("[owncast] status: %d, token: %s\n", resp.StatusCode, token)
-	wsURL := fmt.Sprintf("wss://%s/ws?accessToken=%s", owncastURL, token)
+	loadEmoji(baseURL)
+	wsURL := fmt.Sprintf("%s/ws?accessToken=%s", wsBaseURL, url.QueryEscape(token))
 	c, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
 	if err != nil {
-		log.Println("[owncast] ws connect error:", err)
-		return
+		return fmt.Errorf("ws connect error: %w", err)
 	}
+	owncastCloseMu.Lock()
 	owncastConn = c
+	owncastBaseURL = baseURL
 	owncastConnected = true
+	owncastCloseMu.Unlock()
 	log.Println("[owncast] connected to", wsURL)
 	//delay the message a bit to allow the disconnect message to come thru first
-	twitchChat.SayLater("connected to owncast chat: https://" + owncastURL)
+	chatSource.SayAllLater("connected to owncast chat: " + baseURL)
 
 	go func() {
-		defer DisconnectFromOwncast()
+		defer disconnect(c)
 		for {
 			_, messageBytes, err := c.ReadMessage()
 			if err != nil {
@@ -91,69 +88,200 @@ func ConnectToOwncast() {
 				if line == "" {
 					continue
 				}
-				var msg map[string]any
+				var msg owncastMessage
 				if err := json.Unmarshal([]byte(line), &msg); err != nil {
 					log.Println("[owncast] parse err:", err)
 					continue
 				}
-				parseOwncastMessage(msg)
+				parseOwncastMessage(baseURL, msg)
 			}
 		}
 	}()
+	return nil
 }
 
-func parseOwncastMessage(m map[string]any) {
-	t, _ := m["type"].(string)
-	body, _ := m["body"].(string)
-	userRaw, _ := m["user"].(map[string]any)
-	if body == "" || userRaw == nil {
-		return
-	}
-	dispName, _ := userRaw["displayName"].(string)
-	if dispName == "" {
-		return
-	}
-	color := "rgb(255,255,255)"
-	if val, ok := userRaw["displayColor"].(float64); ok {
-		color = fmt.Sprintf("hsla(%d, 100%%, 60%%, 0.85)", int(val))
-	}
-	if t != "CHAT" {
-		body = strings.ReplaceAll(body, "<p>", "")
-		body = strings.ReplaceAll(body, "</p>", "")
-		body = strings.ReplaceAll(body, "\n", " ")
-	}
-	if t == "FEDIVERSE_ENGAGEMENT_LIKE" {
-		title, _ := m["title"].(string)
-		image, _ := m["image"].(string)
-		body = fmt.Sprintf("%s %s %s", title, image, body)
-		if image != "" {
-			start := strings.Index(body, image)
-			end := start + len(image) - 1
-			emotes := map[string][]string{
-				image: {fmt.Sprintf("%d-%d", start, end)},
-			}
-			multiChat.SendChat("owncast", dispName, "", color, body, emotes)
+// serverURLs turns the owncast_url prop into the http and websocket base URLs. It keeps the scheme and
+// port if there are any, so LAN servers on http://host:8080 work, and defaults to https for a bare host.
+func serverURLs(owncastURL string) (string, string, error) {
+	if !strings.Contains(owncastURL, "://") {
+		owncastURL = "https://" + owncastURL
+	}
+	u, err := url.Parse(owncastURL)
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("invalid owncast_url %q", owncastURL)
+	}
+	wsScheme := "wss"
+	switch u.Scheme {
+	case "https":
+	case "http":
+		wsScheme = "ws"
+	default:
+		return "", "", fmt.Errorf("owncast_url must be http or https, not %q", u.Scheme)
+	}
+	path := strings.TrimRight(u.Path, "/")
+	return u.Scheme + "://" + u.Host + path, wsScheme + "://" + u.Host + path, nil
+}
+
+// owncastMessage is everything the owncast chat websocket sends, which fields are set depends on Type
+type owncastMessage struct {
+	Type    string       `json:"type"`
+	ID      string       `json:"id"`
+	Body    string       `json:"body"`
+	User    *owncastUser `json:"user"`
+	OldName string       `json:"oldName"` // NAME_CHANGE
+	IDs     []string     `json:"ids"`     // VISIBILITY-UPDATE
+	Visible bool         `json:"visible"` // VISIBILITY-UPDATE
+	Title   string       `json:"title"`   // FEDIVERSE_ENGAGEMENT_*, the fediverse account
+	Image   string       `json:"image"`   // FEDIVERSE_ENGAGEMENT_*, the account's avatar
+	Link    string       `json:"link"`    // FEDIVERSE_ENGAGEMENT_*
+}
+
+type owncastUser struct {
+	ID           string   `json:"id"`
+	DisplayName  string   `json:"displayName"`
+	DisplayColor *int     `json:"displayColor"`
+	Scopes       []string `json:"scopes"`
+}
+
+func (u *owncastUser) color() string {
+	if u == nil || u.DisplayColor == nil {
+		return "rgb(255,255,255)"
+	}
+	return fmt.Sprintf("hsla(%d, 100%%, 60%%, 0.85)", *u.DisplayColor)
+}
+
+func (u *owncastUser) role() string {
+	if u == nil {
+		return ""
+	}
+	for _, scope := range u.Scopes {
+		if scope == "MODERATOR" {
+			return multiChat.ROLE_MOD
+		}
+	}
+	return ""
+}
+
+func parseOwncastMessage(baseURL string, m owncastMessage) {
+	switch m.Type {
+	case "CHAT":
+		if m.User == nil || m.User.DisplayName == "" || m.Body == "" {
+			return
+		}
+		text, emotes := parseBody(baseURL, m.Body)
+		multiChat.SendChatMessage(multiChat.ChatMessage{
+			PlatformID: m.ID,
+			UserID:     m.User.ID,
+			Source:     "owncast",
+			Username:   m.User.DisplayName,
+			Color:      m.User.color(),
+			Emotes:     emotes,
+			Text:       text,
+			Role:       m.User.role(),
+		})
+	case "USER_JOINED":
+		if m.User == nil || m.User.DisplayName == "" {
+			return
+		}
+		sendEvent(m, m.User.DisplayName, &multiChat.Event{Kind: multiChat.EVENT_USER_JOINED, User: m.User.DisplayName,
+			SystemMessage: m.User.DisplayName + " joined the chat"})
+	case "NAME_CHANGE":
+		if m.User == nil || m.User.DisplayName == "" {
 			return
 		}
+		sendEvent(m, m.User.DisplayName, &multiChat.Event{Kind: multiChat.EVENT_NAME_CHANGE, User: m.User.DisplayName,
+			SystemMessage: fmt.Sprintf("%s is now known as %s", m.OldName, m.User.DisplayName)})
+	case "CHAT_ACTION":
+		text, _ := parseBody(baseURL, m.Body)
+		if text == "" {
+			return
+		}
+		username := ""
+		if m.User != nil {
+			username = m.User.DisplayName
+		}
+		sendEvent(m, username, &multiChat.Event{Kind: multiChat.EVENT_ACTION, User: username, SystemMessage: text})
+	case "VISIBILITY-UPDATE":
+		// a moderator hid (or unhid) messages, hidden ones are removed like deleted messages
+		if m.Visible {
+			log.Printf("[owncast] %d messages were made visible again, they won't come back in the multichat", len(m.IDs))
+			return
+		}
+		for _, id := range m.IDs {
+			multiChat.DeleteMessage("owncast", id)
+		}
+	case "FEDIVERSE_ENGAGEMENT_FOLLOW", "FEDIVERSE_ENGAGEMENT_LIKE", "FEDIVERSE_ENGAGEMENT_REPOST":
+		kind := map[string]string{
+			"FEDIVERSE_ENGAGEMENT_FOLLOW": multiChat.EVENT_FOLLOW,
+			"FEDIVERSE_ENGAGEMENT_LIKE":   multiChat.EVENT_FEDI_LIKE,
+			"FEDIVERSE_ENGAGEMENT_REPOST": multiChat.EVENT_FEDI_BOOST,
+		}[m.Type]
+		text, _ := parseBody(baseURL, m.Body)
+		if text == "" {
+			text = fmt.Sprintf("%s: %s", kind, m.Title)
+		}
+		sendEvent(m, m.Title, &multiChat.Event{Kind: kind, User: m.Title, Image: m.Image, SystemMessage: text})
+	case "CONNECTED_USER_INFO", "USER_PARTED", "SYSTEM":
+	default:
+		log.Printf("[owncast] unhandled message type %s", m.Type)
+	}
+}
+
+func sendEvent(m owncastMessage, username string, event *multiChat.Event) {
+	msg := multiChat.ChatMessage{
+		PlatformID: m.ID,
+		Source:     "owncast",
+		Username:   username,
+		Event:      event,
+	}
+	if m.User != nil {
+		msg.UserID = m.User.ID
+		msg.Color = m.User.color()
+		msg.Role = m.User.role()
 	}
-	multiChat.SendChat("owncast", dispName, "", color, body, nil)
+	multiChat.SendEvent(msg)
+}
+
+func (source) Stop() {
+	owncastCloseMu.Lock()
+	c := owncastConn
+	owncastCloseMu.Unlock()
+	disconnect(c)
 }
 
-func DisconnectFromOwncast() {
+// disconnect closes c if it is still the current connection, so an old read loop can't close a newer connection
+func disconnect(c *websocket.Conn) {
 	owncastCloseMu.Lock()
 	defer owncastCloseMu.Unlock()
-	if owncastConnected && owncastConn != nil {
+	if owncastConnected && owncastConn != nil && owncastConn == c {
 		log.Println("[owncast] disconnecting")
 		owncastConn.Close()
 		owncastConn = nil
 		owncastConnected = false
-		twitchChat.Say("disconnected from owncast chat")
+		go chatSource.SayAll("disconnected from owncast chat")
+	}
+}
+
+// Say posts into the chat as the user the bot registered on connect
+func (source) Say(text string) error {
+	owncastCloseMu.Lock()
+	c := owncastConn
+	owncastCloseMu.Unlock()
+	if c == nil {
+		return fmt.Errorf("not connected")
 	}
+	msg, _ := json.Marshal(map[string]any{"type": "CHAT", "body": text})
+	owncastWriteMu.Lock()
+	defer owncastWriteMu.Unlock()
+	return c.WriteMessage(websocket.TextMessage, msg)
 }
 
-func GetStatus() map[string]interface{} {
-	return map[string]interface{}{
-		"connected":   owncastConnected,
-		"owncastConn": strings.ReplaceAll(strings.ReplaceAll(fmt.Sprintf("%#v", owncastConn), "0x0, ", ""), "0x0", "..."),
+func (source) Status() chatSource.Status {
+	return chatSource.Status{
+		Connected: owncastConnected,
+		Details: map[string]any{
+			"url":         owncastBaseURL,
+			"owncastConn": strings.ReplaceAll(strings.ReplaceAll(fmt.Sprintf("%#v", owncastConn), "0x0, ", ""), "0x0", "..."),
+		},
 	}
 }
@@ -0,0 +1,184 @@
+package platformAuth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+)
+
+const (
+	OAUTH_STATE_TTL = 10 * time.Minute //how long the streamer has to finish logging in on the platform
+)
+
+// Provider is an oauth app the channel owner logs in to, so the bot can act on their behalf on that platform
+type Provider struct {
+	Name   string
+	Config *oauth2.Config
+	PKCE   bool
+}
+
+var (
+	providers     = make(map[string]*Provider)
+	providersLock sync.Mutex
+	tokenLock     sync.Mutex // so two refreshes can't race and store an outdated token
+)
+
+// Register adds a provider, it is skipped if the oauth app isn't configured in the env
+func Register(p *Provider) {
+	if p.Config.ClientID == "" {
+		log.Printf("[auth] no client ID for %s, logging in to %s will be disabled", p.Name, p.Name)
+		return
+	}
+	p.Config.RedirectURL = fmt.Sprintf("%s/%s/auth/%s/callback", env.BASE_URL, env.TWITCH_CHANNEL, p.Name)
+	providersLock.Lock()
+	providers[p.Name] = p
+	providersLock.Unlock()
+}
+
+func get(name string) (*Provider, bool) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// tokens are kept out of the channel props so they are never sent to the browser
+func tokenKey(name string) string {
+	return "channels/" + env.TWITCH_CHANNEL + "/oauth_tokens/" + name
+}
+
+func stateKey(state string) string {
+	return "channels/" + env.TWITCH_CHANNEL + "/oauth_state/" + state
+}
+
+// Token returns a valid access token for the platform, refreshing and storing it if it expired
+func Token(ctx context.Context, name string) (*oauth2.Token, error) {
+	p, ok := get(name)
+	if !ok {
+		return nil, fmt.Errorf("%s login is not set up", name)
+	}
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+	raw, err := redisClient.Get(ctx, tokenKey(name)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("not logged in to %s", name)
+	}
+	var tok oauth2.Token
+	if err := json.Unmarshal([]byte(raw), &tok); err != nil {
+		return nil, fmt.Errorf("bad stored %s token: %w", name, err)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	fresh, err := p.Config.TokenSource(ctx, &tok).Token()
+	if err != nil {
+		return nil, fmt.Errorf("%s token refresh failed: %w", name, err)
+	}
+	if fresh.AccessToken != tok.AccessToken {
+		log.Printf("[auth] refreshed %s token", name)
+		saveToken(ctx, name, fresh)
+	}
+	return fresh, nil
+}
+
+// IsLoggedIn reports whether the channel owner has connected their account on the platform
+func IsLoggedIn(ctx context.Context, name string) bool {
+	n, _ := redisClient.Exists(ctx, tokenKey(name)).Result()
+	return n > 0
+}
+
+func saveToken(ctx context.Context, name string, tok *oauth2.Token) {
+	raw, _ := json.Marshal(tok)
+	redisClient.Set(ctx, tokenKey(name), raw, 0)
+}
+
+// LoginHandler sends the channel owner to the platform to log in, /auth/{platform}
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := get(mux.Vars(r)["platform"])
+	if !ok {
+		http.Error(w, "unknown platform", http.StatusNotFound)
+		return
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	state := hex.EncodeToString(b)
+	verifier := ""
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline, oauth2.ApprovalForce}
+	if p.PKCE {
+		verifier = oauth2.GenerateVerifier()
+		opts = append(opts, oauth2.S256ChallengeOption(verifier))
+	}
+	// store the verifier under the state so the callback can finish the exchange
+	redisClient.Set(r.Context(), stateKey(state), verifier, OAUTH_STATE_TTL)
+	http.Redirect(w, r, p.Config.AuthCodeURL(state, opts...), http.StatusFound)
+}
+
+// CallbackHandler finishes the login and stores the token, /auth/{platform}/callback
+func CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["platform"]
+	p, ok := get(name)
+	if !ok {
+		http.Error(w, "unknown platform", http.StatusNotFound)
+		return
+	}
+	if errMsg := r.URL.Query().Get("error"); errMsg != "" {
+		http.Error(w, fmt.Sprintf("%s login failed: %s", name, errMsg), http.StatusBadRequest)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	verifier, err := redisClient.Get(r.Context(), stateKey(state)).Result()
+	if state == "" || err != nil {
+		http.Error(w, "invalid or expired state, please try again", http.StatusBadRequest)
+		return
+	}
+	redisClient.Del(r.Context(), stateKey(state))
+
+	var opts []oauth2.AuthCodeOption
+	if p.PKCE {
+		opts = append(opts, oauth2.VerifierOption(verifier))
+	}
+	tok, err := p.Config.Exchange(r.Context(), r.URL.Query().Get("code"), opts...)
+	if err != nil {
+		log.Printf("[auth] %s exchange failed: %v", name, err)
+		http.Error(w, fmt.Sprintf("%s login failed", name), http.StatusBadRequest)
+		return
+	}
+	saveToken(r.Context(), name, tok)
+	log.Printf("[auth] logged in to %s", name)
+	http.Redirect(w, r, "/"+env.TWITCH_CHANNEL, http.StatusFound)
+}
+
+// LogoutHandler forgets the stored token, DELETE /auth/{platform}
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["platform"]
+	redisClient.Del(r.Context(), tokenKey(name))
+	log.Printf("[auth] logged out of %s", name)
+	w.Write([]byte("ok"))
+}
+
+// Status lists which platforms can be logged in to and whether the channel owner is logged in
+func Status(ctx context.Context) map[string]bool {
+	providersLock.Lock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	providersLock.Unlock()
+	status := make(map[string]bool)
+	for _, name := range names {
+		status[name] = IsLoggedIn(ctx, name)
+	}
+	return status
+}
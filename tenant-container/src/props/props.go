@@ -1,6 +1,7 @@
 package props
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"log"
@@ -16,21 +17,55 @@ import (
 
 var (
 	DEFAULT_CHANNEL_PROPS = map[string]interface{}{
-		"enabled":             true,
-		"did_first_run":       false,
-		"fwd_cmds_yt_twitch":  []string{"!sr", "!test"},
-		"max_nickname_length": 20,
-		"greetz_threshold":    (5 * time.Hour).Milliseconds(),
-		"greetz_wb_threshold": (45 * time.Minute).Milliseconds(),
-		"youtube_id":          "",
-		"owncast_url":         "",
-		"kick_username":       "",
-		"kick_chatroom_id":    "",
-		"show_usernames":      true, // Whether to show certain data in the rendered chat
-		"show_nicknames":      true,
-		"show_pronouns":       true,
-		"text_shadow":         "1px 1px 2px black",
-		"font":                `"Cabin", "Segoe UI", "Helvetica Neue", Helvetica, Arial, sans-serif`,
+		"enabled":               true,
+		"did_first_run":         false,
+		"fwd_cmds_yt_twitch":    []string{"!sr", "!test"}, // legacy, only used until fwd_rules is saved
+		"fwd_rules":             nil,                      // list of command forwarding rules, see cmdForwarding.Rule
+		"timers":                nil,                      // list of recurring messages, see timers.Timer
+		"max_nickname_length":   20,
+		"greetz_threshold":      (5 * time.Hour).Milliseconds(),
+		"greetz_wb_threshold":   (45 * time.Minute).Milliseconds(),
+		"greetz_reply_on":       "source",            // "source" greets on the viewer's platform, or a platform name like "twitch"
+		"event_thanks":          map[string]string{}, // event kind => what the bot says for it, see greetz.ThankEvent
+		"chatbot_enabled":       false,
+		"chatbot_backend":       "local", // "local" (keyword rules + markov chain) or "openai"
+		"chatbot_cooldown_secs": 30,
+		"chatbot_ignore_bots":   true, // don't learn from or answer the bots in chatbot_bot_names
+		"chatbot_bot_names":     []string{"nightbot", "streamelements", "streamlabs", "moobot", "fossabot", "wizebot", "sery_bot", "botrixoficial"},
+		"chatbot_rules":         nil, // list of keyword replies, see chatbot.Rule
+		"chatbot_openai_url":    "https://api.openai.com/v1",
+		"chatbot_openai_model":  "gpt-4o-mini",
+		"chatbot_system_prompt": "You are {bot}, a friendly bot in the chat of {channel}'s livestream. Reply in one or two short sentences, no markdown.",
+		"youtube_id":            "",
+		"youtube_mode":          "scrape",   // "scrape" or "api", the other one is used if it fails
+		"youtube_video_ids":     []string{}, // only read these videos, e.g. unlisted streams, instead of every live video on youtube_id
+		"owncast_url":           "",
+		"tiktok_username":       "",
+		"kick_username":         "",
+		"kick_chatroom_id":      "",
+		"discord_channel_id":    "",
+		"discord_relay_sources": []string{}, // sources whose chat the bot posts into the discord channel
+		"matrix_homeserver":     "",         // e.g. matrix.org or http://localhost:6167
+		"matrix_room":           "",         // room ID or alias, e.g. #mychannel:matrix.org
+		"irc_server":            "",
+		"irc_port":              6697,
+		"irc_tls":               true,
+		"irc_nick":              "",         // the bot's twitch username if empty
+		"irc_channels":          []string{}, // e.g. #mychannel
+		"irc_sasl_username":     "",         // the password is set separately, props are public
+		"irc_relay_sources":     []string{}, // sources whose chat the bot posts into the irc channels
+		"automod_enabled":       false,
+		"automod_exempt_role":   multiChat.ROLE_MOD,          // chatters with this role or higher skip automod, "" = nobody does
+		"overlay_mode":          multiChat.OVERLAY_MODE_LIVE, // "live", "delay" or "approval", see multiChat.SetOverlayMode
+		"overlay_delay_secs":    10,
+		"mod_delegates":         []string{}, // twitch logins that can delete messages, time out and ban from the bot page
+		"show_usernames":        true,       // Whether to show certain data in the rendered chat
+		"show_nicknames":        true,
+		"show_events":           multiChat.DefaultShownEvents(), // which kinds of multiChat events show in the chat
+		"show_pronouns":         true,
+		"show_streams":          false, // tag messages with which stream they came from, for sources that read several
+		"text_shadow":           "1px 1px 2px black",
+		"font":                  `"Cabin", "Segoe UI", "Helvetica Neue", Helvetica, Arial, sans-serif`,
 	}
 	DEFAULT_VIEWER_PROPS = map[string]interface{}{
 		"nickname":      nil,
@@ -139,6 +174,15 @@ func GetChannelPropAs[T int | int64 | float64](ctx context.Context, propName str
 	return defaultValue
 }
 
+// GetChannelPropJSON decodes a structured channel prop (a list or object) into out, e.g. a []SomeStruct
+func GetChannelPropJSON(ctx context.Context, propName string, out any) error {
+	raw, err := json.Marshal(GetChannelProp(ctx, propName))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
 func SetChannelProp(ctx context.Context, propName string, propValue interface{}) {
 	// check old value if needed
 	var oldVal interface{}
@@ -158,13 +202,20 @@ func SetChannelProp(ctx context.Context, propName string, propValue interface{})
 	})
 
 	// trigger listeners if changed
-	if listeners, exists := channelPropListeners[propName]; exists && oldVal != propValue {
+	if listeners, exists := channelPropListeners[propName]; exists && propChanged(oldVal, propValue) {
 		for _, fn := range listeners {
 			fn(oldVal, propValue)
 		}
 	}
 }
 
+// propChanged compares props as JSON, since lists and objects decoded from JSON can't be compared with !=
+func propChanged(oldVal, newVal any) bool {
+	oldRaw, _ := json.Marshal(oldVal)
+	newRaw, _ := json.Marshal(newVal)
+	return !bytes.Equal(oldRaw, newRaw)
+}
+
 func GetViewerProp(ctx context.Context, username, propName string) any {
 	val, err := redisClient.HGet(ctx, viewerKey(username), propName).Result()
 	if err == redis.Nil {
@@ -212,7 +263,7 @@ func SetViewerProp(ctx context.Context, username, propName string, propValue int
 		"prop_name":  propName,
 		"prop_value": propValue,
 	})
-	if hasListeners && oldVal != propValue {
+	if hasListeners && propChanged(oldVal, propValue) {
 		for _, fn := range listeners {
 			fn(username, oldVal, propValue)
 		}
@@ -0,0 +1,34 @@
+package props
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPropChanged(t *testing.T) {
+	// what a list prop looks like after a round trip thru redis, comparing these with != panics
+	var saved, again any
+	json.Unmarshal([]byte(`["a", "b"]`), &saved)
+	json.Unmarshal([]byte(`["a", "b"]`), &again)
+	var other any
+	json.Unmarshal([]byte(`["a", "c"]`), &other)
+
+	cases := []struct {
+		name     string
+		old, new any
+		want     bool
+	}{
+		{"same decoded list", saved, again, false},
+		{"default list and saved list", []string{"a", "b"}, saved, false},
+		{"changed list", saved, other, true},
+		{"unset to list", nil, saved, true},
+		{"list to unset", saved, nil, true},
+		{"default int and decoded number", int64(5), float64(5), false},
+		{"changed string", "live", "delay", true},
+	}
+	for _, c := range cases {
+		if got := propChanged(c.old, c.new); got != c.want {
+			t.Errorf("%s: propChanged = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
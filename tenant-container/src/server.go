@@ -3,26 +3,46 @@ package main
 import (
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/twitch"
 
 	"multibot/common/src/env"
 	"multibot/common/src/redisClient"
 	"multibot/common/src/redisSession"
 
+	"multibot/tenant-container/src/automod"
+	"multibot/tenant-container/src/chatCommands"
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/chatbot"
+	"multibot/tenant-container/src/cmdForwarding"
+	"multibot/tenant-container/src/discordChat"
 	"multibot/tenant-container/src/emotes"
 	"multibot/tenant-container/src/frontend"
+	"multibot/tenant-container/src/greetz"
+	"multibot/tenant-container/src/identity"
+	"multibot/tenant-container/src/ircChat"
 	"multibot/tenant-container/src/kickChat"
+	"multibot/tenant-container/src/matrixChat"
+	"multibot/tenant-container/src/moderation"
 	"multibot/tenant-container/src/multiChat"
 	"multibot/tenant-container/src/owncastChat"
+	"multibot/tenant-container/src/platformAuth"
 	"multibot/tenant-container/src/props"
+	"multibot/tenant-container/src/tiktokChat"
+	"multibot/tenant-container/src/timers"
+	"multibot/tenant-container/src/twitchApi"
 	"multibot/tenant-container/src/twitchChat"
+	"multibot/tenant-container/src/webhooks"
 	"multibot/tenant-container/src/youtubeApi"
 	"multibot/tenant-container/src/youtubeChat"
 )
@@ -43,41 +63,49 @@ func main() {
 	// Run first-run checks.
 	go ensureFirstRun()
 
-	// Set up property listeners
-	props.AddChannelPropListener("youtube_id", func(oldValue, newValue interface{}) {
-		log.Printf("youtube_id changed from %v to %v", oldValue, newValue)
-		youtubeChat.DisconnectFromYouTube()
-		youtubeChat.ConnectToYouTube()
-	})
-	props.AddChannelPropListener("owncast_url", func(oldValue, newValue interface{}) {
-		log.Printf("owncast_url changed from %v to %v", oldValue, newValue)
-		owncastChat.DisconnectFromOwncast()
-		owncastChat.ConnectToOwncast()
+	// Register the chat sources, each one restarts when its own props change
+	chatSource.Register(twitchChat.Source)
+	chatSource.Register(youtubeChat.Source, "youtube_id", "youtube_mode", "youtube_video_ids")
+	chatSource.Register(owncastChat.Source, "owncast_url")
+	chatSource.Register(kickChat.Source, "kick_chatroom_id")
+	chatSource.Register(tiktokChat.Source, "tiktok_username")
+	chatSource.Register(discordChat.Source, "discord_channel_id")
+	chatSource.Register(matrixChat.Source, "matrix_homeserver", "matrix_room")
+	chatSource.Register(ircChat.Source, "irc_server", "irc_port", "irc_tls", "irc_nick", "irc_channels", "irc_sasl_username")
+
+	// Let the channel owner log in to twitch for eventsub (follows, channel points, etc.)
+	platformAuth.Register(&platformAuth.Provider{
+		Name: "twitch",
+		Config: &oauth2.Config{
+			ClientID:     env.TWITCH_CLIENT_ID,
+			ClientSecret: env.TWITCH_SECRET,
+			Endpoint:     twitch.Endpoint,
+			Scopes:       strings.Fields(twitchApi.EVENTSUB_SCOPES + " " + twitchApi.MODERATION_SCOPES),
+		},
 	})
-	props.AddChannelPropListener("kick_chatroom_id", func(oldValue, newValue interface{}) {
-		log.Printf("kick_chatroom_id changed from %v to %v", oldValue, newValue)
-		kickChat.DisconnectFromKick()
-		kickChat.ConnectToKick()
+
+	// Let the channel owner log in to youtube and kick so the bot can post there
+	platformAuth.Register(&platformAuth.Provider{
+		Name: "youtube",
+		Config: &oauth2.Config{
+			ClientID:     env.YOUTUBE_CLIENT_ID,
+			ClientSecret: env.YOUTUBE_CLIENT_SECRET,
+			Endpoint:     youtubeApi.OAuthEndpoint,
+			Scopes:       []string{youtubeApi.OAUTH_SCOPE},
+		},
 	})
-	props.AddChannelPropListener("enabled", func(oldValue, newValue interface{}) {
-		log.Printf("enabled changed from %v to %v", oldValue, newValue)
-		go func() {
-			twitchChat.DisconnectFromTwitch()
-			twitchChat.ConnectToTwitch()
-		}()
-		go func() {
-			youtubeChat.DisconnectFromYouTube()
-			youtubeChat.ConnectToYouTube()
-		}()
-		go func() {
-			owncastChat.DisconnectFromOwncast()
-			owncastChat.ConnectToOwncast()
-		}()
-		go func() {
-			kickChat.DisconnectFromKick()
-			kickChat.ConnectToKick()
-		}()
+	platformAuth.Register(&platformAuth.Provider{
+		Name: "kick",
+		Config: &oauth2.Config{
+			ClientID:     env.KICK_CLIENT_ID,
+			ClientSecret: env.KICK_CLIENT_SECRET,
+			Endpoint:     kickChat.OAuthEndpoint,
+			Scopes:       strings.Fields(kickChat.KICK_OAUTH_SCOPES),
+		},
+		PKCE: true,
 	})
+
+	// Set up property listeners
 	props.AddViewerPropListener("nickname", func(username string, oldValue, newValue interface{}) {
 		// If a user's nickname changes, update the chat history so old messages will show the new nickname
 		log.Printf("nickname for %s changed from %v to %v", username, oldValue, newValue)
@@ -90,11 +118,46 @@ func main() {
 		multiChat.UpdateChatHistoryNickname(username, nickname)
 	})
 
-	// Start background tasks to attempt connections.
-	go twitchChat.ConnectToTwitchLoop()
-	go youtubeChat.ConnectToYouTubeLoop()
-	go owncastChat.ConnectToOwncastLoop()
-	go kickChat.ConnectToKickLoop()
+	// Point chatters linked with !link at their viewer record
+	multiChat.SetIdentityResolver(identity.Resolve)
+
+	// Hide, mask or hold messages according to the automod rules before they reach the overlay
+	automod.MoveRulesFromProps(nil)
+	multiChat.SetChatFilter(automod.Filter)
+	automod.RegisterAccountAge("twitch", twitchChat.AccountCreatedAt)
+	automod.RegisterAccountAge("youtube", youtubeChat.AccountCreatedAt)
+
+	// Hold chat for a delay or until a mod approves it, according to overlay_mode
+	applyOverlayMode := func(oldValue, newValue interface{}) {
+		mode, _ := props.GetChannelProp(nil, "overlay_mode").(string)
+		delay := props.GetChannelPropAs(nil, "overlay_delay_secs", 10)
+		multiChat.SetOverlayMode(mode, time.Duration(delay)*time.Second)
+	}
+	props.AddChannelPropListener("overlay_mode", applyOverlayMode)
+	props.AddChannelPropListener("overlay_delay_secs", applyOverlayMode)
+	applyOverlayMode(nil, nil)
+
+	// Run commands and greetz for chat from every platform
+	multiChat.AddChatListener(chatCommands.HandleChat)
+
+	// Thank for follows, subs, raids etc. according to event_thanks
+	multiChat.AddEventListener(greetz.ThankEvent)
+
+	// Forward commands between platforms according to fwd_rules
+	multiChat.AddChatListener(cmdForwarding.HandleChat)
+	multiChat.AddChatListener(timers.HandleChat)
+
+	// Reply when the bot is mentioned or replied to
+	multiChat.AddChatListener(chatbot.HandleChat)
+
+	// Relay chat from the sources in discord_relay_sources into discord
+	multiChat.AddChatListener(discordChat.HandleChat)
+
+	// Relay chat from the sources in irc_relay_sources into the irc channels
+	multiChat.AddChatListener(ircChat.HandleChat)
+
+	// Start background tasks to keep the chat sources connected.
+	chatSource.Run()
 
 	// fetch the pronoun list from pronouns.alejo.io at startup:
 	go multiChat.LoadPronounMapOnce()
@@ -102,6 +165,15 @@ func main() {
 	// start a background cycle to keep your 3rd-party emotes updated:
 	go emotes.EmoteCacheRefresher()
 
+	// teach the chatbot's markov chain the chat history:
+	go chatbot.Train()
+
+	// post the recurring messages while chat is active:
+	go timers.Run()
+
+	// drop chat history older than CHAT_HISTORY_MAX_DAYS:
+	go multiChat.ChatHistoryTrimmer()
+
 	// Setup HTTP routes.
 	router := mux.NewRouter()
 
@@ -110,6 +182,7 @@ func main() {
 
 	router.HandleFunc("/ws", multiChat.WsHandler)
 	router.HandleFunc("/ws/num_clients", multiChat.WsNumClientsHandler)
+	router.Handle("/ws/mod", modAuthMiddleware(http.HandlerFunc(multiChat.ModWsHandler)))
 
 	router.HandleFunc("/", indexHandler)
 	router.HandleFunc("/chat", indexHandlerChat)
@@ -135,11 +208,47 @@ func main() {
 	router.HandleFunc("/chat_history", chatHistoryHandler).Methods("GET")
 	router.HandleFunc("/find_youtube_id", youtubeApi.FindYoutubeIDHandler).Methods("GET")
 
-	router.HandleFunc("/status/twitch", statusTwitchHandler).Methods("GET")
-	router.HandleFunc("/status/youtube", statusYouTubeHandler).Methods("GET")
-	router.HandleFunc("/status/owncast", statusOwncastHandler).Methods("GET")
-	router.HandleFunc("/status/kick", statusKickHandler).Methods("GET")
+	router.HandleFunc("/commands", getCommandsHandler).Methods("GET")
+	router.Handle("/commands", channelAuthMiddleware(http.HandlerFunc(saveCommandHandler))).Methods("POST")
+	router.Handle("/commands/{name}", channelAuthMiddleware(http.HandlerFunc(deleteCommandHandler))).Methods("DELETE")
+
+	router.HandleFunc("/link_code", linkCodeHandler).Methods("POST")
+	router.HandleFunc("/links", getLinksHandler).Methods("GET")
+	router.HandleFunc("/links/{key}", deleteLinkHandler).Methods("DELETE")
+
+	router.HandleFunc("/chatbot", chatbotStatusHandler).Methods("GET")
+	router.Handle("/chatbot/api_key", channelAuthMiddleware(http.HandlerFunc(chatbotAPIKeyHandler))).Methods("POST")
+	router.HandleFunc("/discord", discordSettingsHandler).Methods("GET")
+	router.Handle("/discord/bot_token", channelAuthMiddleware(http.HandlerFunc(discordBotTokenHandler))).Methods("POST")
+	router.HandleFunc("/matrix", matrixSettingsHandler).Methods("GET")
+	router.Handle("/matrix/access_token", channelAuthMiddleware(http.HandlerFunc(matrixAccessTokenHandler))).Methods("POST")
+	router.HandleFunc("/irc", ircSettingsHandler).Methods("GET")
+	router.Handle("/irc/sasl_password", channelAuthMiddleware(http.HandlerFunc(ircSASLPasswordHandler))).Methods("POST")
+
+	router.Handle("/webhooks", channelAuthMiddleware(http.HandlerFunc(getWebhooksHandler))).Methods("GET")
+	router.Handle("/webhooks", channelAuthMiddleware(http.HandlerFunc(createWebhookHandler))).Methods("POST")
+	router.Handle("/webhooks/{source}", channelAuthMiddleware(http.HandlerFunc(deleteWebhookHandler))).Methods("DELETE")
+	router.HandleFunc("/webhooks/{source}/messages", webhookMessageHandler).Methods("POST")
+
+	router.HandleFunc("/moderation", moderationStatusHandler).Methods("GET")
+	router.Handle("/moderation/{action}", modAuthMiddleware(http.HandlerFunc(moderationHandler))).Methods("POST")
+
+	router.Handle("/automod/rules", channelAuthMiddleware(http.HandlerFunc(getAutomodRulesHandler))).Methods("GET")
+	router.Handle("/automod/rules", channelAuthMiddleware(http.HandlerFunc(setAutomodRulesHandler))).Methods("POST")
+	router.Handle("/automod/log", modAuthMiddleware(http.HandlerFunc(automodLogHandler))).Methods("GET")
+	router.Handle("/held_messages", modAuthMiddleware(http.HandlerFunc(heldMessagesHandler))).Methods("GET")
+	router.Handle("/held_messages/approve_all", modAuthMiddleware(http.HandlerFunc(approveAllHeldHandler))).Methods("POST")
+	router.Handle("/held_messages/{id}", modAuthMiddleware(http.HandlerFunc(approveHeldHandler))).Methods("POST")
+	router.Handle("/held_messages/{id}", modAuthMiddleware(http.HandlerFunc(rejectHeldHandler))).Methods("DELETE")
+
+	router.HandleFunc("/sources", sourcesHandler).Methods("GET")
+	router.HandleFunc("/auth", authStatusHandler).Methods("GET")
+	router.Handle("/auth/{platform}", channelAuthMiddleware(http.HandlerFunc(platformAuth.LoginHandler))).Methods("GET")
+	router.Handle("/auth/{platform}", channelAuthMiddleware(http.HandlerFunc(platformAuth.LogoutHandler))).Methods("DELETE")
+	router.Handle("/auth/{platform}/callback", channelAuthMiddleware(http.HandlerFunc(platformAuth.CallbackHandler))).Methods("GET")
 	router.HandleFunc("/status/emotes", statusEmotesHandler).Methods("GET")
+	router.HandleFunc("/status/{source}", statusSourceHandler).Methods("GET")
+	router.Handle("/restart/{source}", channelAuthMiddleware(http.HandlerFunc(restartSourceHandler))).Methods("POST")
 
 	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		now := time.Now().UnixMilli()
@@ -218,24 +327,336 @@ func indexHandlerChat(w http.ResponseWriter, r *http.Request) {
 // Handlers for each /status/* route
 // --------------------------------------------------
 
-// /status/twitch
-func statusTwitchHandler(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, twitchChat.GetStatus())
+// /commands lists the custom commands
+func getCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, chatCommands.ListCommands())
+}
+
+// POST /commands creates or replaces a custom command
+func saveCommandHandler(w http.ResponseWriter, r *http.Request) {
+	var cmd chatCommands.Command
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	cmd, err := chatCommands.SaveCommand(r.Context(), cmd)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	respondJSON(w, cmd)
+}
+
+func deleteCommandHandler(w http.ResponseWriter, r *http.Request) {
+	chatCommands.DeleteCommand(r.Context(), mux.Vars(r)["name"])
+	w.Write([]byte("ok"))
+}
+
+// sessionViewer is the viewer record of the logged in twitch user, the same name twitch chat uses
+func sessionViewer(r *http.Request) string {
+	user, _ := redisSession.GetSessionUser(r)
+	if user == nil {
+		return ""
+	}
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return user.Login
+}
+
+// POST /link_code gives the logged in user a code to type as "!link CODE" on their other platforms
+func linkCodeHandler(w http.ResponseWriter, r *http.Request) {
+	viewer := sessionViewer(r)
+	if viewer == "" {
+		http.Error(w, "Forbidden (no user in session)", http.StatusForbidden)
+		return
+	}
+	code, err := identity.CreateCode(r.Context(), viewer)
+	if err != nil {
+		log.Println("[identity] code error:", err)
+		http.Error(w, "could not create code", http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, map[string]any{"code": code, "expires_in_secs": int(identity.LINK_CODE_TTL.Seconds())})
+}
+
+// /links lists the logged in user's linked accounts
+func getLinksHandler(w http.ResponseWriter, r *http.Request) {
+	viewer := sessionViewer(r)
+	if viewer == "" {
+		respondJSON(w, []identity.Link{})
+		return
+	}
+	respondJSON(w, identity.Links(r.Context(), viewer))
+}
+
+// DELETE /links/{key} unlinks an account, for the user it is linked to or the channel owner
+func deleteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	viewer := sessionViewer(r)
+	if viewer == "" {
+		http.Error(w, "Forbidden (no user in session)", http.StatusForbidden)
+		return
+	}
+	user, isSuperAdmin := redisSession.GetSessionUser(r)
+	isOwner := strings.EqualFold(user.Login, env.TWITCH_CHANNEL) || isSuperAdmin
+	if !isOwner && !strings.EqualFold(identity.LinkedTo(r.Context(), key), viewer) {
+		http.Error(w, "Forbidden (not your linked account)", http.StatusForbidden)
+		return
+	}
+	identity.Unlink(r.Context(), key)
+	w.Write([]byte("ok"))
+}
+
+// /chatbot shows the chatbot backends and whether an API key is set
+func chatbotStatusHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, chatbot.Status(r.Context()))
+}
+
+// POST /chatbot/api_key stores the key for the openai backend, an empty key removes it
+func chatbotAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		APIKey string `json:"api_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := chatbot.SetAPIKey(r.Context(), body.APIKey); err != nil {
+		log.Println("[chatbot] error saving API key:", err)
+		http.Error(w, "could not save API key", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// /discord shows whether a discord bot token is set
+func discordSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, discordChat.Settings(r.Context()))
+}
+
+// POST /discord/bot_token stores the discord bot token and reconnects, an empty token removes it
+func discordBotTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		BotToken string `json:"bot_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := discordChat.SetBotToken(r.Context(), body.BotToken); err != nil {
+		log.Println("[discord] error saving bot token:", err)
+		http.Error(w, "could not save bot token", http.StatusInternalServerError)
+		return
+	}
+	chatSource.Restart("discord")
+	w.Write([]byte("ok"))
+}
+
+// /matrix shows whether a matrix access token is set
+func matrixSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, matrixChat.Settings(r.Context()))
+}
+
+// POST /matrix/access_token stores the matrix access token and reconnects, an empty token removes it
+func matrixAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := matrixChat.SetAccessToken(r.Context(), body.AccessToken); err != nil {
+		log.Println("[matrix] error saving access token:", err)
+		http.Error(w, "could not save access token", http.StatusInternalServerError)
+		return
+	}
+	chatSource.Restart("matrix")
+	w.Write([]byte("ok"))
+}
+
+// /irc shows whether an irc SASL password is set
+func ircSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, ircChat.Settings(r.Context()))
+}
+
+// POST /irc/sasl_password stores the password for irc_sasl_username and reconnects, an empty password removes it
+func ircSASLPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := ircChat.SetSASLPassword(r.Context(), body.Password); err != nil {
+		log.Println("[irc] error saving SASL password:", err)
+		http.Error(w, "could not save SASL password", http.StatusInternalServerError)
+		return
+	}
+	chatSource.Restart("irc")
+	w.Write([]byte("ok"))
+}
+
+// /webhooks lists the webhooks outside tools post thru
+func getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, webhooks.List(r.Context()))
+}
+
+// POST /webhooks creates a webhook, or gives an existing one a new token. The token is only shown this once.
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Source string `json:"source"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	token, err := webhooks.Create(r.Context(), body.Source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	respondJSON(w, map[string]string{"source": strings.ToLower(strings.TrimSpace(body.Source)), "token": token})
+}
+
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks.Delete(r.Context(), mux.Vars(r)["source"])
+	w.Write([]byte("ok"))
+}
+
+// POST /webhooks/{source}/messages posts a chat message or event, authenticated with "Authorization: Bearer <token>"
+func webhookMessageHandler(w http.ResponseWriter, r *http.Request) {
+	source := mux.Vars(r)["source"]
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !webhooks.Authenticate(r.Context(), source, token) {
+		http.Error(w, "Forbidden (bad webhook token)", http.StatusForbidden)
+		return
+	}
+	var msg webhooks.Message
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, webhooks.WEBHOOK_MAX_BODY_BYTES)).Decode(&msg); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := webhooks.Post(source, msg); err != nil {
+		if errors.Is(err, webhooks.ErrRateLimited) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(webhooks.WEBHOOK_RATE_WINDOW.Seconds())))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// /moderation tells the bot page whether to show the mod buttons, and which sources can moderate on the platform
+func moderationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, map[string]any{"can_moderate": canModerate(r), "sources": chatSource.ModeratorNames()})
+}
+
+// POST /moderation/{delete,timeout,ban} runs the action on the platform a message came from
+func moderationHandler(w http.ResponseWriter, r *http.Request) {
+	var req moderation.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.Action = mux.Vars(r)["action"]
+	results, err := moderation.Run(r.Context(), req, sessionViewer(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	respondJSON(w, results)
+}
+
+// /automod/rules lists the automod rules, they aren't a channel prop so chatters can't read the blocked words
+func getAutomodRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := automod.Rules(r.Context())
+	if err != nil {
+		log.Println("[automod] error reading the rules:", err)
+		http.Error(w, "could not read the rules", http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, rules)
+}
+
+// POST /automod/rules replaces the automod rules
+func setAutomodRulesHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Rules []automod.Rule `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := automod.SetRules(r.Context(), body.Rules); err != nil {
+		log.Println("[automod] error saving the rules:", err)
+		http.Error(w, "could not save the rules", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// /automod/log lists the latest messages the automod rules matched
+func automodLogHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, automod.Decisions())
 }
 
-// /status/youtube
-func statusYouTubeHandler(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, youtubeChat.GetStatus())
+// /held_messages lists the messages waiting for a mod to approve or reject them
+func heldMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, multiChat.HeldMessages())
 }
 
-// /status/owncast
-func statusOwncastHandler(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, owncastChat.GetStatus())
+func approveHeldHandler(w http.ResponseWriter, r *http.Request) {
+	if !multiChat.ApproveHeld(mux.Vars(r)["id"]) {
+		http.Error(w, "no such held message, it may have been reviewed already", http.StatusNotFound)
+		return
+	}
+	w.Write([]byte("ok"))
 }
 
-// /status/kick
-func statusKickHandler(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, kickChat.GetStatus())
+func approveAllHeldHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, map[string]int{"approved": multiChat.ApproveAllHeld()})
+}
+
+func rejectHeldHandler(w http.ResponseWriter, r *http.Request) {
+	if !multiChat.RejectHeld(mux.Vars(r)["id"]) {
+		http.Error(w, "no such held message, it may have been reviewed already", http.StatusNotFound)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// /sources lists the registered chat source names
+func sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, chatSource.Names())
+}
+
+// /auth lists the platforms the owner can log in to, and whether they are logged in
+func authStatusHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, platformAuth.Status(r.Context()))
+}
+
+// /status/twitch, /status/youtube, etc. for every registered chat source
+func statusSourceHandler(w http.ResponseWriter, r *http.Request) {
+	status, ok := chatSource.GetStatus(mux.Vars(r)["source"])
+	if !ok {
+		http.Error(w, "unknown source", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, status)
+}
+
+// /restart/twitch, /restart/youtube, etc.
+func restartSourceHandler(w http.ResponseWriter, r *http.Request) {
+	if !chatSource.Restart(mux.Vars(r)["source"]) {
+		http.Error(w, "unknown source", http.StatusNotFound)
+		return
+	}
+	w.Write([]byte("ok"))
 }
 
 // /status/emotes
@@ -342,9 +763,24 @@ func clearChatHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("ok"))
 }
 
+// /chat_history?before=<history_id>&after=<history_id>&limit=<n>, all optional
 func chatHistoryHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(multiChat.GetChatHistoryJSON())
+	q := r.URL.Query()
+	limit := 0
+	if l := q.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	history, err := multiChat.GetChatHistory(r.Context(), q.Get("before"), q.Get("after"), limit)
+	if err != nil {
+		log.Println("[history] error:", err)
+		http.Error(w, "error loading chat history", http.StatusBadRequest)
+		return
+	}
+	respondJSON(w, history)
 }
 
 func channelAuthMiddleware(next http.Handler) http.Handler {
@@ -362,6 +798,35 @@ func channelAuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// canModerate is true for the channel owner, super admins and the twitch users in the mod_delegates prop
+func canModerate(r *http.Request) bool {
+	user, isSuperAdmin := redisSession.GetSessionUser(r)
+	if user == nil {
+		return false
+	}
+	if strings.EqualFold(user.Login, env.TWITCH_CHANNEL) || isSuperAdmin {
+		return true
+	}
+	var delegates []string
+	props.GetChannelPropJSON(r.Context(), "mod_delegates", &delegates)
+	for _, login := range delegates {
+		if strings.EqualFold(strings.TrimSpace(login), user.Login) {
+			return true
+		}
+	}
+	return false
+}
+
+func modAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if canModerate(r) {
+			next.ServeHTTP(w, r)
+		} else {
+			http.Error(w, "Forbidden (not channel owner or delegated mod)", http.StatusForbidden)
+		}
+	})
+}
+
 func ensureFirstRun() {
 	didFirstRun := props.GetChannelProp(nil, "did_first_run").(bool)
 
@@ -0,0 +1,233 @@
+package tiktokChat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const TIKTOK_MAX_PAYLOAD_BYTES = 4 << 20 //a gzipped frame that unpacks to more than this is refused, real ones are a few KB
+
+// tiktok doesn't publish its .proto files, the field numbers here are the ones the open source
+// TikTokLive libraries found, and we only decode the few fields we use
+
+// pbMessage is a decoded protobuf message, each field number's values in the order they came
+type pbMessage map[protowire.Number][]pbValue
+
+// pbValue is a varint/fixed value in num, or a string/bytes/embedded message in bytes
+type pbValue struct {
+	num   uint64
+	bytes []byte
+}
+
+func parseMessage(b []byte) (pbMessage, error) {
+	m := pbMessage{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v pbValue
+		switch typ {
+		case protowire.VarintType:
+			v.num, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var x uint32
+			x, n = protowire.ConsumeFixed32(b)
+			v.num = uint64(x)
+		case protowire.Fixed64Type:
+			v.num, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		m[num] = append(m[num], v)
+	}
+	return m, nil
+}
+
+// the getters return the last value of a field like protobuf does, or the zero value if it's missing
+
+func (m pbMessage) Int(num protowire.Number) int64 {
+	if vs := m[num]; len(vs) > 0 {
+		return int64(vs[len(vs)-1].num)
+	}
+	return 0
+}
+
+func (m pbMessage) Bool(num protowire.Number) bool {
+	return m.Int(num) != 0
+}
+
+func (m pbMessage) Bytes(num protowire.Number) []byte {
+	if vs := m[num]; len(vs) > 0 {
+		return vs[len(vs)-1].bytes
+	}
+	return nil
+}
+
+func (m pbMessage) Str(num protowire.Number) string {
+	return string(m.Bytes(num))
+}
+
+func (m pbMessage) Strs(num protowire.Number) []string {
+	strs := []string{}
+	for _, v := range m[num] {
+		strs = append(strs, string(v.bytes))
+	}
+	return strs
+}
+
+// Msg parses an embedded message, a broken one is treated as empty
+func (m pbMessage) Msg(num protowire.Number) pbMessage {
+	sub, err := parseMessage(m.Bytes(num))
+	if err != nil {
+		return pbMessage{}
+	}
+	return sub
+}
+
+func (m pbMessage) Msgs(num protowire.Number) []pbMessage {
+	msgs := []pbMessage{}
+	for _, v := range m[num] {
+		if sub, err := parseMessage(v.bytes); err == nil {
+			msgs = append(msgs, sub)
+		}
+	}
+	return msgs
+}
+
+// pushFrame is the envelope of every websocket frame (WebcastPushFrame)
+type pushFrame struct {
+	SeqID           int64
+	LogID           int64
+	PayloadEncoding string // "gzip" or "pb"
+	PayloadType     string // "msg" for a webcastResponse, "hb" heartbeat, "ack"
+	Payload         []byte
+}
+
+func decodePushFrame(b []byte) (pushFrame, error) {
+	m, err := parseMessage(b)
+	if err != nil {
+		return pushFrame{}, err
+	}
+	f := pushFrame{
+		SeqID:           m.Int(1),
+		LogID:           m.Int(2),
+		PayloadEncoding: m.Str(6),
+		PayloadType:     m.Str(7),
+		Payload:         m.Bytes(8),
+	}
+	if f.PayloadEncoding == "gzip" {
+		r, err := gzip.NewReader(bytes.NewReader(f.Payload))
+		if err != nil {
+			return f, fmt.Errorf("gzip: %w", err)
+		}
+		// read one byte past the limit to tell a payload of exactly the limit from a bigger one
+		if f.Payload, err = io.ReadAll(io.LimitReader(r, TIKTOK_MAX_PAYLOAD_BYTES+1)); err != nil {
+			return f, fmt.Errorf("gzip: %w", err)
+		}
+		if len(f.Payload) > TIKTOK_MAX_PAYLOAD_BYTES {
+			return f, fmt.Errorf("gzip: payload is over %d bytes", TIKTOK_MAX_PAYLOAD_BYTES)
+		}
+	}
+	return f, nil
+}
+
+func (f pushFrame) encode() []byte {
+	var b []byte
+	if f.SeqID != 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(f.SeqID))
+	}
+	if f.LogID != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(f.LogID))
+	}
+	b = protowire.AppendTag(b, 6, protowire.BytesType)
+	b = protowire.AppendString(b, f.PayloadEncoding)
+	b = protowire.AppendTag(b, 7, protowire.BytesType)
+	b = protowire.AppendString(b, f.PayloadType)
+	b = protowire.AppendTag(b, 8, protowire.BytesType)
+	b = protowire.AppendBytes(b, f.Payload)
+	return b
+}
+
+// heartbeatFrame keeps the websocket open, its payload is a HeartbeatMessage with the room ID
+func heartbeatFrame(roomID int64) []byte {
+	var hb []byte
+	hb = protowire.AppendTag(hb, 1, protowire.VarintType)
+	hb = protowire.AppendVarint(hb, uint64(roomID))
+	return pushFrame{PayloadEncoding: "pb", PayloadType: "hb", Payload: hb}.encode()
+}
+
+// ackFrame confirms a frame when the response asks for it, tiktok stops sending otherwise
+func ackFrame(logID int64, internalExt string) []byte {
+	return pushFrame{LogID: logID, PayloadEncoding: "pb", PayloadType: "ack", Payload: []byte(internalExt)}.encode()
+}
+
+// webcastResponse is the payload of a "msg" frame and the body of the first HTTP fetch (ProtoMessageFetchResult)
+type webcastResponse struct {
+	Messages    []webcastMessage
+	Cursor      string
+	InternalExt string
+	RouteParams map[string]string // extra query params for the websocket URL
+	NeedsAck    bool
+	PushServer  string // the websocket URL
+}
+
+// webcastMessage is one message inside a response, Method says what Payload is, e.g. "WebcastChatMessage"
+type webcastMessage struct {
+	Method  string
+	Payload []byte
+	MsgID   int64
+}
+
+func decodeWebcastResponse(b []byte) (webcastResponse, error) {
+	m, err := parseMessage(b)
+	if err != nil {
+		return webcastResponse{}, err
+	}
+	r := webcastResponse{
+		Cursor:      m.Str(2),
+		InternalExt: m.Str(5),
+		RouteParams: map[string]string{},
+		NeedsAck:    m.Bool(9),
+		PushServer:  m.Str(10),
+	}
+	for _, msg := range m.Msgs(1) {
+		r.Messages = append(r.Messages, webcastMessage{Method: msg.Str(1), Payload: msg.Bytes(2), MsgID: msg.Int(3)})
+	}
+	// a map field is a repeated message of key 1 and value 2
+	for _, kv := range m.Msgs(7) {
+		r.RouteParams[kv.Str(1)] = kv.Str(2)
+	}
+	return r, nil
+}
+
+type tiktokUser struct {
+	ID       int64
+	Nickname string // the display name
+	UniqueID string // the @username
+}
+
+func decodeUser(m pbMessage) tiktokUser {
+	return tiktokUser{ID: m.Int(1), Nickname: m.Str(3), UniqueID: m.Str(38)}
+}
+
+// firstURL is the first URL of an Image message
+func firstURL(image pbMessage) string {
+	if urls := image.Strs(1); len(urls) > 0 {
+		return urls[0]
+	}
+	return ""
+}
@@ -0,0 +1,519 @@
+package tiktokChat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"multibot/common/src/env"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	TIKTOK_ROOM_URL           = "https://www.tiktok.com/api-live/user/room/"
+	TIKTOK_WEBCAST_URL        = "https://webcast.tiktok.com"
+	TIKTOK_HTTP_TIMEOUT       = 10 * time.Second
+	TIKTOK_HEARTBEAT_INTERVAL = 10 * time.Second
+	TIKTOK_USER_AGENT         = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+	TIKTOK_ROOM_LIVE          = 2 //liveRoom.status when the user is live
+	TIKTOK_CONTROL_ENDED      = 3 //WebcastControlMessage action when the stream ends
+	TIKTOK_SOCIAL_FOLLOW      = 1 //WebcastSocialMessage action for a follow, the others are shares etc.
+	TIKTOK_GIFT_STREAKABLE    = 1 //gift type that repeats while a combo is going
+)
+
+// the query params the tiktok web client sends, tiktok rejects requests without them
+var clientParams = url.Values{
+	"aid":              {"1988"},
+	"app_language":     {"en-US"},
+	"app_name":         {"tiktok_web"},
+	"browser_language": {"en-US"},
+	"browser_name":     {"Mozilla"},
+	"browser_online":   {"true"},
+	"browser_platform": {"Win32"},
+	"cookie_enabled":   {"true"},
+	"device_platform":  {"web_pc"},
+	"screen_height":    {"1080"},
+	"screen_width":     {"1920"},
+	"tz_name":          {"Etc/UTC"},
+	"webcast_language": {"en"},
+}
+
+var (
+	errStreamEnded = errors.New("the tiktok stream ended")
+
+	tiktokConnected bool
+	tiktokConn      *websocket.Conn
+	tiktokUsername  string
+	tiktokRoomID    int64
+	tiktokError     string
+	tiktokWriteMu   sync.Mutex // to guard writes to tiktokConn
+	tiktokCloseMu   sync.Mutex // to guard the vars above
+)
+
+// clientQuery is a copy of clientParams to add to
+func clientQuery() url.Values {
+	q := url.Values{}
+	for k, v := range clientParams {
+		q[k] = slices.Clone(v)
+	}
+	return q
+}
+
+// Source is the tiktok ChatSource, registered with the supervisor in main
+var Source chatSource.ChatSource = source{}
+
+type source struct{}
+
+func (source) Name() string { return "tiktok" }
+
+// Username cleans up what was entered on the bot page, e.g. "@name" or "https://www.tiktok.com/@name/live"
+func Username(input string) string {
+	input = strings.TrimSpace(input)
+	if _, after, ok := strings.Cut(input, "tiktok.com/"); ok {
+		input, _, _ = strings.Cut(after, "/")
+	}
+	return strings.ToLower(strings.TrimPrefix(input, "@"))
+}
+
+func (source) Start() error {
+	username, _ := props.GetChannelProp(nil, "tiktok_username").(string)
+	username = Username(username)
+	if username == "" {
+		return fmt.Errorf("no tiktok username: %w", chatSource.ErrNotConfigured)
+	}
+	tiktokCloseMu.Lock()
+	tiktokUsername = username
+	tiktokCloseMu.Unlock()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Timeout: TIKTOK_HTTP_TIMEOUT, Jar: jar}
+
+	roomID, err := findRoom(client, username)
+	if err != nil {
+		setError(err)
+		return err
+	}
+	log.Printf("[tiktok] @%s is live in room %d, connecting...", username, roomID)
+	first, err := fetch(client, roomID)
+	if err != nil {
+		setError(err)
+		return fmt.Errorf("fetch error: %w", err)
+	}
+	if first.PushServer == "" {
+		err := errors.New("no websocket URL in the fetch response, tiktok might want a signed request, see TIKTOK_WEBCAST_URL")
+		setError(err)
+		return err
+	}
+
+	wsURL, err := url.Parse(first.PushServer)
+	if err != nil {
+		return fmt.Errorf("bad websocket URL %q: %w", first.PushServer, err)
+	}
+	q := clientQuery()
+	for k, v := range first.RouteParams {
+		q.Set(k, v)
+	}
+	q.Set("room_id", strconv.FormatInt(roomID, 10))
+	q.Set("cursor", first.Cursor)
+	q.Set("internal_ext", first.InternalExt)
+	q.Set("compress", "gzip")
+	q.Set("version_code", "270000")
+	q.Set("update_version_code", "1.3.0")
+	q.Set("heartbeatDuration", "0")
+	wsURL.RawQuery = q.Encode()
+	header := http.Header{"User-Agent": {TIKTOK_USER_AGENT}}
+	fetchURL, _ := url.Parse(webcastURL())
+	var cookies []string
+	for _, cookie := range jar.Cookies(fetchURL) {
+		cookies = append(cookies, cookie.String())
+	}
+	if len(cookies) > 0 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	c, _, err := websocket.DefaultDialer.Dial(wsURL.String(), header)
+	if err != nil {
+		setError(err)
+		return fmt.Errorf("ws connect error: %w", err)
+	}
+
+	tiktokCloseMu.Lock()
+	tiktokConn = c
+	tiktokConnected = true
+	tiktokRoomID = roomID
+	tiktokError = ""
+	tiktokCloseMu.Unlock()
+	log.Printf("[tiktok] connected to @%s", username)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(TIKTOK_HEARTBEAT_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := send(c, heartbeatFrame(roomID)); err != nil {
+					log.Println("[tiktok] heartbeat error:", err)
+					disconnect(c)
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		defer close(done)
+		defer disconnect(c)
+		for {
+			_, raw, err := c.ReadMessage()
+			if err != nil {
+				log.Println("[tiktok] read error:", err)
+				return
+			}
+			record(raw)
+			if err := handleFrame(c, raw, username); err != nil {
+				log.Println("[tiktok]", err)
+				if errors.Is(err, errStreamEnded) {
+					setError(err)
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// findRoom gets the room ID of the user's live, or an error if they aren't live
+func findRoom(client *http.Client, username string) (int64, error) {
+	q := clientQuery()
+	q.Set("uniqueId", username)
+	q.Set("sourceType", "54")
+	var body struct {
+		StatusCode int    `json:"statusCode"`
+		Message    string `json:"message"`
+		Data       struct {
+			User struct {
+				RoomID string `json:"roomId"`
+			} `json:"user"`
+			LiveRoom struct {
+				Status int `json:"status"`
+			} `json:"liveRoom"`
+		} `json:"data"`
+	}
+	if err := getJSON(client, TIKTOK_ROOM_URL+"?"+q.Encode(), &body); err != nil {
+		return 0, fmt.Errorf("room lookup: %w", err)
+	}
+	if body.StatusCode != 0 {
+		return 0, fmt.Errorf("room lookup for @%s: %s (%d)", username, body.Message, body.StatusCode)
+	}
+	roomID, _ := strconv.ParseInt(body.Data.User.RoomID, 10, 64)
+	if roomID == 0 || body.Data.LiveRoom.Status != TIKTOK_ROOM_LIVE {
+		return 0, fmt.Errorf("@%s is not live", username)
+	}
+	return roomID, nil
+}
+
+func webcastURL() string {
+	if env.TIKTOK_WEBCAST_URL != "" {
+		return strings.TrimSuffix(env.TIKTOK_WEBCAST_URL, "/")
+	}
+	return TIKTOK_WEBCAST_URL
+}
+
+// fetch does the first HTTP poll of the room, which says where the websocket is and sets the cookies for it.
+// It also has the last few chat messages, those are old so they are skipped.
+func fetch(client *http.Client, roomID int64) (webcastResponse, error) {
+	q := clientQuery()
+	q.Set("room_id", strconv.FormatInt(roomID, 10))
+	q.Set("resp_content_type", "protobuf")
+	q.Set("did_rule", "3")
+	q.Set("fetch_rule", "1")
+	q.Set("identity", "audience")
+	q.Set("live_id", "12")
+	q.Set("history_comment_count", "6")
+	q.Set("version_code", "180800")
+	req, err := http.NewRequest(http.MethodGet, webcastURL()+"/webcast/im/fetch/?"+q.Encode(), nil)
+	if err != nil {
+		return webcastResponse{}, err
+	}
+	req.Header.Set("User-Agent", TIKTOK_USER_AGENT)
+	resp, err := client.Do(req)
+	if err != nil {
+		return webcastResponse{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return webcastResponse{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return webcastResponse{}, fmt.Errorf("status %d: %.200s", resp.StatusCode, body)
+	}
+	return decodeWebcastResponse(body)
+}
+
+func getJSON(client *http.Client, u string, out any) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", TIKTOK_USER_AGENT)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// record saves a raw frame to TIKTOK_RECORD_DIR, to replay it later with tools/tiktokDecode
+func record(raw []byte) {
+	if env.TIKTOK_RECORD_DIR == "" {
+		return
+	}
+	name := filepath.Join(env.TIKTOK_RECORD_DIR, fmt.Sprintf("%d.bin", time.Now().UnixNano()))
+	if err := os.WriteFile(name, raw, 0o644); err != nil {
+		log.Println("[tiktok] record error:", err)
+	}
+}
+
+// handleFrame acks and sends the messages in one websocket frame
+func handleFrame(c *websocket.Conn, raw []byte, host string) error {
+	frame, resp, err := decodeFrame(raw)
+	if err != nil {
+		return err
+	}
+	if frame.PayloadType != "msg" {
+		return nil
+	}
+	if resp.NeedsAck {
+		send(c, ackFrame(frame.LogID, resp.InternalExt))
+	}
+	for _, m := range resp.Messages {
+		if m.Method == "WebcastControlMessage" {
+			if control, err := parseMessage(m.Payload); err == nil && control.Int(2) == TIKTOK_CONTROL_ENDED {
+				return errStreamEnded
+			}
+			continue
+		}
+		msg, ok, err := convert(m, host)
+		if err != nil {
+			log.Println("[tiktok] decode error:", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if msg.Event != nil {
+			multiChat.SendEvent(msg)
+		} else {
+			log.Printf("[tiktok] %s: %s", msg.Username, msg.Text)
+			multiChat.SendChatMessage(msg)
+		}
+	}
+	return nil
+}
+
+// decodeFrame unpacks a websocket frame, resp is empty unless the frame's PayloadType is "msg"
+func decodeFrame(raw []byte) (pushFrame, webcastResponse, error) {
+	frame, err := decodePushFrame(raw)
+	if err != nil {
+		return frame, webcastResponse{}, fmt.Errorf("frame: %w", err)
+	}
+	if frame.PayloadType != "msg" {
+		return frame, webcastResponse{}, nil
+	}
+	resp, err := decodeWebcastResponse(frame.Payload)
+	if err != nil {
+		return frame, resp, fmt.Errorf("response: %w", err)
+	}
+	return frame, resp, nil
+}
+
+// Decode turns one raw websocket frame into the chat messages and events it carries, without sending them.
+// host is the streamer's tiktok username. It needs no connection, tools/tiktokDecode uses it to replay recorded frames.
+func Decode(raw []byte, host string) ([]multiChat.ChatMessage, error) {
+	_, resp, err := decodeFrame(raw)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []multiChat.ChatMessage{}
+	for _, m := range resp.Messages {
+		msg, ok, err := convert(m, host)
+		if err != nil {
+			return msgs, err
+		}
+		if ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// convert turns a webcast message into a multichat message, ok is false for the kinds we don't show
+func convert(m webcastMessage, host string) (msg multiChat.ChatMessage, ok bool, err error) {
+	p, err := parseMessage(m.Payload)
+	if err != nil {
+		return msg, false, fmt.Errorf("%s: %w", m.Method, err)
+	}
+	// every message starts with a Common that has the message ID
+	msgID := strconv.FormatInt(p.Msg(1).Int(2), 10)
+
+	switch m.Method {
+	case "WebcastChatMessage":
+		user := decodeUser(p.Msg(2))
+		text := strings.TrimSpace(p.Str(3))
+		if user.UniqueID == "" || text == "" {
+			return msg, false, nil
+		}
+		return chatMessage(msgID, user, host, text), true, nil
+
+	case "WebcastGiftMessage":
+		user := decodeUser(p.Msg(7))
+		gift := p.Msg(15)
+		// a streakable gift (e.g. roses) is sent again for every tap of a combo, only show the last one
+		if gift.Int(11) == TIKTOK_GIFT_STREAKABLE && !p.Bool(9) {
+			return msg, false, nil
+		}
+		count := max(int(p.Int(5)), 1)
+		giftName := gift.Str(16)
+		if giftName == "" {
+			giftName = "a gift"
+		}
+		event := &multiChat.Event{
+			Kind:          multiChat.EVENT_TIKTOK_GIFT,
+			User:          displayName(user),
+			Count:         count,
+			Image:         firstURL(gift.Msg(1)),
+			SystemMessage: fmt.Sprintf("%s sent %s", displayName(user), giftName),
+		}
+		if count > 1 {
+			event.SystemMessage += fmt.Sprintf(" x%d", count)
+		}
+		if diamonds := gift.Int(12) * int64(count); diamonds > 0 {
+			event.Amount = fmt.Sprintf("%d💎", diamonds)
+			event.Currency = "💎"
+		}
+		msg = chatMessage(msgID, user, host, "")
+		msg.Event = event
+		return msg, true, nil
+
+	case "WebcastLikeMessage":
+		user := decodeUser(p.Msg(5))
+		count := int(p.Int(2))
+		if count == 0 {
+			return msg, false, nil
+		}
+		msg = chatMessage(msgID, user, host, "")
+		msg.Event = &multiChat.Event{
+			Kind:          multiChat.EVENT_TIKTOK_LIKE,
+			User:          displayName(user),
+			Count:         count,
+			SystemMessage: fmt.Sprintf("%s liked the stream x%d", displayName(user), count),
+		}
+		return msg, true, nil
+
+	case "WebcastSocialMessage":
+		if p.Int(4) != TIKTOK_SOCIAL_FOLLOW {
+			return msg, false, nil
+		}
+		user := decodeUser(p.Msg(2))
+		msg = chatMessage(msgID, user, host, "")
+		msg.Event = &multiChat.Event{
+			Kind:          multiChat.EVENT_FOLLOW,
+			User:          displayName(user),
+			SystemMessage: displayName(user) + " followed",
+		}
+		return msg, true, nil
+	}
+	return msg, false, nil
+}
+
+func chatMessage(msgID string, user tiktokUser, host, text string) multiChat.ChatMessage {
+	msg := multiChat.ChatMessage{
+		PlatformID: msgID,
+		UserID:     strconv.FormatInt(user.ID, 10),
+		Source:     "tiktok",
+		Username:   user.UniqueID,
+		Emotes:     make(map[string][]string),
+		Text:       text,
+	}
+	if strings.EqualFold(user.UniqueID, host) {
+		msg.Role = multiChat.ROLE_BROADCASTER
+	}
+	return msg
+}
+
+func displayName(user tiktokUser) string {
+	if user.Nickname != "" {
+		return user.Nickname
+	}
+	return user.UniqueID
+}
+
+func send(c *websocket.Conn, frame []byte) error {
+	tiktokWriteMu.Lock()
+	defer tiktokWriteMu.Unlock()
+	return c.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+func setError(err error) {
+	tiktokCloseMu.Lock()
+	defer tiktokCloseMu.Unlock()
+	tiktokError = err.Error()
+}
+
+func (source) Stop() {
+	tiktokCloseMu.Lock()
+	c := tiktokConn
+	tiktokCloseMu.Unlock()
+	disconnect(c)
+}
+
+// disconnect closes c if it is still the current connection, so an old read loop can't close a newer connection
+func disconnect(c *websocket.Conn) {
+	tiktokCloseMu.Lock()
+	defer tiktokCloseMu.Unlock()
+	if tiktokConnected && tiktokConn != nil && tiktokConn == c {
+		log.Println("[tiktok] disconnecting")
+		tiktokConn.Close()
+		tiktokConn = nil
+		tiktokConnected = false
+	}
+}
+
+func (source) Status() chatSource.Status {
+	tiktokCloseMu.Lock()
+	defer tiktokCloseMu.Unlock()
+	details := map[string]any{
+		"username": tiktokUsername,
+		"room_id":  tiktokRoomID,
+		"error":    tiktokError,
+	}
+	if tiktokConn != nil {
+		details["remote_addr"] = tiktokConn.RemoteAddr().String()
+	}
+	return chatSource.Status{
+		Connected: tiktokConnected,
+		Details:   details,
+	}
+}
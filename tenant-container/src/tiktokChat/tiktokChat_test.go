@@ -0,0 +1,165 @@
+package tiktokChat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"multibot/tenant-container/src/multiChat"
+)
+
+// the frames in testdata are built by the helpers below, go test -run TestDecode -update writes them again
+var update = flag.Bool("update", false, "rewrite the frames in testdata")
+
+// pb builds a protobuf message from field number and value pairs, a value is an int, bool, string or []byte
+func pb(fields ...any) []byte {
+	var b []byte
+	for i := 0; i < len(fields); i += 2 {
+		num := protowire.Number(fields[i].(int))
+		switch v := fields[i+1].(type) {
+		case int:
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(v))
+		case bool:
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+		case string:
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, v)
+		case []byte:
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, v)
+		}
+	}
+	return b
+}
+
+func common(msgID int) []byte { return pb(2, msgID) }
+
+func user(id int, nickname, uniqueID string) []byte { return pb(1, id, 3, nickname, 38, uniqueID) }
+
+// msgFrame wraps webcast messages, given as method and payload pairs, in a "msg" frame
+func msgFrame(encoding string, messages ...any) []byte {
+	var fields []any
+	for i := 0; i < len(messages); i += 2 {
+		fields = append(fields, 1, pb(1, messages[i].(string), 2, messages[i+1].([]byte), 3, i+1))
+	}
+	fields = append(fields, 2, "cursor", 5, "ext", 9, true)
+	payload := pb(fields...)
+	if encoding == "gzip" {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(payload)
+		w.Close()
+		payload = buf.Bytes()
+	}
+	return pushFrame{SeqID: 1, LogID: 42, PayloadEncoding: encoding, PayloadType: "msg", Payload: payload}.encode()
+}
+
+func testFrames() map[string][]byte {
+	alice := user(1, "Alice ✨", "alice")
+	host := user(2, "The Host", "thehost")
+	rose := pb(1, pb(1, "https://example.com/rose.png"), 11, TIKTOK_GIFT_STREAKABLE, 12, 1, 16, "Rose")
+	return map[string][]byte{
+		"chat.bin": msgFrame("pb",
+			"WebcastChatMessage", pb(1, common(101), 2, alice, 3, " hello chat "),
+			"WebcastChatMessage", pb(1, common(102), 2, host, 3, "welcome!"),
+			"WebcastChatMessage", pb(1, common(103), 2, alice, 3, "   "),
+			"WebcastRoomUserSeqMessage", pb(1, common(104), 3, 1234)),
+		"gift.bin": msgFrame("gzip",
+			"WebcastGiftMessage", pb(1, common(201), 5, 2, 7, alice, 9, false, 15, rose),
+			"WebcastGiftMessage", pb(1, common(202), 5, 5, 7, alice, 9, true, 15, rose)),
+		"like.bin": msgFrame("pb",
+			"WebcastLikeMessage", pb(1, common(301), 2, 15, 5, alice),
+			"WebcastLikeMessage", pb(1, common(302), 2, 0, 5, alice)),
+		"follow.bin": msgFrame("pb",
+			"WebcastSocialMessage", pb(1, common(401), 2, alice, 4, 3),
+			"WebcastSocialMessage", pb(1, common(402), 2, alice, 4, TIKTOK_SOCIAL_FOLLOW)),
+	}
+}
+
+func readFrame(t *testing.T, name string, frame []byte) []byte {
+	path := filepath.Join("testdata", name)
+	if *update {
+		os.MkdirAll("testdata", 0o755)
+		if err := os.WriteFile(path, frame, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestDecode(t *testing.T) {
+	frames := testFrames()
+	tests := []struct {
+		file string
+		want []multiChat.ChatMessage
+	}{
+		{"chat.bin", []multiChat.ChatMessage{
+			{PlatformID: "101", UserID: "1", Source: "tiktok", Username: "alice", Text: "hello chat"},
+			{PlatformID: "102", UserID: "2", Source: "tiktok", Username: "thehost", Text: "welcome!", Role: multiChat.ROLE_BROADCASTER},
+		}},
+		{"gift.bin", []multiChat.ChatMessage{
+			{PlatformID: "202", UserID: "1", Source: "tiktok", Username: "alice", Event: &multiChat.Event{
+				Kind: multiChat.EVENT_TIKTOK_GIFT, User: "Alice ✨", Count: 5, Image: "https://example.com/rose.png",
+				Amount: "5💎", Currency: "💎", SystemMessage: "Alice ✨ sent Rose x5"}},
+		}},
+		{"like.bin", []multiChat.ChatMessage{
+			{PlatformID: "301", UserID: "1", Source: "tiktok", Username: "alice", Event: &multiChat.Event{
+				Kind: multiChat.EVENT_TIKTOK_LIKE, User: "Alice ✨", Count: 15, SystemMessage: "Alice ✨ liked the stream x15"}},
+		}},
+		{"follow.bin", []multiChat.ChatMessage{
+			{PlatformID: "402", UserID: "1", Source: "tiktok", Username: "alice", Event: &multiChat.Event{
+				Kind: multiChat.EVENT_FOLLOW, User: "Alice ✨", SystemMessage: "Alice ✨ followed"}},
+		}},
+	}
+	for _, test := range tests {
+		msgs, err := Decode(readFrame(t, test.file, frames[test.file]), "thehost")
+		if err != nil {
+			t.Errorf("%s: %v", test.file, err)
+			continue
+		}
+		if len(msgs) != len(test.want) {
+			t.Errorf("%s: got %d messages, want %d: %+v", test.file, len(msgs), len(test.want), msgs)
+			continue
+		}
+		for i, want := range test.want {
+			got := msgs[i]
+			if got.PlatformID != want.PlatformID || got.UserID != want.UserID || got.Source != want.Source ||
+				got.Username != want.Username || got.Text != want.Text || got.Role != want.Role {
+				t.Errorf("%s message %d = %+v, want %+v", test.file, i, got, want)
+			}
+			if (got.Event == nil) != (want.Event == nil) || (got.Event != nil && *got.Event != *want.Event) {
+				t.Errorf("%s message %d event = %+v, want %+v", test.file, i, got.Event, want.Event)
+			}
+		}
+	}
+}
+
+func TestDecodeHeartbeat(t *testing.T) {
+	msgs, err := Decode(heartbeatFrame(7), "thehost")
+	if err != nil || len(msgs) != 0 {
+		t.Errorf("heartbeat decoded to %+v, %v", msgs, err)
+	}
+}
+
+func TestDecodeGzipLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(strings.Repeat("x", TIKTOK_MAX_PAYLOAD_BYTES+1)))
+	w.Close()
+	frame := pushFrame{PayloadEncoding: "gzip", PayloadType: "msg", Payload: buf.Bytes()}.encode()
+	if _, err := Decode(frame, "thehost"); err == nil || !strings.Contains(err.Error(), "over") {
+		t.Errorf("an oversized gzip payload returned %v, want an error", err)
+	}
+}
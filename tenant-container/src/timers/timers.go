@@ -0,0 +1,135 @@
+package timers
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/props"
+)
+
+const (
+	TIMER_CHECK_INTERVAL = 30 * time.Second //how often to check if a timer is due
+)
+
+// Timer posts Message every IntervalMins, as long as at least MinLines chat messages came in since its last post
+type Timer struct {
+	Message      string   `json:"message"`
+	IntervalMins int      `json:"interval_mins"`
+	MinLines     int      `json:"min_lines"`
+	To           []string `json:"to"` // empty = every platform
+	Enabled      bool     `json:"enabled"`
+}
+
+type timerState struct {
+	lastPost        time.Time
+	linesAtLastPost int64
+}
+
+var (
+	chatLines int64 // chat messages seen since startup, not counting the bot
+
+	// state of each timer, keyed by its position and message so edits reset it
+	states     = make(map[string]*timerState)
+	statesLock sync.Mutex
+)
+
+// HandleChat is a multiChat chat listener that counts chat activity
+func HandleChat(msg multiChat.ChatMessage) {
+	if chatSource.IsFromBot(msg) {
+		return
+	}
+	statesLock.Lock()
+	chatLines++
+	statesLock.Unlock()
+}
+
+func GetTimers() []Timer {
+	var timers []Timer
+	if err := props.GetChannelPropJSON(nil, "timers", &timers); err != nil {
+		log.Println("[timers] error reading timers:", err)
+		return nil
+	}
+	return timers
+}
+
+// Run checks the timers in the background, they are paused while the bot is disabled
+func Run() {
+	for {
+		time.Sleep(TIMER_CHECK_INTERVAL)
+		if enabled, _ := props.GetChannelProp(nil, "enabled").(bool); !enabled {
+			continue
+		}
+		timers := GetTimers()
+		for i, t := range timers {
+			if due(i, t) {
+				post(t)
+			}
+		}
+		forgetOldTimers(timers)
+	}
+}
+
+// due returns true and records the post if the timer should post now
+func due(i int, t Timer) bool {
+	if !t.Enabled || t.Message == "" || t.IntervalMins <= 0 {
+		return false
+	}
+	now := time.Now()
+	key := stateKey(i, t)
+	statesLock.Lock()
+	defer statesLock.Unlock()
+	state, ok := states[key]
+	if !ok {
+		// new (or edited) timer, wait a full interval before the first post
+		states[key] = &timerState{lastPost: now, linesAtLastPost: chatLines}
+		return false
+	}
+	if now.Sub(state.lastPost) < time.Duration(t.IntervalMins)*time.Minute {
+		return false
+	}
+	if chatLines-state.linesAtLastPost < int64(t.MinLines) {
+		return false
+	}
+	state.lastPost = now
+	state.linesAtLastPost = chatLines
+	return true
+}
+
+func stateKey(i int, t Timer) string {
+	return fmt.Sprintf("%d/%s", i, t.Message)
+}
+
+// forgetOldTimers drops the state of timers that were edited, deleted or disabled
+func forgetOldTimers(timers []Timer) {
+	current := make(map[string]bool, len(timers))
+	for i, t := range timers {
+		if t.Enabled {
+			current[stateKey(i, t)] = true
+		}
+	}
+	statesLock.Lock()
+	defer statesLock.Unlock()
+	for key := range states {
+		if !current[key] {
+			delete(states, key)
+		}
+	}
+}
+
+func post(t Timer) {
+	if len(t.To) == 0 {
+		log.Println("[timers] posting to all platforms:", t.Message)
+		chatSource.SayAll(t.Message)
+		return
+	}
+	for _, dest := range t.To {
+		log.Printf("[timers] posting to %s: %s", dest, t.Message)
+		if err := chatSource.Say(dest, t.Message); err != nil {
+			log.Printf("[timers] error posting to %s: %v", dest, err)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package twitchApi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	EVENTSUB_WS_URL            = "wss://eventsub.wss.twitch.tv/ws"
+	EVENTSUB_SUBSCRIPTIONS_URL = "https://api.twitch.tv/helix/eventsub/subscriptions"
+	EVENTSUB_KEEPALIVE_SLACK   = 10 * time.Second //extra time on top of keepalive_timeout_seconds before the connection counts as dead
+	EVENTSUB_DEDUPE_SIZE       = 1000             //how many message IDs to remember to skip resent messages
+
+	// twitch scopes the broadcaster has to grant for the topics in DefaultEventSubTopics
+	EVENTSUB_SCOPES = "moderator:read:followers bits:read channel:read:redemptions"
+)
+
+// EventSubTopic is a subscription type, see https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/
+type EventSubTopic struct {
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+}
+
+// DefaultEventSubTopics are the follows, cheers, channel points and stream online/offline for a broadcaster
+func DefaultEventSubTopics(broadcasterID string) []EventSubTopic {
+	b := map[string]string{"broadcaster_user_id": broadcasterID}
+	return []EventSubTopic{
+		{"channel.follow", "2", map[string]string{"broadcaster_user_id": broadcasterID, "moderator_user_id": broadcasterID}},
+		{"channel.cheer", "1", b},
+		{"channel.channel_points_custom_reward_redemption.add", "1", b},
+		{"stream.online", "1", b},
+		{"stream.offline", "1", b},
+	}
+}
+
+// EventSubConfig is what RunEventSub needs. The URLs default to twitch's, point them at
+// `twitch event websocket start-server` (ws://127.0.0.1:8080/ws and http://127.0.0.1:8080/eventsub/subscriptions) to test.
+type EventSubConfig struct {
+	WsURL            string
+	SubscriptionsURL string
+	ClientID         string
+	Token            string // the broadcaster's user access token
+	Topics           []EventSubTopic
+	OnConnect        func(err error) // called once the subscriptions are made, err says which ones failed if some did
+}
+
+// EventSubNotification is one event, Event is the JSON for the subscription type
+type EventSubNotification struct {
+	Type  string          `json:"type"`
+	Event json.RawMessage `json:"event"`
+}
+
+type eventSubMessage struct {
+	Metadata struct {
+		MessageID   string `json:"message_id"`
+		MessageType string `json:"message_type"`
+	} `json:"metadata"`
+	Payload struct {
+		Session struct {
+			ID                      string `json:"id"`
+			KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+			ReconnectURL            string `json:"reconnect_url"`
+		} `json:"session"`
+		Subscription struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"subscription"`
+		Event json.RawMessage `json:"event"`
+	} `json:"payload"`
+}
+
+// eventSubRead is a message or error from one of the connections, there are two during a reconnect
+type eventSubRead struct {
+	conn *websocket.Conn
+	msg  eventSubMessage
+	err  error
+}
+
+// RunEventSub connects to EventSub, subscribes to the topics and calls onNotification for each event.
+// It blocks until ctx is cancelled (returns nil) or the connection fails (returns the error).
+func RunEventSub(ctx context.Context, cfg EventSubConfig, onNotification func(EventSubNotification)) error {
+	if cfg.WsURL == "" {
+		cfg.WsURL = EVENTSUB_WS_URL
+	}
+	if cfg.SubscriptionsURL == "" {
+		cfg.SubscriptionsURL = EVENTSUB_SUBSCRIPTIONS_URL
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, cfg.WsURL, nil)
+	if err != nil {
+		return fmt.Errorf("eventsub dial: %w", err)
+	}
+	// close every connection still open when this returns
+	var connsLock sync.Mutex
+	conns := map[*websocket.Conn]bool{conn: true}
+	defer func() {
+		connsLock.Lock()
+		defer connsLock.Unlock()
+		for c := range conns {
+			c.Close()
+		}
+	}()
+	closeConn := func(c *websocket.Conn) {
+		connsLock.Lock()
+		defer connsLock.Unlock()
+		c.Close()
+		delete(conns, c)
+	}
+
+	var keepalive atomic.Int64
+	keepalive.Store(int64(10 * time.Second))
+	reads := make(chan eventSubRead)
+	done := make(chan struct{})
+	defer close(done)
+	read := func(c *websocket.Conn) {
+		for {
+			c.SetReadDeadline(time.Now().Add(time.Duration(keepalive.Load()) + EVENTSUB_KEEPALIVE_SLACK))
+			var msg eventSubMessage
+			err := c.ReadJSON(&msg)
+			select {
+			case reads <- eventSubRead{c, msg, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	go read(conn)
+
+	subscribed := false
+	var reconnecting *websocket.Conn // the new connection after a session_reconnect, until it sends its welcome
+	seen := make(map[string]bool)    // twitch can resend a message, skip the duplicates
+	for {
+		var r eventSubRead
+		select {
+		case <-ctx.Done():
+			return nil
+		case r = <-reads:
+		}
+		if r.conn != conn && r.conn != reconnecting {
+			continue // an old connection that was already replaced
+		}
+		if r.err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if r.conn == reconnecting {
+				// keep going on the old connection, twitch closes it if it really has to go
+				log.Println("[eventsub] reconnect failed:", r.err)
+				closeConn(reconnecting)
+				reconnecting = nil
+				continue
+			}
+			return fmt.Errorf("eventsub read: %w", r.err)
+		}
+		msg := r.msg
+		if msg.Metadata.MessageID != "" {
+			if seen[msg.Metadata.MessageID] {
+				continue
+			}
+			if len(seen) >= EVENTSUB_DEDUPE_SIZE {
+				seen = make(map[string]bool)
+			}
+			seen[msg.Metadata.MessageID] = true
+		}
+
+		switch msg.Metadata.MessageType {
+		case "session_welcome":
+			if msg.Payload.Session.KeepaliveTimeoutSeconds > 0 {
+				keepalive.Store(int64(time.Duration(msg.Payload.Session.KeepaliveTimeoutSeconds) * time.Second))
+			}
+			// the new connection is ready, twitch stops sending to the old one now
+			if r.conn == reconnecting {
+				log.Println("[eventsub] reconnected")
+				closeConn(conn)
+				conn, reconnecting = reconnecting, nil
+			}
+			// after a reconnect the subscriptions carry over to the new session
+			if subscribed {
+				continue
+			}
+			// don't count as connected with no subscriptions, nothing would ever arrive
+			err := subscribeAll(ctx, cfg, msg.Payload.Session.ID)
+			if errors.Is(err, errNoSubscriptions) {
+				return err
+			}
+			subscribed = true
+			if cfg.OnConnect != nil {
+				cfg.OnConnect(err)
+			}
+		case "session_keepalive":
+		case "notification":
+			onNotification(EventSubNotification{Type: msg.Payload.Subscription.Type, Event: msg.Payload.Event})
+		case "session_reconnect":
+			log.Println("[eventsub] twitch asked to reconnect to", msg.Payload.Session.ReconnectURL)
+			if reconnecting != nil {
+				closeConn(reconnecting)
+			}
+			newConn, _, err := websocket.DefaultDialer.DialContext(ctx, msg.Payload.Session.ReconnectURL, nil)
+			if err != nil {
+				log.Println("[eventsub] reconnect failed:", err)
+				continue
+			}
+			connsLock.Lock()
+			conns[newConn] = true
+			connsLock.Unlock()
+			// keep reading the old connection until the new one sends its welcome, so nothing in between is lost
+			reconnecting = newConn
+			go read(newConn)
+		case "revocation":
+			log.Printf("[eventsub] subscription to %s was revoked: %s", msg.Payload.Subscription.Type, msg.Payload.Subscription.Status)
+		default:
+			log.Println("[eventsub] unknown message type:", msg.Metadata.MessageType)
+		}
+	}
+}
+
+var errNoSubscriptions = errors.New("could not subscribe to any eventsub topic")
+
+// subscribeAll subscribes the session to every topic. It returns errNoSubscriptions if they all failed,
+// or an error listing the ones that failed.
+func subscribeAll(ctx context.Context, cfg EventSubConfig, sessionID string) error {
+	var errs []error
+	for _, topic := range cfg.Topics {
+		if err := subscribe(ctx, cfg, sessionID, topic); err != nil {
+			log.Printf("[eventsub] could not subscribe to %s: %v", topic.Type, err)
+			errs = append(errs, fmt.Errorf("%s: %w", topic.Type, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == len(cfg.Topics) {
+		return fmt.Errorf("%w: %w", errNoSubscriptions, errors.Join(errs...))
+	}
+	return errors.Join(errs...)
+}
+
+func subscribe(ctx context.Context, cfg EventSubConfig, sessionID string, topic EventSubTopic) error {
+	body, _ := json.Marshal(map[string]any{
+		"type":      topic.Type,
+		"version":   topic.Version,
+		"condition": topic.Condition,
+		"transport": map[string]string{"method": "websocket", "session_id": sessionID},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.SubscriptionsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Client-Id", cfg.ClientID)
+	req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 500))
+		return fmt.Errorf("status=%d body=%s", resp.StatusCode, b)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package twitchApi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	HELIX_URL          = "https://api.twitch.tv/helix"
+	HELIX_HTTP_TIMEOUT = 10 * time.Second
+	MAX_TIMEOUT_SECS   = 1209600 //twitch timeouts are at most 2 weeks
+
+	// twitch scopes the broadcaster has to grant for BanUser and DeleteChatMessage
+	MODERATION_SCOPES = "moderator:manage:banned_users moderator:manage:chat_messages"
+)
+
+var (
+	appToken       string
+	appTokenExpiry time.Time
+	appTokenLock   sync.Mutex
+)
+
+// helixRequest calls the helix API with a user access token and decodes the response into out
+func helixRequest(ctx context.Context, clientID, token, method, path string, query url.Values, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, HELIX_URL+path+"?"+query.Encode(), reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Client-ID", clientID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: HELIX_HTTP_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("helix %s status %d: %s", path, resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// GetUserID looks up a user's ID by login, or the token's own user if login is ""
+func GetUserID(ctx context.Context, clientID, token, login string) (string, error) {
+	query := url.Values{}
+	if login != "" {
+		query.Set("login", login)
+	}
+	var data helixUsersResponse
+	if err := helixRequest(ctx, clientID, token, http.MethodGet, "/users", query, nil, &data); err != nil {
+		return "", err
+	}
+	if len(data.Data) == 0 {
+		return "", fmt.Errorf("no twitch user %s", login)
+	}
+	return data.Data[0].ID, nil
+}
+
+// GetUserCreatedAt returns when a twitch account was made, with a cached app access token
+func GetUserCreatedAt(ctx context.Context, clientID, clientSecret, userID string) (time.Time, error) {
+	token, err := appAccessToken(clientID, clientSecret)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var data struct {
+		Data []struct {
+			CreatedAt time.Time `json:"created_at"`
+		} `json:"data"`
+	}
+	if err := helixRequest(ctx, clientID, token, http.MethodGet, "/users", url.Values{"id": {userID}}, nil, &data); err != nil {
+		return time.Time{}, err
+	}
+	if len(data.Data) == 0 {
+		return time.Time{}, fmt.Errorf("no twitch user %s", userID)
+	}
+	return data.Data[0].CreatedAt, nil
+}
+
+// appAccessToken reuses the app token until shortly before it expires, for calls that happen for every chatter
+func appAccessToken(clientID, clientSecret string) (string, error) {
+	appTokenLock.Lock()
+	defer appTokenLock.Unlock()
+	if appToken != "" && time.Now().Before(appTokenExpiry) {
+		return appToken, nil
+	}
+	token, expiresIn, err := getTwitchAppAccessToken(clientID, clientSecret)
+	if err != nil {
+		return "", err
+	}
+	appToken = token
+	appTokenExpiry = time.Now().Add(time.Duration(expiresIn)*time.Second - time.Minute)
+	return appToken, nil
+}
+
+// BanUser bans userID from the broadcaster's chat, or times them out if duration isn't 0.
+// The token must be the broadcaster's (or a mod's, with moderatorID set to the mod) with MODERATION_SCOPES.
+func BanUser(ctx context.Context, clientID, token, broadcasterID, moderatorID, userID string, duration time.Duration, reason string) error {
+	data := map[string]any{"user_id": userID, "reason": reason}
+	if duration > 0 {
+		data["duration"] = min(max(int(duration.Seconds()), 1), MAX_TIMEOUT_SECS)
+	}
+	query := url.Values{"broadcaster_id": {broadcasterID}, "moderator_id": {moderatorID}}
+	return helixRequest(ctx, clientID, token, http.MethodPost, "/moderation/bans", query, map[string]any{"data": data}, nil)
+}
+
+// DeleteChatMessage deletes one message from the broadcaster's chat
+func DeleteChatMessage(ctx context.Context, clientID, token, broadcasterID, moderatorID, messageID string) error {
+	query := url.Values{"broadcaster_id": {broadcasterID}, "moderator_id": {moderatorID}, "message_id": {messageID}}
+	return helixRequest(ctx, clientID, token, http.MethodDelete, "/moderation/chat", query, nil, nil)
+}
@@ -0,0 +1,175 @@
+package twitchChat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"multibot/common/src/env"
+
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/platformAuth"
+	"multibot/tenant-container/src/twitchApi"
+)
+
+const (
+	EVENTSUB_RETRY_DELAY = 1 * time.Minute //time to wait before reconnecting to eventsub, or checking again if the streamer logged in
+)
+
+var (
+	eventSubCancel    context.CancelFunc
+	eventSubConnected bool
+	eventSubError     string
+	eventSubLock      sync.Mutex
+)
+
+// startEventSub keeps an eventsub connection for follows, cheers, channel points and stream online/offline
+// until stopEventSub. It needs the streamer to log in to twitch on the bot page.
+func startEventSub() {
+	stopEventSub()
+	ctx, cancel := context.WithCancel(context.Background())
+	eventSubLock.Lock()
+	eventSubCancel = cancel
+	eventSubLock.Unlock()
+	go func() {
+		for {
+			err := connectEventSub(ctx)
+			setEventSubStatus(false, err)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[eventsub] %v, retrying in %v", err, EVENTSUB_RETRY_DELAY)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(EVENTSUB_RETRY_DELAY):
+			}
+		}
+	}()
+}
+
+func stopEventSub() {
+	eventSubLock.Lock()
+	defer eventSubLock.Unlock()
+	if eventSubCancel != nil {
+		eventSubCancel()
+		eventSubCancel = nil
+	}
+}
+
+func setEventSubStatus(connected bool, err error) {
+	eventSubLock.Lock()
+	defer eventSubLock.Unlock()
+	eventSubConnected = connected
+	eventSubError = ""
+	if err != nil {
+		eventSubError = err.Error()
+	}
+}
+
+func eventSubStatus() map[string]any {
+	eventSubLock.Lock()
+	defer eventSubLock.Unlock()
+	return map[string]any{"connected": eventSubConnected, "error": eventSubError}
+}
+
+// connectEventSub runs one eventsub connection, it returns when the connection drops
+func connectEventSub(ctx context.Context) error {
+	// the twitch CLI mock server takes any token, so testing doesn't need a login
+	token := ""
+	tok, err := platformAuth.Token(ctx, "twitch")
+	if err == nil {
+		token = tok.AccessToken
+	} else if env.TWITCH_EVENTSUB_WS_URL == "" {
+		return err
+	}
+	// the mock doesn't check the broadcaster either, so it can be given one instead of looking it up with real credentials
+	broadcasterID := env.TWITCH_EVENTSUB_BROADCASTER_ID
+	if broadcasterID == "" {
+		id, err := twitchApi.GetTwitchChannelID(env.TWITCH_CHANNEL, env.TWITCH_CLIENT_ID, env.TWITCH_SECRET)
+		if err != nil {
+			return fmt.Errorf("could not get the channel's user ID: %w", err)
+		}
+		broadcasterID = strconv.Itoa(id)
+	}
+	return twitchApi.RunEventSub(ctx, twitchApi.EventSubConfig{
+		WsURL:            env.TWITCH_EVENTSUB_WS_URL,
+		SubscriptionsURL: env.TWITCH_EVENTSUB_SUBSCRIPTIONS_URL,
+		ClientID:         env.TWITCH_CLIENT_ID,
+		Token:            token,
+		Topics:           twitchApi.DefaultEventSubTopics(broadcasterID),
+		OnConnect: func(err error) {
+			log.Println("[eventsub] connected!")
+			setEventSubStatus(true, err)
+		},
+	}, handleEventSub)
+}
+
+// the parts of the eventsub events we use, see https://dev.twitch.tv/docs/eventsub/eventsub-reference/
+type eventSubEvent struct {
+	UserID      string `json:"user_id"`
+	UserName    string `json:"user_name"`
+	IsAnonymous bool   `json:"is_anonymous"`
+	Message     string `json:"message"`    // cheer
+	Bits        int    `json:"bits"`       // cheer
+	UserInput   string `json:"user_input"` // redemption
+	Reward      struct {
+		Title string `json:"title"`
+		Cost  int    `json:"cost"`
+	} `json:"reward"`
+	BroadcasterUserName string `json:"broadcaster_user_name"`
+}
+
+// handleEventSub sends an eventsub notification to the multichat
+func handleEventSub(n twitchApi.EventSubNotification) {
+	if msg, ok := eventSubMessage(n); ok {
+		multiChat.SendEvent(msg)
+	}
+}
+
+// eventSubMessage turns an eventsub notification into a multiChat event
+func eventSubMessage(n twitchApi.EventSubNotification) (multiChat.ChatMessage, bool) {
+	var e eventSubEvent
+	if err := json.Unmarshal(n.Event, &e); err != nil {
+		log.Printf("[eventsub] bad %s event: %v", n.Type, err)
+		return multiChat.ChatMessage{}, false
+	}
+	username := e.UserName
+	if e.IsAnonymous || username == "" {
+		username = "anonymous"
+	}
+	msg := multiChat.ChatMessage{
+		UserID:   e.UserID,
+		Source:   "twitch",
+		Username: username,
+	}
+	switch n.Type {
+	case "channel.follow":
+		msg.Event = &multiChat.Event{Kind: multiChat.EVENT_FOLLOW, User: username,
+			SystemMessage: username + " followed"}
+	case "channel.cheer":
+		msg.Text = e.Message
+		msg.Event = &multiChat.Event{Kind: multiChat.EVENT_CHEER, User: username, Count: e.Bits,
+			SystemMessage: fmt.Sprintf("%s cheered %d bits", username, e.Bits)}
+	case "channel.channel_points_custom_reward_redemption.add":
+		msg.Text = e.UserInput
+		msg.Event = &multiChat.Event{Kind: multiChat.EVENT_REDEMPTION, User: username, Count: e.Reward.Cost, Reward: e.Reward.Title,
+			SystemMessage: fmt.Sprintf("%s redeemed %s", username, e.Reward.Title)}
+	case "stream.online":
+		msg.UserID, msg.Username = "", e.BroadcasterUserName
+		msg.Event = &multiChat.Event{Kind: multiChat.EVENT_STREAM_ONLINE, User: e.BroadcasterUserName,
+			SystemMessage: e.BroadcasterUserName + " went live"}
+	case "stream.offline":
+		msg.UserID, msg.Username = "", e.BroadcasterUserName
+		msg.Event = &multiChat.Event{Kind: multiChat.EVENT_STREAM_OFFLINE, User: e.BroadcasterUserName,
+			SystemMessage: e.BroadcasterUserName + " ended the stream"}
+	default:
+		log.Println("[eventsub] unhandled notification:", n.Type)
+		return msg, false
+	}
+	return msg, true
+}
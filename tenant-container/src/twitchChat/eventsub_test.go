@@ -0,0 +1,204 @@
+package twitchChat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/twitchApi"
+)
+
+// fakeEventSub is a stand-in for the eventsub websocket and subscriptions API, like the twitch CLI mock server
+type fakeEventSub struct {
+	*httptest.Server
+	subscribeStatus int
+	subscriptions   atomic.Int32
+	reconnected     chan struct{} // the client dialed the reconnect URL
+	welcomeNew      chan struct{} // the old connection sent its last message, the new one can send its welcome
+	oldClosed       chan struct{} // the client closed the old connection
+}
+
+var upgrader = websocket.Upgrader{}
+
+func newFakeEventSub(t *testing.T, subscribeStatus int) *fakeEventSub {
+	f := &fakeEventSub{
+		subscribeStatus: subscribeStatus,
+		reconnected:     make(chan struct{}),
+		welcomeNew:      make(chan struct{}),
+		oldClosed:       make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/eventsub/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("subscribe without the token: %q", r.Header.Get("Authorization"))
+		}
+		f.subscriptions.Add(1)
+		w.WriteHeader(f.subscribeStatus)
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		send(t, c, "w1", "session_welcome", `{"session":{"id":"s1","keepalive_timeout_seconds":10}}`)
+		if f.subscribeStatus != http.StatusAccepted {
+			c.ReadMessage() // until the client gives up
+			return
+		}
+		waitFor(t, func() bool { return f.subscriptions.Load() == 5 })
+		send(t, c, "n1", "notification", `{"subscription":{"type":"channel.cheer"},"event":{"user_id":"1","user_name":"Alice","message":"Cheer100 hi","bits":100}}`)
+		ws2 := "ws" + strings.TrimPrefix(f.URL, "http") + "/ws2"
+		send(t, c, "r1", "session_reconnect", `{"session":{"id":"s1","reconnect_url":"`+ws2+`"}}`)
+		<-f.reconnected
+		// twitch can still send to the old connection until the new one has sent its welcome
+		send(t, c, "n2", "notification", `{"subscription":{"type":"channel.follow"},"event":{"user_id":"2","user_name":"Bob"}}`)
+		close(f.welcomeNew)
+		if _, _, err := c.ReadMessage(); err != nil {
+			close(f.oldClosed)
+		}
+	})
+	mux.HandleFunc("/ws2", func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		close(f.reconnected)
+		<-f.welcomeNew
+		send(t, c, "w2", "session_welcome", `{"session":{"id":"s1","keepalive_timeout_seconds":10}}`)
+		event := `{"subscription":{"type":"channel.channel_points_custom_reward_redemption.add"},"event":{"user_id":"3","user_name":"Carol","user_input":"play a song","reward":{"title":"Song","cost":500}}}`
+		send(t, c, "n3", "notification", event)
+		send(t, c, "n3", "notification", event) // resent, should be skipped
+		c.ReadMessage()
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func send(t *testing.T, c *websocket.Conn, id, messageType, payload string) {
+	msg := `{"metadata":{"message_id":"` + id + `","message_type":"` + messageType + `"},"payload":` + payload + `}`
+	if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Errorf("send %s: %v", id, err)
+	}
+}
+
+func waitFor(t *testing.T, ok func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Error("timed out")
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (f *fakeEventSub) config(onConnect func(error)) twitchApi.EventSubConfig {
+	return twitchApi.EventSubConfig{
+		WsURL:            "ws" + strings.TrimPrefix(f.URL, "http") + "/ws",
+		SubscriptionsURL: f.URL + "/eventsub/subscriptions",
+		ClientID:         "client",
+		Token:            "token",
+		Topics:           twitchApi.DefaultEventSubTopics("1234"),
+		OnConnect:        onConnect,
+	}
+}
+
+func TestEventSubReconnect(t *testing.T) {
+	f := newFakeEventSub(t, http.StatusAccepted)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var msgs []multiChat.ChatMessage
+	connects := 0
+	cfg := f.config(func(err error) {
+		if err != nil {
+			t.Errorf("OnConnect error: %v", err)
+		}
+		lock.Lock()
+		connects++
+		lock.Unlock()
+	})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- twitchApi.RunEventSub(ctx, cfg, func(n twitchApi.EventSubNotification) {
+			msg, ok := eventSubMessage(n)
+			if !ok {
+				t.Errorf("no message for %s", n.Type)
+				return
+			}
+			lock.Lock()
+			msgs = append(msgs, msg)
+			lock.Unlock()
+		})
+	}()
+
+	waitFor(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(msgs) >= 3
+	})
+	select {
+	case <-f.oldClosed:
+	case <-time.After(5 * time.Second):
+		t.Error("the old connection was not closed after the new one's welcome")
+	}
+	time.Sleep(100 * time.Millisecond) // let a resent message show up if it wasn't skipped
+	cancel()
+	if err := <-errc; err != nil {
+		t.Errorf("RunEventSub returned %v after cancel", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if connects != 1 {
+		t.Errorf("OnConnect was called %d times, want 1", connects)
+	}
+	if n := f.subscriptions.Load(); n != 5 {
+		t.Errorf("made %d subscriptions, want 5 (none again after the reconnect)", n)
+	}
+	want := []struct {
+		kind, user, text, system string
+		count                    int
+	}{
+		{multiChat.EVENT_CHEER, "Alice", "Cheer100 hi", "Alice cheered 100 bits", 100},
+		{multiChat.EVENT_FOLLOW, "Bob", "", "Bob followed", 0},
+		{multiChat.EVENT_REDEMPTION, "Carol", "play a song", "Carol redeemed Song", 500},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("got %d messages, want %d: %+v", len(msgs), len(want), msgs)
+	}
+	for i, w := range want {
+		m := msgs[i]
+		if m.Source != "twitch" || m.Event == nil || m.Event.Kind != w.kind || m.Event.User != w.user ||
+			m.Text != w.text || m.Event.SystemMessage != w.system || m.Event.Count != w.count {
+			t.Errorf("message %d = %+v %+v, want %+v", i, m, m.Event, w)
+		}
+	}
+}
+
+func TestEventSubNoSubscriptions(t *testing.T) {
+	f := newFakeEventSub(t, http.StatusForbidden)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cfg := f.config(func(err error) {
+		t.Errorf("OnConnect called with every subscription failing: %v", err)
+	})
+	err := twitchApi.RunEventSub(ctx, cfg, func(twitchApi.EventSubNotification) {})
+	if err == nil || !strings.Contains(err.Error(), "could not subscribe") {
+		t.Errorf("RunEventSub returned %v, want a subscribe error", err)
+	}
+}
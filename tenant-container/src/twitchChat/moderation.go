@@ -0,0 +1,59 @@
+package twitchChat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"multibot/common/src/env"
+
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/platformAuth"
+	"multibot/tenant-container/src/twitchApi"
+)
+
+// moderation goes thru helix with the streamer's login, since the bot account isn't necessarily a mod
+func moderationAuth(ctx context.Context) (token, broadcasterID string, err error) {
+	tok, err := platformAuth.Token(ctx, "twitch")
+	if err != nil {
+		return "", "", err
+	}
+	broadcasterID, err = twitchApi.GetUserID(ctx, env.TWITCH_CLIENT_ID, tok.AccessToken, "")
+	if err != nil {
+		return "", "", fmt.Errorf("could not get the channel's user ID: %w", err)
+	}
+	return tok.AccessToken, broadcasterID, nil
+}
+
+func (source) DeleteMessage(msg multiChat.ChatMessage) error {
+	if msg.PlatformID == "" {
+		return fmt.Errorf("no twitch message ID")
+	}
+	ctx := context.Background()
+	token, broadcasterID, err := moderationAuth(ctx)
+	if err != nil {
+		return err
+	}
+	return twitchApi.DeleteChatMessage(ctx, env.TWITCH_CLIENT_ID, token, broadcasterID, broadcasterID, msg.PlatformID)
+}
+
+func (source) Ban(msg multiChat.ChatMessage, duration time.Duration, reason string) error {
+	ctx := context.Background()
+	token, broadcasterID, err := moderationAuth(ctx)
+	if err != nil {
+		return err
+	}
+	userID := msg.UserID
+	if userID == "" {
+		// linked accounts are only known by name
+		if userID, err = twitchApi.GetUserID(ctx, env.TWITCH_CLIENT_ID, token, msg.Username); err != nil {
+			return err
+		}
+	}
+	return twitchApi.BanUser(ctx, env.TWITCH_CLIENT_ID, token, broadcasterID, broadcasterID, userID, duration, reason)
+}
+
+// AccountCreatedAt looks up when a chatter's account was made, for automod's new account rule
+func AccountCreatedAt(ctx context.Context, userID, username string) (time.Time, error) {
+	return twitchApi.GetUserCreatedAt(ctx, env.TWITCH_CLIENT_ID, env.TWITCH_SECRET, userID)
+}
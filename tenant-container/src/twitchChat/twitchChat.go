@@ -3,110 +3,45 @@ package twitchChat
 import (
 	"fmt"
 	"log"
-	"math/rand"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	goaway "github.com/TwiN/go-away"
 	"github.com/gempir/go-twitch-irc/v4"
 
 	"multibot/common/src/env"
 
+	"multibot/tenant-container/src/chatSource"
 	"multibot/tenant-container/src/multiChat"
 	"multibot/tenant-container/src/props"
 )
 
 const (
-	TWITCH_MESSAGE_DELAY     = 500 * time.Millisecond //time to wait between twitch chats for both to go thru
-	GREETZ_DELAY_FOR_COMMAND = 2 * time.Second        //wait to greet when the user ran a command
+	TWITCH_MESSAGE_DELAY = 500 * time.Millisecond //time to wait between twitch chats for both to go thru
 )
 
 var (
 	twitchClient    *twitch.Client
 	twitchConnected bool
-
-	GREETZ = []string{
-		"yo #",
-		"yo #",
-		"yo yo #",
-		"yo yo yo #",
-		"yo yo yo # whats up!",
-		"heyo #",
-		"yooo # good to see u",
-		"good to see u #",
-		"hi #",
-		"hello #",
-		"helo #",
-		"whats up #",
-		"hey #, whats up?",
-		"welcome #",
-		"welcome in, #",
-		"greetings #",
-		"hows it going #",
-		"hey whats new with you #",
-		"how have you been #",
-		"#!",
-	}
-	GREETZ_ALSO = []string{
-		"also hi #",
-		"also hi # whats up!",
-		"also its good to see u #",
-		"also whats up #",
-		"also, whats up #?",
-		"also welcome #",
-		"also welcome in, #",
-		"also welcome to chat, #",
-		"also welcome to the stream, #",
-		"also hows it going #",
-		"also how have you been #",
-	}
-	GREETZ_WELCOME_BACK = []string{
-		"welcome back #",
-		"welcome back in, #",
-		"welcome back to chat, #",
-		"good to see u again #",
-		"hello again #",
-		"hi again #",
-	}
-	GREETZ_WELCOME_BACK_ALSO = []string{
-		"also welcome back #",
-		"also welcome back in, #",
-		"also welcome back to chat, #",
-		"also good to see u again #",
-		"also hello again #",
-		"also hi again #",
-	}
-
-	// Greet tracking: lastSeens stores last time a user talked (in ms).
-	lastSeens     = make(map[string]int64)
-	lastSeensLock sync.Mutex
 )
 
 // -----------------------------------------------------------------------------
 //  Twitch bridging
 // -----------------------------------------------------------------------------
 
-func ConnectToTwitchLoop() {
-	for {
-		ConnectToTwitch()
-		time.Sleep(1 * time.Minute)
-	}
-}
+// Source is the twitch ChatSource, registered with the supervisor in main
+var Source chatSource.ChatSource = source{}
 
-func ConnectToTwitch() {
-	if !props.GetChannelProp(nil, "enabled").(bool) {
-		log.Println("[twitch] bot is disabled, will not connect")
-		return
-	}
-	if twitchConnected {
-		log.Println("[twitch] already connected, will not connect")
-		return
-	}
+type source struct{}
+
+func (source) Name() string { return "twitch" }
+
+func (source) Start() error {
 	log.Printf("[twitch] connecting as %s to channel %s\n", env.TWITCH_BOT_USERNAME, env.TWITCH_CHANNEL)
-	twitchClient = twitch.NewClient(env.TWITCH_BOT_USERNAME, env.TWITCH_BOT_OAUTH_TOKEN)
+	c := twitch.NewClient(env.TWITCH_BOT_USERNAME, env.TWITCH_BOT_OAUTH_TOKEN)
+	twitchClient = c
 	// Callback for normal chat messages
-	twitchClient.OnPrivateMessage(func(msg twitch.PrivateMessage) {
+	c.OnPrivateMessage(func(msg twitch.PrivateMessage) {
 		// Ignore our own bot messages
 		if strings.EqualFold(msg.User.Name, env.TWITCH_BOT_USERNAME) {
 			return
@@ -147,51 +82,78 @@ func ConnectToTwitch() {
 		//     emoteMap[url] = append(emoteMap[url], positions...)
 		// }
 
+		replyTo := ""
+		if msg.Reply != nil {
+			replyTo = msg.Reply.ParentUserLogin
+		}
+
 		// Now send the chat with combined emotes
-		multiChat.SendChat("twitch", username, nickname, color, msg.Message, emoteMap)
+		multiChat.SendChatMessage(multiChat.ChatMessage{
+			PlatformID: msg.ID,
+			UserID:     msg.User.ID,
+			Source:     "twitch",
+			Username:   username,
+			Nickname:   nickname,
+			Color:      color,
+			Emotes:     emoteMap,
+			Text:       msg.Message,
+			Role:       twitchRole(msg.User.Badges),
+			ReplyTo:    replyTo,
+		})
+
+	})
+
+	// Subs, gift subs, raids, announcements etc.
+	c.OnUserNoticeMessage(func(msg twitch.UserNoticeMessage) {
+		if !strings.EqualFold(msg.Channel, env.TWITCH_CHANNEL) {
+			return
+		}
+		handleUserNotice(msg)
+	})
 
-		// Check commands & greet
-		validCommand, shouldReply := handleCommand(msg, username)
-		greetz(username, validCommand, shouldReply)
+	// A mod deleted a single message
+	c.OnClearMessage(func(msg twitch.ClearMessage) {
+		if !strings.EqualFold(msg.Channel, env.TWITCH_CHANNEL) {
+			return
+		}
+		multiChat.DeleteMessage("twitch", msg.TargetMsgID)
+	})
+	// A user was banned or timed out, or (with no target) the whole chat was cleared
+	c.OnClearChatMessage(func(msg twitch.ClearChatMessage) {
+		if !strings.EqualFold(msg.Channel, env.TWITCH_CHANNEL) {
+			return
+		}
+		if msg.TargetUserID == "" && msg.TargetUsername == "" {
+			log.Println("[twitch] chat was cleared on twitch, use !clear to clear the multichat")
+			return
+		}
+		multiChat.PurgeUser("twitch", msg.TargetUserID, msg.TargetUsername)
 	})
 
 	// Other handlers...
-	twitchClient.OnWhisperMessage(func(msg twitch.WhisperMessage) {
+	c.OnWhisperMessage(func(msg twitch.WhisperMessage) {
 		// ...
 	})
-	twitchClient.OnConnect(func() {
+	c.OnConnect(func() {
 		log.Println("[twitch] connected!")
-		twitchClient.Join(env.TWITCH_CHANNEL)
+		c.Join(env.TWITCH_CHANNEL)
 		twitchConnected = true
 	})
 
+	startEventSub()
+
 	go func() {
-		if err := twitchClient.Connect(); err != nil {
+		if err := c.Connect(); err != nil {
 			log.Println("[twitch] connect error:", err)
 			twitchConnected = false
 		}
 	}()
+	return nil
 }
 
-func Say(message string) {
-	if twitchClient == nil || !twitchConnected {
-		return
-	}
-	twitchClient.Say(env.TWITCH_CHANNEL, message)
-
-	nickname, _ := props.GetViewerProp(nil, env.TWITCH_BOT_USERNAME, "nickname").(string)
-	multiChat.SendChat("twitch", env.TWITCH_BOT_USERNAME, nickname, "", message, nil)
-}
-
-func SayLater(message string) {
-	go func() {
-		time.Sleep(TWITCH_MESSAGE_DELAY)
-		Say(message)
-	}()
-}
-
-func DisconnectFromTwitch() {
-	if twitchClient != nil && twitchConnected {
+func (source) Stop() {
+	stopEventSub()
+	if twitchClient != nil {
 		log.Println("[twitch] disconnecting")
 		twitchClient.Disconnect()
 		twitchConnected = false
@@ -199,144 +161,132 @@ func DisconnectFromTwitch() {
 	}
 }
 
-func handleCommand(msg twitch.PrivateMessage, username string) (bool, bool) {
-	command := strings.ReplaceAll(msg.Message, " 󠀀", " ")
-	command = strings.TrimSpace(command)
-	validCommand := true
-	shouldReply := true
-
-	switch {
-	case command == "!help" || command == "!commands":
-		Say(`commands: !nick - set your nickname; !botpage - link to the page with nicknames and other info; !multichat - link to combined chat; !clear - clear the multichat`)
-	case command == "!botpage":
-		Say(fmt.Sprintf("see the nicknames and other bot info at %s/%s", env.BASE_URL, env.TWITCH_CHANNEL))
-	case command == "!multichat":
-		Say(fmt.Sprintf("see the multichat at %s/%s/chat (change font and show/hide options on !botpage)", env.BASE_URL, env.TWITCH_CHANNEL))
-	case command == "!clear":
-		// Only allow if user is mod, broadcaster, or super admin
-		if msg.User.IsMod || strings.EqualFold(msg.User.Name, env.TWITCH_CHANNEL) || strings.EqualFold(msg.User.Name, env.TWITCH_SUPER_ADMIN_USERNAME) {
-			multiChat.ClearChat()
-			shouldReply = false
-		} else {
-			Say(fmt.Sprintf("@%s you do not have permission to clear chat", username))
-		}
-	case command == "!nick":
-		curr := props.GetViewerProp(nil, username, "nickname")
-		if curr != nil {
-			props.SetViewerProp(nil, username, "nickname", nil)
-			Say(fmt.Sprintf("@%s removed nickname, sad to see you go", username))
-		} else {
-			Say(fmt.Sprintf("@%s please provide a nickname, e.g. !nick name", username))
-		}
-	case strings.HasPrefix(command, "!nick "):
-		parts := strings.SplitN(command, " ", 2)
-		if len(parts) < 2 {
-			Say(fmt.Sprintf("@%s please provide a nickname after !nick", username))
-			break
-		}
-		nickname := strings.TrimSpace(parts[1])
-		maxLen := props.GetChannelPropAs(nil, "max_nickname_length", 0)
-
-		if goaway.IsProfane(nickname) {
-			Say(fmt.Sprintf("@%s no profanity allowed in nickname, choose a different one", username))
-		} else if props.GetViewerProp(nil, username, "nickname") == nickname {
-			Say(fmt.Sprintf("@%s you already have that nickname", username))
-		} else if len(nickname) > maxLen {
-			Say(fmt.Sprintf("@%s nickname \"%s\" is too long, max length = %d", username, nickname, maxLen))
-		} else if isNicknameTaken(nickname) {
-			Say(fmt.Sprintf("@%s nickname \"%s\" is already taken, see !botpage for the list", username, nickname))
-		} else {
-			props.SetViewerProp(nil, username, "nickname", nickname)
-			Say(fmt.Sprintf("@%s set nickname to %s", username, nickname))
-		}
-	default:
-		validCommand = false
+func (source) Status() chatSource.Status {
+	return chatSource.Status{
+		Connected: twitchConnected,
+		Details: map[string]any{
+			"twitchClient": fmt.Sprintf("%#v", twitchClient),
+			"eventsub":     eventSubStatus(),
+		},
 	}
-	return validCommand, shouldReply
 }
 
-// isNicknameTaken checks if any other user is using the given nickname
-func isNicknameTaken(nick string) bool {
-	viewers := props.ListViewers(nil)
-	for _, v := range viewers {
-		val := props.GetViewerProp(nil, v, "nickname")
-		if valStr, ok := val.(string); ok && valStr == nick {
-			return true
-		}
+func (source) Say(text string) error {
+	if twitchClient == nil || !twitchConnected {
+		return fmt.Errorf("not connected")
 	}
-	return false
+	Say(text)
+	return nil
 }
 
-func greetz(username string, validCommand, shouldReply bool) {
-	if strings.EqualFold(username, env.TWITCH_BOT_USERNAME) {
-		return
+// handleUserNotice turns a USERNOTICE into a multiChat event
+func handleUserNotice(msg twitch.UserNoticeMessage) {
+	p := msg.MsgParams
+	username := msg.User.DisplayName
+	if username == "" {
+		username = msg.User.Name
 	}
-	// Only greet if the user has a nickname set (like in Node).
-	nick := props.GetViewerProp(nil, username, "nickname")
-	if nick == nil {
-		return
+	event := &multiChat.Event{
+		Kind:          msg.MsgID,
+		User:          username,
+		Tier:          subTier(p["msg-param-sub-plan"]),
+		Months:        atoi(p["msg-param-cumulative-months"]),
+		SystemMessage: msg.SystemMsg,
+	}
+	switch msg.MsgID {
+	case multiChat.EVENT_SUB_GIFT:
+		// a mystery gift is followed by one of these per sub, the mystery gift already said how many
+		if p["msg-param-community-gift-id"] != "" {
+			return
+		}
+		event.Recipient = p["msg-param-recipient-display-name"]
+		event.Months = atoi(p["msg-param-months"])
+		event.Count = 1
+	case multiChat.EVENT_MYSTERY_GIFT:
+		event.Count = atoi(p["msg-param-mass-gift-count"])
+	case "anongiftpaidupgrade", "primepaidupgrade":
+		event.Kind = multiChat.EVENT_GIFT_UPGRADE
+	case multiChat.EVENT_RAID:
+		event.Count = atoi(p["msg-param-viewerCount"])
+	case multiChat.EVENT_BITS_BADGE:
+		event.Count = atoi(p["msg-param-threshold"])
+	case multiChat.EVENT_VIEWER_STREAK:
+		event.Count = atoi(p["msg-param-value"])
+	}
+	if event.SystemMessage == "" && msg.MsgID == multiChat.EVENT_ANNOUNCEMENT {
+		event.SystemMessage = username + " made an announcement"
 	}
 
-	// Retrieve the last time we saw this user
-	lastSeensLock.Lock()
-	lastSeen, hasSeen := lastSeens[username]
-	nowMs := time.Now().UnixMilli()
-	lastSeensLock.Unlock()
-
-	greetzThreshold := props.GetChannelPropAs(nil, "greetz_threshold", props.DEFAULT_CHANNEL_PROPS["greetz_threshold"].(int64))
-	wbThreshold := props.GetChannelPropAs(nil, "greetz_wb_threshold", props.DEFAULT_CHANNEL_PROPS["greetz_wb_threshold"].(int64))
-
-	log.Println("[greetz]", lastSeen, "|", hasSeen, "|", nowMs, "|", greetzThreshold, "|", wbThreshold)
-
-	if !hasSeen || (nowMs-lastSeen > greetzThreshold) {
-		// They’ve been away a long time => use initial greet
-		if shouldReply && validCommand {
-			// If they typed a valid command, wait 2s, then greet with "also" variant
-			go func(u string) {
-				time.Sleep(GREETZ_DELAY_FOR_COMMAND)
-				Say(parseGreetz(GREETZ_ALSO, u))
-			}(username)
-		} else {
-			Say(parseGreetz(GREETZ, username))
+	emoteMap := make(map[string][]string)
+	for _, emote := range msg.Emotes {
+		if emote == nil {
+			continue
 		}
-	} else if !hasSeen || (nowMs-lastSeen > wbThreshold) {
-		// They’ve been away for a shorter threshold => welcome back
-		if shouldReply && validCommand {
-			go func(u string) {
-				time.Sleep(GREETZ_DELAY_FOR_COMMAND)
-				Say(parseGreetz(GREETZ_WELCOME_BACK_ALSO, u))
-			}(username)
-		} else {
-			Say(parseGreetz(GREETZ_WELCOME_BACK, username))
+		for _, pos := range emote.Positions {
+			emoteMap[emote.ID] = append(emoteMap[emote.ID], fmt.Sprintf("%d-%d", pos.Start, pos.End))
 		}
 	}
+	multiChat.SendEvent(multiChat.ChatMessage{
+		PlatformID: msg.ID,
+		UserID:     msg.User.ID,
+		Source:     "twitch",
+		Username:   username,
+		Color:      msg.User.Color,
+		Emotes:     emoteMap,
+		Text:       msg.Message,
+		Role:       twitchRole(msg.User.Badges),
+		Event:      event,
+	})
+}
 
-	// Update lastSeens
-	lastSeensLock.Lock()
-	lastSeens[username] = nowMs
-	lastSeensLock.Unlock()
+// subTier turns twitch's sub plan ("Prime", "1000", "2000", "3000") into a multiChat tier
+func subTier(plan string) string {
+	switch plan {
+	case "":
+		return ""
+	case "Prime":
+		return "prime"
+	default:
+		return strings.TrimSuffix(plan, "000")
+	}
 }
 
-func parseGreetz(stock []string, username string) string {
-	nickname, _ := props.GetViewerProp(nil, username, "nickname").(string)
-	custom, _ := props.GetViewerProp(nil, username, "custom_greetz").(string)
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
 
-	message := ""
-	if custom != "" {
-		message = custom
-	} else {
-		message = stock[rand.Intn(len(stock))]
+// twitchRole maps the user's badges to a multiChat role
+func twitchRole(badges map[string]int) string {
+	roles := []string{}
+	for badge := range badges {
+		switch badge {
+		case "broadcaster":
+			roles = append(roles, multiChat.ROLE_BROADCASTER)
+		case "moderator":
+			roles = append(roles, multiChat.ROLE_MOD)
+		case "vip":
+			roles = append(roles, multiChat.ROLE_VIP)
+		case "subscriber", "founder":
+			roles = append(roles, multiChat.ROLE_SUB)
+		}
 	}
-	// In Node code: message.replaceAll('@', '@'+username).replaceAll('#', nickname)
-	// Go doesn't have replaceAll in older versions, but we can just do strings.ReplaceAll
-	res := strings.ReplaceAll(message, "@", "@"+username)
-	res = strings.ReplaceAll(res, "#", nickname)
-	return res
+	return multiChat.HighestRole(roles...)
 }
 
-func GetStatus() map[string]interface{} {
-	return map[string]interface{}{
-		"connected":    twitchConnected,
-		"twitchClient": fmt.Sprintf("%#v", twitchClient),
+func Say(message string) {
+	if twitchClient == nil || !twitchConnected {
+		return
 	}
+	twitchClient.Say(env.TWITCH_CHANNEL, message)
+
+	nickname, _ := props.GetViewerProp(nil, env.TWITCH_BOT_USERNAME, "nickname").(string)
+	multiChat.SendChat("twitch", env.TWITCH_BOT_USERNAME, nickname, "", message, nil)
+}
+
+func SayLater(message string) {
+	go func() {
+		time.Sleep(TWITCH_MESSAGE_DELAY)
+		Say(message)
+	}()
 }
@@ -0,0 +1,214 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"multibot/common/src/env"
+	"multibot/common/src/redisClient"
+
+	"multibot/tenant-container/src/chatSource"
+	"multibot/tenant-container/src/multiChat"
+)
+
+const (
+	WEBHOOK_TOKEN_BYTES    = 24
+	WEBHOOK_RATE_LIMIT     = 10 //messages each webhook can post per WEBHOOK_RATE_WINDOW
+	WEBHOOK_RATE_WINDOW    = 10 * time.Second
+	WEBHOOK_MAX_TEXT_RUNES = 500
+	WEBHOOK_MAX_NAME_RUNES = 50
+	WEBHOOK_MAX_BODY_BYTES = 16 * 1024
+)
+
+var (
+	sourceNameRegex = regexp.MustCompile(`^[a-z0-9_-]{1,25}$`)
+	colorRegex      = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+	ErrRateLimited = errors.New("too many messages, slow down")
+
+	// when each webhook posted recently, for the rate limit
+	recentPosts = make(map[string][]time.Time)
+	rateLock    sync.Mutex
+)
+
+// Webhook lets an outside tool, e.g. a donation handler, post into the multichat as its own source
+type Webhook struct {
+	Source    string    `json:"source"` // what the messages show as their source, e.g. "kofi"
+	CreatedAt time.Time `json:"created_at"`
+	TokenHash string    `json:"token_hash,omitempty"` // sha256 of the token, the token itself is only shown once
+}
+
+// Message is the JSON body a webhook posts. With an Event it is shown as an event, e.g. a donation, otherwise as chat.
+type Message struct {
+	Username string           `json:"username"`
+	Text     string           `json:"text"`
+	Color    string           `json:"color"` // "#rrggbb", optional
+	Event    *multiChat.Event `json:"event"`
+}
+
+func webhooksKey() string {
+	return "channels/" + env.TWITCH_CHANNEL + "/webhooks"
+}
+
+// List returns the webhooks sorted by source, without their token hashes
+func List(ctx context.Context) []Webhook {
+	raw, err := redisClient.HGetAll(ctx, webhooksKey()).Result()
+	if err != nil {
+		log.Println("[webhooks] list error:", err)
+		return nil
+	}
+	hooks := make([]Webhook, 0, len(raw))
+	for source, val := range raw {
+		var h Webhook
+		if err := json.Unmarshal([]byte(val), &h); err != nil {
+			log.Printf("[webhooks] skipping bad webhook %s: %v", source, err)
+			continue
+		}
+		h.TokenHash = ""
+		hooks = append(hooks, h)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].Source < hooks[j].Source })
+	return hooks
+}
+
+// Create makes a webhook for source, or gives an existing one a new token, and returns the token
+func Create(ctx context.Context, source string) (string, error) {
+	source = strings.ToLower(strings.TrimSpace(source))
+	if !sourceNameRegex.MatchString(source) {
+		return "", fmt.Errorf("source must be 1-25 lowercase letters, numbers, _ or -")
+	}
+	// a webhook can't pretend to be one of the platforms
+	if slices.Contains(chatSource.Names(), source) {
+		return "", fmt.Errorf("%s is already a chat source", source)
+	}
+	b := make([]byte, WEBHOOK_TOKEN_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	raw, _ := json.Marshal(Webhook{Source: source, CreatedAt: time.Now(), TokenHash: hashToken(token)})
+	if err := redisClient.HSet(ctx, webhooksKey(), source, string(raw)).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func Delete(ctx context.Context, source string) {
+	redisClient.HDel(ctx, webhooksKey(), strings.ToLower(source))
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate checks the token a tool sent for source
+func Authenticate(ctx context.Context, source, token string) bool {
+	if token == "" {
+		return false
+	}
+	val, err := redisClient.HGet(ctx, webhooksKey(), strings.ToLower(source)).Result()
+	if err != nil {
+		return false
+	}
+	var h Webhook
+	if err := json.Unmarshal([]byte(val), &h); err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(h.TokenHash)) == 1
+}
+
+// allow returns false if source posted WEBHOOK_RATE_LIMIT messages in the last WEBHOOK_RATE_WINDOW, otherwise it records this one
+func allow(source string) bool {
+	now := time.Now()
+	rateLock.Lock()
+	defer rateLock.Unlock()
+	recent := slices.DeleteFunc(recentPosts[source], func(t time.Time) bool { return now.Sub(t) >= WEBHOOK_RATE_WINDOW })
+	if len(recent) >= WEBHOOK_RATE_LIMIT {
+		recentPosts[source] = recent
+		return false
+	}
+	recentPosts[source] = append(recent, now)
+	return true
+}
+
+// Post validates a message from an authenticated webhook and sends it to the multichat, so it gets
+// emotes and history like the platforms' messages
+func Post(source string, m Message) error {
+	source = strings.ToLower(source)
+	m.Username = strings.TrimSpace(m.Username)
+	m.Text = strings.TrimSpace(m.Text)
+	if m.Event != nil {
+		if err := checkEvent(m.Event); err != nil {
+			return err
+		}
+		if m.Username == "" {
+			m.Username = m.Event.User
+		}
+	}
+	switch {
+	case m.Event == nil && (m.Username == "" || m.Text == ""):
+		return fmt.Errorf("username and text are required")
+	case utf8.RuneCountInString(m.Username) > WEBHOOK_MAX_NAME_RUNES:
+		return fmt.Errorf("username is too long, max length = %d", WEBHOOK_MAX_NAME_RUNES)
+	case utf8.RuneCountInString(m.Text) > WEBHOOK_MAX_TEXT_RUNES:
+		return fmt.Errorf("text is too long, max length = %d", WEBHOOK_MAX_TEXT_RUNES)
+	case m.Color != "" && !colorRegex.MatchString(m.Color):
+		return fmt.Errorf("color must be #rrggbb")
+	}
+	if !allow(source) {
+		return ErrRateLimited
+	}
+
+	// roles aren't taken from the body, and chat from a webhook doesn't run commands at all
+	msg := multiChat.ChatMessage{
+		Source:   source,
+		Username: m.Username,
+		Color:    m.Color,
+		Text:     m.Text,
+		Event:    m.Event,
+		Webhook:  true,
+	}
+	if msg.Event != nil {
+		multiChat.SendEvent(msg)
+	} else {
+		multiChat.SendChatMessage(msg)
+	}
+	return nil
+}
+
+func checkEvent(e *multiChat.Event) error {
+	if e.Kind == "" {
+		e.Kind = multiChat.EVENT_DONATION
+	}
+	e.User = strings.TrimSpace(e.User)
+	e.SystemMessage = strings.TrimSpace(e.SystemMessage)
+	switch {
+	case !slices.Contains(multiChat.EVENT_KINDS, e.Kind):
+		return fmt.Errorf("unknown event kind %q", e.Kind)
+	case e.SystemMessage == "":
+		return fmt.Errorf("event.system_message is required")
+	case utf8.RuneCountInString(e.SystemMessage) > WEBHOOK_MAX_TEXT_RUNES:
+		return fmt.Errorf("event.system_message is too long, max length = %d", WEBHOOK_MAX_TEXT_RUNES)
+	case e.Color != "" && !colorRegex.MatchString(e.Color):
+		return fmt.Errorf("event.color must be #rrggbb")
+	case e.Image != "" && !strings.HasPrefix(e.Image, "https://"):
+		return fmt.Errorf("event.image must be an https URL")
+	}
+	return nil
+}
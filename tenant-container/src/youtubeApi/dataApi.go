@@ -0,0 +1,309 @@
+package youtubeApi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	DATA_API_URL = "https://www.googleapis.com/youtube/v3"
+	OAUTH_SCOPE  = "https://www.googleapis.com/auth/youtube.force-ssl" //lets the owner's token post in chat
+)
+
+// OAuthEndpoint is google's oauth endpoint, used with OAUTH_SCOPE
+var OAuthEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://accounts.google.com/o/oauth2/auth",
+	TokenURL: "https://oauth2.googleapis.com/token",
+}
+
+// Auth is how to call the data API, the owner's token if they logged in, otherwise an API key which can only read public data
+type Auth struct {
+	Token  *oauth2.Token
+	APIKey string
+}
+
+// dataAPIRequest calls the youtube data API and decodes the response into out
+func dataAPIRequest(ctx context.Context, auth Auth, method, path string, query url.Values, body any, out any) error {
+	if auth.Token == nil {
+		if auth.APIKey == "" {
+			return fmt.Errorf("youtube api needs a login or an API key")
+		}
+		query.Set("key", auth.APIKey)
+	}
+	var reqBody io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, DATA_API_URL+path+"?"+query.Encode(), reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth.Token != nil {
+		auth.Token.SetAuthHeader(req)
+	}
+	client := &http.Client{Timeout: LIVE_CHAT_HTTP_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		// the chat is gone once the stream ends
+		if strings.Contains(string(msg), "liveChatEnded") || strings.Contains(string(msg), "liveChatNotFound") {
+			return ErrLiveStreamOver
+		}
+		return fmt.Errorf("youtube api %s status %d: %s", path, resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// GetActiveLiveChatID returns the live chat ID of a video that is live right now
+func GetActiveLiveChatID(ctx context.Context, auth Auth, videoID string) (string, error) {
+	var data struct {
+		Items []struct {
+			LiveStreamingDetails struct {
+				ActiveLiveChatID string `json:"activeLiveChatId"`
+			} `json:"liveStreamingDetails"`
+		} `json:"items"`
+	}
+	query := url.Values{"part": {"liveStreamingDetails"}, "id": {videoID}}
+	if err := dataAPIRequest(ctx, auth, http.MethodGet, "/videos", query, nil, &data); err != nil {
+		return "", err
+	}
+	if len(data.Items) == 0 || data.Items[0].LiveStreamingDetails.ActiveLiveChatID == "" {
+		return "", ErrStreamNotLive
+	}
+	return data.Items[0].LiveStreamingDetails.ActiveLiveChatID, nil
+}
+
+// InsertLiveChatMessage posts a message into a live chat as the logged in owner
+func InsertLiveChatMessage(ctx context.Context, tok *oauth2.Token, liveChatID, text string) error {
+	body := map[string]any{
+		"snippet": map[string]any{
+			"liveChatId": liveChatID,
+			"type":       "textMessageEvent",
+			"textMessageDetails": map[string]any{
+				"messageText": text,
+			},
+		},
+	}
+	return dataAPIRequest(ctx, Auth{Token: tok}, http.MethodPost, "/liveChat/messages", url.Values{"part": {"snippet"}}, body, nil)
+}
+
+// DeleteLiveChatMessage deletes a message from a live chat, the token must be the owner's or a moderator's
+func DeleteLiveChatMessage(ctx context.Context, tok *oauth2.Token, messageID string) error {
+	return dataAPIRequest(ctx, Auth{Token: tok}, http.MethodDelete, "/liveChat/messages", url.Values{"id": {messageID}}, nil, nil)
+}
+
+// BanLiveChatUser bans a channel from a live chat, for duration or for good if duration is 0
+func BanLiveChatUser(ctx context.Context, tok *oauth2.Token, liveChatID, channelID string, duration time.Duration) error {
+	snippet := map[string]any{
+		"liveChatId":        liveChatID,
+		"type":              "permanent",
+		"bannedUserDetails": map[string]any{"channelId": channelID},
+	}
+	if duration > 0 {
+		snippet["type"] = "temporary"
+		snippet["banDurationSeconds"] = max(int(duration.Seconds()), 1)
+	}
+	return dataAPIRequest(ctx, Auth{Token: tok}, http.MethodPost, "/liveChat/bans", url.Values{"part": {"snippet"}}, map[string]any{"snippet": snippet}, nil)
+}
+
+// GetChannelCreatedAt returns when a youtube channel was made
+func GetChannelCreatedAt(ctx context.Context, auth Auth, channelID string) (time.Time, error) {
+	var data struct {
+		Items []struct {
+			Snippet struct {
+				PublishedAt time.Time `json:"publishedAt"`
+			} `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := dataAPIRequest(ctx, auth, http.MethodGet, "/channels", url.Values{"part": {"snippet"}, "id": {channelID}}, nil, &data); err != nil {
+		return time.Time{}, err
+	}
+	if len(data.Items) == 0 {
+		return time.Time{}, fmt.Errorf("no youtube channel %s", channelID)
+	}
+	return data.Items[0].Snippet.PublishedAt, nil
+}
+
+// LiveChat is a live video and the ID of its chat
+type LiveChat struct {
+	VideoID    string
+	LiveChatID string
+}
+
+// FindLiveChats finds the channel's live videos and their chats. With the owner's token that is liveBroadcasts.list,
+// otherwise (or if the token is for another channel) it checks the newest uploads, which is 2 quota units instead of search's 100.
+func FindLiveChats(ctx context.Context, auth Auth, channelID string) ([]LiveChat, error) {
+	chats := []LiveChat{}
+	if auth.Token != nil {
+		var data struct {
+			Items []struct {
+				ID      string `json:"id"`
+				Snippet struct {
+					ChannelID  string `json:"channelId"`
+					LiveChatID string `json:"liveChatId"`
+				} `json:"snippet"`
+			} `json:"items"`
+		}
+		query := url.Values{"part": {"snippet"}, "broadcastStatus": {"active"}, "broadcastType": {"all"}}
+		if err := dataAPIRequest(ctx, auth, http.MethodGet, "/liveBroadcasts", query, nil, &data); err != nil {
+			return nil, err
+		}
+		for _, item := range data.Items {
+			if item.Snippet.ChannelID == channelID && item.Snippet.LiveChatID != "" {
+				chats = append(chats, LiveChat{item.ID, item.Snippet.LiveChatID})
+			}
+		}
+		if len(chats) > 0 {
+			return chats, nil
+		}
+	}
+
+	// a live stream shows up in the channel's uploads playlist, which is the channel ID with UU instead of UC
+	if !strings.HasPrefix(channelID, "UC") {
+		return nil, fmt.Errorf("invalid channel ID %q", channelID)
+	}
+	var uploads struct {
+		Items []struct {
+			ContentDetails struct {
+				VideoID string `json:"videoId"`
+			} `json:"contentDetails"`
+		} `json:"items"`
+	}
+	query := url.Values{"part": {"contentDetails"}, "playlistId": {"UU" + channelID[2:]}, "maxResults": {"10"}}
+	if err := dataAPIRequest(ctx, auth, http.MethodGet, "/playlistItems", query, nil, &uploads); err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, item := range uploads.Items {
+		ids = append(ids, item.ContentDetails.VideoID)
+	}
+	if len(ids) == 0 {
+		return nil, ErrStreamNotLive
+	}
+	var videos struct {
+		Items []struct {
+			ID                   string `json:"id"`
+			LiveStreamingDetails struct {
+				ActiveLiveChatID string `json:"activeLiveChatId"`
+			} `json:"liveStreamingDetails"`
+		} `json:"items"`
+	}
+	query = url.Values{"part": {"liveStreamingDetails"}, "id": {strings.Join(ids, ",")}}
+	if err := dataAPIRequest(ctx, auth, http.MethodGet, "/videos", query, nil, &videos); err != nil {
+		return nil, err
+	}
+	for _, video := range videos.Items {
+		if video.LiveStreamingDetails.ActiveLiveChatID != "" {
+			chats = append(chats, LiveChat{video.ID, video.LiveStreamingDetails.ActiveLiveChatID})
+		}
+	}
+	if len(chats) == 0 {
+		return nil, ErrStreamNotLive
+	}
+	return chats, nil
+}
+
+// LiveChatMessage is one item from liveChatMessages.list, see https://developers.google.com/youtube/v3/live/docs/liveChatMessages
+type LiveChatMessage struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Type               string    `json:"type"`
+		PublishedAt        time.Time `json:"publishedAt"`
+		DisplayMessage     string    `json:"displayMessage"`
+		TextMessageDetails struct {
+			MessageText string `json:"messageText"`
+		} `json:"textMessageDetails"`
+		SuperChatDetails struct {
+			AmountDisplayString string `json:"amountDisplayString"`
+			UserComment         string `json:"userComment"`
+		} `json:"superChatDetails"`
+		SuperStickerDetails struct {
+			AmountDisplayString  string `json:"amountDisplayString"`
+			SuperStickerMetadata struct {
+				AltText string `json:"altText"`
+			} `json:"superStickerMetadata"`
+		} `json:"superStickerDetails"`
+		NewSponsorDetails struct {
+			MemberLevelName string `json:"memberLevelName"`
+		} `json:"newSponsorDetails"`
+		MemberMilestoneChatDetails struct {
+			MemberMonth     int    `json:"memberMonth"`
+			MemberLevelName string `json:"memberLevelName"`
+			UserComment     string `json:"userComment"`
+		} `json:"memberMilestoneChatDetails"`
+		MembershipGiftingDetails struct {
+			GiftMembershipsCount int `json:"giftMembershipsCount"`
+		} `json:"membershipGiftingDetails"`
+		MessageDeletedDetails struct {
+			DeletedMessageID string `json:"deletedMessageId"`
+		} `json:"messageDeletedDetails"`
+		UserBannedDetails struct {
+			BannedUserDetails struct {
+				ChannelID string `json:"channelId"`
+			} `json:"bannedUserDetails"`
+		} `json:"userBannedDetails"`
+	} `json:"snippet"`
+	AuthorDetails struct {
+		ChannelID       string `json:"channelId"`
+		DisplayName     string `json:"displayName"`
+		IsChatOwner     bool   `json:"isChatOwner"`
+		IsChatModerator bool   `json:"isChatModerator"`
+		IsChatSponsor   bool   `json:"isChatSponsor"`
+	} `json:"authorDetails"`
+}
+
+// Role is "owner", "moderator", "member" or "", the same as LiveChatAuthor.Role
+func (m *LiveChatMessage) Role() string {
+	switch {
+	case m.AuthorDetails.IsChatOwner:
+		return "owner"
+	case m.AuthorDetails.IsChatModerator:
+		return "moderator"
+	case m.AuthorDetails.IsChatSponsor:
+		return "member"
+	}
+	return ""
+}
+
+// ListLiveChatMessages gets the messages after pageToken ("" for the recent backlog). It returns the token for
+// the next call and how long youtube wants us to wait before it.
+func ListLiveChatMessages(ctx context.Context, auth Auth, liveChatID, pageToken string) ([]LiveChatMessage, string, time.Duration, error) {
+	var data struct {
+		NextPageToken         string            `json:"nextPageToken"`
+		PollingIntervalMillis int               `json:"pollingIntervalMillis"`
+		OfflineAt             string            `json:"offlineAt"`
+		Items                 []LiveChatMessage `json:"items"`
+	}
+	query := url.Values{"part": {"id,snippet,authorDetails"}, "liveChatId": {liveChatID}}
+	if pageToken != "" {
+		query.Set("pageToken", pageToken)
+	}
+	if err := dataAPIRequest(ctx, auth, http.MethodGet, "/liveChat/messages", query, nil, &data); err != nil {
+		return nil, "", 0, err
+	}
+	if data.OfflineAt != "" {
+		return data.Items, "", 0, ErrLiveStreamOver
+	}
+	return data.Items, data.NextPageToken, time.Duration(data.PollingIntervalMillis) * time.Millisecond, nil
+}
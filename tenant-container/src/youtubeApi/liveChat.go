@@ -0,0 +1,362 @@
+package youtubeApi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	LIVE_CHAT_URL          = "https://www.youtube.com/youtubei/v1/live_chat/get_live_chat?key=%s"
+	LIVE_CHAT_HTTP_TIMEOUT = 30 * time.Second
+)
+
+var (
+	ErrLiveStreamOver = errors.New("live stream over")
+	ErrStreamNotLive  = errors.New("stream not live")
+
+	ytCfgRegex         = regexp.MustCompile(`ytcfg\.set\s*\(\s*({.+?})\s*\)\s*;`)
+	ytInitialDataRegex = regexp.MustCompile(`(?:window\s*\[\s*["']ytInitialData["']\s*\]|ytInitialData)\s*=\s*({.+?})\s*;\s*(?:var\s+meta|</script|\n)`)
+)
+
+// InnertubeConfig is scraped from the live page and is needed to poll the live chat endpoint
+type InnertubeConfig struct {
+	APIKey  string          `json:"INNERTUBE_API_KEY"`
+	Context json.RawMessage `json:"INNERTUBE_CONTEXT"`
+}
+
+type liveChatInitialData struct {
+	Contents struct {
+		TwoColumnWatchNextResults struct {
+			ConversationBar struct {
+				LiveChatRenderer struct {
+					Header struct {
+						LiveChatHeaderRenderer struct {
+							ViewSelector struct {
+								SortFilterSubMenuRenderer struct {
+									SubMenuItems []struct {
+										Title        string `json:"title"`
+										Continuation struct {
+											ReloadContinuationData struct {
+												Continuation string `json:"continuation"`
+											} `json:"reloadContinuationData"`
+										} `json:"continuation"`
+									} `json:"subMenuItems"`
+								} `json:"sortFilterSubMenuRenderer"`
+							} `json:"viewSelector"`
+						} `json:"liveChatHeaderRenderer"`
+					} `json:"header"`
+				} `json:"liveChatRenderer"`
+			} `json:"conversationBar"`
+		} `json:"twoColumnWatchNextResults"`
+	} `json:"contents"`
+}
+
+// LiveChatRun is one piece of a message, either text or an emoji
+type LiveChatRun struct {
+	Text  string `json:"text,omitempty"`
+	Emoji *struct {
+		EmojiID       string   `json:"emojiId"`
+		Shortcuts     []string `json:"shortcuts"`
+		IsCustomEmoji bool     `json:"isCustomEmoji"`
+		Image         struct {
+			Thumbnails []struct {
+				URL string `json:"url"`
+			} `json:"thumbnails"`
+		} `json:"image"`
+	} `json:"emoji,omitempty"`
+}
+
+// LiveChatText is a field youtube sends either as plain text or as runs
+type LiveChatText struct {
+	SimpleText string        `json:"simpleText,omitempty"`
+	Runs       []LiveChatRun `json:"runs,omitempty"`
+}
+
+// String is the plain text, with emojis as their IDs
+func (t LiveChatText) String() string {
+	if t.SimpleText != "" {
+		return t.SimpleText
+	}
+	text, _ := RunsToText(t.Runs)
+	return text
+}
+
+type liveChatThumbnails struct {
+	Thumbnails []struct {
+		URL string `json:"url"`
+	} `json:"thumbnails"`
+}
+
+// LiveChatAuthor is the part every chat item has in common
+type LiveChatAuthor struct {
+	ID                      string       `json:"id"`
+	AuthorName              LiveChatText `json:"authorName"`
+	AuthorExternalChannelID string       `json:"authorExternalChannelId"`
+	TimestampUsec           string       `json:"timestampUsec"`
+	AuthorBadges            []struct {
+		LiveChatAuthorBadgeRenderer struct {
+			Icon *struct {
+				IconType string `json:"iconType"`
+			} `json:"icon"`
+			CustomThumbnail *liveChatThumbnails `json:"customThumbnail"`
+		} `json:"liveChatAuthorBadgeRenderer"`
+	} `json:"authorBadges"`
+}
+
+// Role returns "owner", "moderator" or "member" from the author's badges, or "" for everyone else.
+// Owners and moderators get an icon badge, members get a custom thumbnail badge instead.
+func (m *LiveChatAuthor) Role() string {
+	role := ""
+	for _, badge := range m.AuthorBadges {
+		b := badge.LiveChatAuthorBadgeRenderer
+		switch {
+		case b.Icon != nil && b.Icon.IconType == "OWNER":
+			return "owner"
+		case b.Icon != nil && b.Icon.IconType == "MODERATOR":
+			role = "moderator"
+		case b.CustomThumbnail != nil && role == "":
+			role = "member"
+		}
+	}
+	return role
+}
+
+type LiveChatTextMessageRenderer struct {
+	LiveChatAuthor
+	Message struct {
+		Runs []LiveChatRun `json:"runs"`
+	} `json:"message"`
+}
+
+// LiveChatPaidMessageRenderer is a Super Chat, Message is empty if they didn't write anything
+type LiveChatPaidMessageRenderer struct {
+	LiveChatAuthor
+	Message struct {
+		Runs []LiveChatRun `json:"runs"`
+	} `json:"message"`
+	PurchaseAmountText    LiveChatText `json:"purchaseAmountText"`    // e.g. "$5.00", "CA$10.00", "¥500"
+	HeaderBackgroundColor int64        `json:"headerBackgroundColor"` // ARGB, shows the tier
+}
+
+// LiveChatPaidStickerRenderer is a Super Sticker
+type LiveChatPaidStickerRenderer struct {
+	LiveChatAuthor
+	PurchaseAmountText       LiveChatText       `json:"purchaseAmountText"`
+	MoneyChipBackgroundColor int64              `json:"moneyChipBackgroundColor"`
+	Sticker                  liveChatThumbnails `json:"sticker"`
+}
+
+// StickerURL is the biggest version of the sticker image
+func (s *LiveChatPaidStickerRenderer) StickerURL() string {
+	thumbs := s.Sticker.Thumbnails
+	if len(thumbs) == 0 {
+		return ""
+	}
+	url := thumbs[len(thumbs)-1].URL
+	if strings.HasPrefix(url, "//") {
+		url = "https:" + url
+	}
+	return url
+}
+
+// LiveChatMembershipItemRenderer is a new member, or a milestone when HeaderPrimaryText is set (e.g. "Member for 6 months")
+type LiveChatMembershipItemRenderer struct {
+	LiveChatAuthor
+	HeaderPrimaryText LiveChatText `json:"headerPrimaryText"`
+	HeaderSubtext     LiveChatText `json:"headerSubtext"` // e.g. "Welcome to <level>!" or the level name
+	Message           struct {
+		Runs []LiveChatRun `json:"runs"`
+	} `json:"message"`
+}
+
+// LiveChatGiftPurchaseRenderer is someone gifting memberships, the author is in the header
+type LiveChatGiftPurchaseRenderer struct {
+	ID                      string `json:"id"`
+	TimestampUsec           string `json:"timestampUsec"`
+	AuthorExternalChannelID string `json:"authorExternalChannelId"`
+	Header                  struct {
+		LiveChatSponsorshipsHeaderRenderer struct {
+			AuthorName  LiveChatText `json:"authorName"`
+			PrimaryText LiveChatText `json:"primaryText"` // e.g. "Gifted 5 <channel> memberships"
+		} `json:"liveChatSponsorshipsHeaderRenderer"`
+	} `json:"header"`
+}
+
+// ColorHex turns youtube's ARGB int into "#rrggbb"
+func ColorHex(argb int64) string {
+	if argb == 0 {
+		return ""
+	}
+	return fmt.Sprintf("#%06x", argb&0xFFFFFF)
+}
+
+// LiveChatItem is a chat item, only one of the renderers is set
+type LiveChatItem struct {
+	LiveChatTextMessageRenderer    *LiveChatTextMessageRenderer    `json:"liveChatTextMessageRenderer"`
+	LiveChatPaidMessageRenderer    *LiveChatPaidMessageRenderer    `json:"liveChatPaidMessageRenderer"`
+	LiveChatPaidStickerRenderer    *LiveChatPaidStickerRenderer    `json:"liveChatPaidStickerRenderer"`
+	LiveChatMembershipItemRenderer *LiveChatMembershipItemRenderer `json:"liveChatMembershipItemRenderer"`
+	LiveChatGiftPurchaseRenderer   *LiveChatGiftPurchaseRenderer   `json:"liveChatSponsorshipsGiftPurchaseAnnouncementRenderer"`
+}
+
+// LiveChatAction is one entry in the live chat response, only one of the fields is set
+type LiveChatAction struct {
+	AddChatItemAction *struct {
+		Item LiveChatItem `json:"item"`
+	} `json:"addChatItemAction,omitempty"`
+	MarkChatItemAsDeletedAction *struct {
+		TargetItemID string `json:"targetItemId"`
+	} `json:"markChatItemAsDeletedAction,omitempty"`
+	MarkChatItemsByAuthorAsDeletedAction *struct {
+		ExternalChannelID string `json:"externalChannelId"`
+	} `json:"markChatItemsByAuthorAsDeletedAction,omitempty"`
+}
+
+type liveChatResponse struct {
+	ContinuationContents struct {
+		LiveChatContinuation struct {
+			Actions       []LiveChatAction `json:"actions"`
+			Continuations []struct {
+				TimedContinuationData *struct {
+					Continuation string `json:"continuation"`
+					TimeoutMs    int    `json:"timeoutMs"`
+				} `json:"timedContinuationData"`
+				InvalidationContinuationData *struct {
+					Continuation string `json:"continuation"`
+					TimeoutMs    int    `json:"timeoutMs"`
+				} `json:"invalidationContinuationData"`
+			} `json:"continuations"`
+		} `json:"liveChatContinuation"`
+	} `json:"continuationContents"`
+}
+
+// ParseLiveChatPage loads a live page (e.g. youtube.com/channel/<id>/live) and returns the first continuation token for the full (not "top") chat
+func ParseLiveChatPage(liveURL string) (string, InnertubeConfig, error) {
+	client := &http.Client{Timeout: LIVE_CHAT_HTTP_TIMEOUT}
+	resp, err := client.Get(liveURL)
+	if err != nil {
+		return "", InnertubeConfig{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", InnertubeConfig{}, fmt.Errorf("live page status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", InnertubeConfig{}, err
+	}
+
+	cfgMatch := ytCfgRegex.FindSubmatch(body)
+	if cfgMatch == nil {
+		return "", InnertubeConfig{}, fmt.Errorf("ytcfg not found")
+	}
+	var cfg InnertubeConfig
+	if err := json.Unmarshal(cfgMatch[1], &cfg); err != nil {
+		return "", InnertubeConfig{}, fmt.Errorf("failed to parse ytcfg: %w", err)
+	}
+
+	dataMatch := ytInitialDataRegex.FindSubmatch(body)
+	if dataMatch == nil {
+		return "", InnertubeConfig{}, ErrStreamNotLive
+	}
+	var data liveChatInitialData
+	if err := json.Unmarshal(dataMatch[1], &data); err != nil {
+		return "", InnertubeConfig{}, fmt.Errorf("failed to parse ytInitialData: %w", err)
+	}
+	items := data.Contents.TwoColumnWatchNextResults.ConversationBar.LiveChatRenderer.Header.LiveChatHeaderRenderer.ViewSelector.SortFilterSubMenuRenderer.SubMenuItems
+	if len(items) == 0 {
+		return "", InnertubeConfig{}, ErrStreamNotLive
+	}
+	// the first item is "top chat", the last is "live chat" with every message
+	continuation := items[len(items)-1].Continuation.ReloadContinuationData.Continuation
+	return continuation, cfg, nil
+}
+
+// FetchLiveChat polls once and returns the actions, the next continuation token and how long to wait before polling again
+func FetchLiveChat(continuation string, cfg InnertubeConfig) ([]LiveChatAction, string, time.Duration, error) {
+	reqBody, _ := json.Marshal(map[string]any{
+		"context":      cfg.Context,
+		"continuation": continuation,
+	})
+	client := &http.Client{Timeout: LIVE_CHAT_HTTP_TIMEOUT}
+	resp, err := client.Post(fmt.Sprintf(LIVE_CHAT_URL, cfg.APIKey), "application/json; charset=UTF-8", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", 0, fmt.Errorf("live chat status %d", resp.StatusCode)
+	}
+	var data liveChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, "", 0, err
+	}
+	chat := data.ContinuationContents.LiveChatContinuation
+	// no continuation returned from youtube => the stream has ended
+	if len(chat.Continuations) == 0 {
+		return chat.Actions, "", 0, ErrLiveStreamOver
+	}
+	next := ""
+	timeoutMs := 0
+	if c := chat.Continuations[0].TimedContinuationData; c != nil {
+		next, timeoutMs = c.Continuation, c.TimeoutMs
+	} else if c := chat.Continuations[0].InvalidationContinuationData; c != nil {
+		next, timeoutMs = c.Continuation, c.TimeoutMs
+	}
+	if next == "" {
+		return chat.Actions, "", 0, ErrLiveStreamOver
+	}
+	wait := time.Duration(timeoutMs) * time.Millisecond
+	if wait <= 0 {
+		wait = 5 * time.Second
+	}
+	return chat.Actions, next, wait, nil
+}
+
+// RunsToText joins the runs into a message, custom emojis become their :shortcut: with an entry in the emotes map (positions in runes)
+func RunsToText(runs []LiveChatRun) (string, map[string][]string) {
+	var sb strings.Builder
+	emotes := make(map[string][]string)
+	pos := 0
+	for _, run := range runs {
+		if run.Emoji == nil {
+			sb.WriteString(run.Text)
+			pos += utf8.RuneCountInString(run.Text)
+			continue
+		}
+		if !run.Emoji.IsCustomEmoji || len(run.Emoji.Image.Thumbnails) == 0 {
+			sb.WriteString(run.Emoji.EmojiID)
+			pos += utf8.RuneCountInString(run.Emoji.EmojiID)
+			continue
+		}
+		code := run.Emoji.EmojiID
+		if len(run.Emoji.Shortcuts) > 0 {
+			code = run.Emoji.Shortcuts[0]
+		}
+		thumbs := run.Emoji.Image.Thumbnails
+		url := thumbs[len(thumbs)-1].URL // the last thumbnail is the biggest
+		length := utf8.RuneCountInString(code)
+		emotes[url] = append(emotes[url], fmt.Sprintf("%d-%d", pos, pos+length-1))
+		sb.WriteString(code)
+		pos += length
+	}
+	return sb.String(), emotes
+}
+
+// ParseTimestampUsec converts youtube's microsecond timestamp string
+func ParseTimestampUsec(usec string) time.Time {
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMicro(n)
+}
@@ -12,15 +12,15 @@ import (
 
 // returns the first live video ID
 func GetYoutubeLiveVideoID(youtubeID string) (string, error) {
-	liveVids, err := getYoutubeLiveVideoIDs(youtubeID)
+	liveVids, err := GetYoutubeLiveVideoIDs(youtubeID)
 	if err != nil || len(liveVids) == 0 {
 		return "", fmt.Errorf("no live video found")
 	}
 	return liveVids[0], nil
 }
 
-// finds all live stream video IDs
-func getYoutubeLiveVideoIDs(youtubeID string) ([]string, error) {
+// finds all live stream video IDs, e.g. a horizontal and a vertical stream at the same time. Unlisted streams aren't on the page.
+func GetYoutubeLiveVideoIDs(youtubeID string) ([]string, error) {
 	data, err := getYtInitialData(youtubeID, "streams")
 	if err != nil {
 		return nil, err
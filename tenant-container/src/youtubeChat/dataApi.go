@@ -0,0 +1,181 @@
+package youtubeChat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"multibot/common/src/env"
+
+	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/platformAuth"
+	"multibot/tenant-container/src/youtubeApi"
+)
+
+const (
+	YOUTUBE_API_MIN_POLL_INTERVAL = 5 * time.Second //each poll costs 5 quota units, so never poll faster than this even if youtube says we can
+)
+
+// apiAuth prefers the streamer's login, it has its own quota and can use liveBroadcasts.list
+func apiAuth(ctx context.Context) (youtubeApi.Auth, error) {
+	if tok, err := platformAuth.Token(ctx, "youtube"); err == nil {
+		return youtubeApi.Auth{Token: tok}, nil
+	}
+	if env.YOUTUBE_API_KEY != "" {
+		return youtubeApi.Auth{APIKey: env.YOUTUBE_API_KEY}, nil
+	}
+	return youtubeApi.Auth{}, fmt.Errorf("api mode needs YOUTUBE_API_KEY or the streamer logged in to youtube")
+}
+
+func liveVideosAPI(ctx context.Context, channelID string) ([]string, error) {
+	auth, err := apiAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chats, err := youtubeApi.FindLiveChats(ctx, auth, channelID)
+	if err != nil {
+		return nil, err
+	}
+	videoIDs := []string{}
+	for _, chat := range chats {
+		videoIDs = append(videoIDs, chat.VideoID)
+	}
+	return videoIDs, nil
+}
+
+// connectAPI reads the chat with liveChatMessages.list from the youtube data API
+func connectAPI(ctx context.Context, videoID string) (string, pollFunc, error) {
+	auth, err := apiAuth(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	liveChatID, err := youtubeApi.GetActiveLiveChatID(ctx, auth, videoID)
+	if err != nil {
+		return "", nil, fmt.Errorf("live chat ID: %w", err)
+	}
+	log.Printf("[youtube] found live chat %s for youtu.be/%s", liveChatID, videoID)
+	pageToken := ""
+	poll := func(ctx context.Context) (time.Duration, error) {
+		// get the auth again every time, the token might have been refreshed
+		auth, err := apiAuth(ctx)
+		if err != nil {
+			return 0, err
+		}
+		msgs, next, wait, err := youtubeApi.ListLiveChatMessages(ctx, auth, liveChatID, pageToken)
+		for _, msg := range msgs {
+			handleAPIMessage(msg, videoID)
+		}
+		if err != nil {
+			return 0, err
+		}
+		pageToken = next
+		return max(wait, YOUTUBE_API_MIN_POLL_INTERVAL), nil
+	}
+	return liveChatID, poll, nil
+}
+
+// handleAPIMessage is handleItem for the data API's messages
+func handleAPIMessage(m youtubeApi.LiveChatMessage, videoID string) {
+	snippet := m.Snippet
+	author := m.AuthorDetails.DisplayName
+	switch snippet.Type {
+	case "messageDeletedEvent":
+		multiChat.DeleteMessage("youtube", snippet.MessageDeletedDetails.DeletedMessageID)
+		return
+	case "userBannedEvent":
+		multiChat.PurgeUser("youtube", snippet.UserBannedDetails.BannedUserDetails.ChannelID, "")
+		return
+	}
+	if isOldTime(snippet.PublishedAt) {
+		return
+	}
+
+	switch snippet.Type {
+	case "textMessageEvent":
+		text := snippet.TextMessageDetails.MessageText
+		if text == "" {
+			text = snippet.DisplayMessage
+		}
+		if text == "" {
+			log.Println("[youtube] Skipping empty message")
+			return
+		}
+		log.Printf("[youtube] %s: %s\n", author, text)
+		multiChat.SendChatMessage(apiChatMessage(m, text, videoID))
+
+	case "superChatEvent":
+		amount := snippet.SuperChatDetails.AmountDisplayString
+		sendAPIEvent(m, videoID, snippet.SuperChatDetails.UserComment, &multiChat.Event{
+			Kind:          multiChat.EVENT_SUPER_CHAT,
+			User:          author,
+			Amount:        amount,
+			Currency:      currency(amount),
+			SystemMessage: fmt.Sprintf("%s sent a %s Super Chat", author, amount),
+		})
+
+	case "superStickerEvent":
+		amount := snippet.SuperStickerDetails.AmountDisplayString
+		sendAPIEvent(m, videoID, "", &multiChat.Event{
+			Kind:          multiChat.EVENT_SUPER_STICKER,
+			User:          author,
+			Amount:        amount,
+			Currency:      currency(amount),
+			SystemMessage: fmt.Sprintf("%s sent a %s Super Sticker: %s", author, amount, snippet.SuperStickerDetails.SuperStickerMetadata.AltText),
+		})
+
+	case "newSponsorEvent":
+		sendAPIEvent(m, videoID, "", &multiChat.Event{
+			Kind:          multiChat.EVENT_MEMBERSHIP,
+			User:          author,
+			SystemMessage: fmt.Sprintf("%s became a member: %s", author, snippet.NewSponsorDetails.MemberLevelName),
+		})
+
+	case "memberMilestoneChatEvent":
+		details := snippet.MemberMilestoneChatDetails
+		sendAPIEvent(m, videoID, details.UserComment, &multiChat.Event{
+			Kind:          multiChat.EVENT_MEMBER_MILESTONE,
+			User:          author,
+			Months:        details.MemberMonth,
+			SystemMessage: fmt.Sprintf("%s: Member for %d months", author, details.MemberMonth),
+		})
+
+	case "membershipGiftingEvent":
+		count := snippet.MembershipGiftingDetails.GiftMembershipsCount
+		sendAPIEvent(m, videoID, "", &multiChat.Event{
+			Kind:          multiChat.EVENT_MEMBER_GIFT,
+			User:          author,
+			Count:         count,
+			SystemMessage: fmt.Sprintf("%s: Gifted %d memberships", author, count),
+		})
+	}
+}
+
+func apiChatMessage(m youtubeApi.LiveChatMessage, text, videoID string) multiChat.ChatMessage {
+	return multiChat.ChatMessage{
+		PlatformID: m.ID,
+		UserID:     m.AuthorDetails.ChannelID,
+		Source:     "youtube",
+		Username:   m.AuthorDetails.DisplayName,
+		Emotes:     make(map[string][]string),
+		Text:       text,
+		Role:       youtubeRoles[m.Role()],
+		Stream:     videoID,
+	}
+}
+
+func sendAPIEvent(m youtubeApi.LiveChatMessage, videoID, text string, event *multiChat.Event) {
+	log.Printf("[youtube] %s event: %s", event.Kind, event.SystemMessage)
+	msg := apiChatMessage(m, text, videoID)
+	msg.Event = event
+	multiChat.SendEvent(msg)
+}
+
+// AccountCreatedAt looks up when a chatter's channel was made, for automod's new account rule
+func AccountCreatedAt(ctx context.Context, userID, username string) (time.Time, error) {
+	auth, err := apiAuth(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return youtubeApi.GetChannelCreatedAt(ctx, auth, userID)
+}
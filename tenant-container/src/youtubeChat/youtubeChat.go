@@ -2,155 +2,616 @@ package youtubeChat
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	goaway "github.com/TwiN/go-away"
-	YtChat "github.com/abhinavxd/youtube-live-chat-downloader/v2"
+	"golang.org/x/oauth2"
 
+	"multibot/common/src/env"
+
+	"multibot/tenant-container/src/chatSource"
 	"multibot/tenant-container/src/multiChat"
+	"multibot/tenant-container/src/platformAuth"
 	"multibot/tenant-container/src/props"
-	"multibot/tenant-container/src/twitchChat"
 	"multibot/tenant-container/src/youtubeApi"
 )
 
 const (
-	YOUTUBE_MAX_MESSAGE_AGE = 1 * time.Minute
+	YOUTUBE_MAX_MESSAGE_AGE   = 1 * time.Minute
+	YOUTUBE_RETRY_DELAY       = 5 * time.Second //wait after a failed poll
+	YOUTUBE_MAX_FETCH_ERRORS  = 5               //failed polls in a row before falling back to the other mode
+	YOUTUBE_DISCOVER_INTERVAL = 2 * time.Minute //how often to look for more live videos while connected
+
+	// values of the youtube_mode channel prop
+	YOUTUBE_MODE_SCRAPE = "scrape" //read the chat the way the youtube web page does, needs no setup
+	YOUTUBE_MODE_API    = "api"    //the official data API, needs YOUTUBE_API_KEY or the streamer logged in to youtube
 )
 
 var (
+	numberRegex = regexp.MustCompile(`\d+`)
+
 	youtubeConnected bool
 	youtubeCancel    context.CancelFunc
+	youtubeLock      sync.Mutex // to guard youtubeConnected and youtubeCancel
 	youtubeWG        sync.WaitGroup
+
+	// the live videos being read, by video ID
+	youtubeStreams     = make(map[string]*stream)
+	youtubeStreamsLock sync.Mutex
 )
 
-func ConnectToYouTubeLoop() {
-	for {
-		ConnectToYouTube()
-		time.Sleep(1 * time.Minute)
+// youtube badge roles => multiChat roles
+var youtubeRoles = map[string]string{
+	"owner":     multiChat.ROLE_BROADCASTER,
+	"moderator": multiChat.ROLE_MOD,
+	"member":    multiChat.ROLE_SUB,
+}
+
+// stream is a live video whose chat is being read
+type stream struct {
+	liveChatID string // for posting with the owner's token, looked up on the first post if the mode doesn't know it
+	mode       string // the mode reading it, which is not youtube_mode after a fallback
+}
+
+// config is what the channel props say to read
+type config struct {
+	channelID string   // youtube_id
+	videoIDs  []string // youtube_video_ids, if empty every live video on the channel is read
+	mode      string   // youtube_mode
+}
+
+// mode is a way of reading youtube chat
+type mode struct {
+	// liveVideos lists the channel's live videos
+	liveVideos func(ctx context.Context, channelID string) ([]string, error)
+	// connect finds a video's chat and returns the chat ID if it knows it, and a func that polls
+	// the chat once and returns how long to wait before the next poll
+	connect func(ctx context.Context, videoID string) (liveChatID string, poll pollFunc, err error)
+}
+
+type pollFunc func(ctx context.Context) (time.Duration, error)
+
+var modes = map[string]mode{
+	YOUTUBE_MODE_SCRAPE: {liveVideosScrape, connectScrape},
+	YOUTUBE_MODE_API:    {liveVideosAPI, connectAPI},
+}
+
+func otherMode(mode string) string {
+	if mode == YOUTUBE_MODE_API {
+		return YOUTUBE_MODE_SCRAPE
 	}
+	return YOUTUBE_MODE_API
 }
 
-func ConnectToYouTube() {
-	if !props.GetChannelProp(nil, "enabled").(bool) {
-		log.Println("[youtube] bot is disabled, will not connect")
-		return
+// Source is the youtube ChatSource, registered with the supervisor in main
+var Source chatSource.ChatSource = source{}
+
+type source struct{}
+
+func (source) Name() string { return "youtube" }
+
+func (source) Start() error {
+	cfg := config{}
+	cfg.channelID, _ = props.GetChannelProp(nil, "youtube_id").(string)
+	if err := props.GetChannelPropJSON(nil, "youtube_video_ids", &cfg.videoIDs); err != nil {
+		log.Println("[youtube] error reading youtube_video_ids:", err)
 	}
-	if youtubeConnected {
-		log.Println("[youtube] already connected, will not connect")
-		return
+	if cfg.channelID == "" && len(cfg.videoIDs) == 0 {
+		return fmt.Errorf("no youtube_id or youtube_video_ids set: %w", chatSource.ErrNotConfigured)
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	youtubeCancel = cancel
-	youtubeConnected = true
-
-	youtubeID, ok := props.GetChannelProp(ctx, "youtube_id").(string)
-	if !ok || youtubeID == "" {
-		log.Println("[youtube] no youtube_id set, skipping connect")
-		youtubeConnected = false
-		return
+	cfg.mode, _ = props.GetChannelProp(nil, "youtube_mode").(string)
+	if _, ok := modes[cfg.mode]; !ok {
+		cfg.mode = YOUTUBE_MODE_SCRAPE
 	}
 
-	// Build a URL for the channel's live stream
-	liveURL := fmt.Sprintf("https://www.youtube.com/channel/%s/live", youtubeID)
-	log.Println("[youtube] connecting to", liveURL)
-
-	continuation, cfg, err := YtChat.ParseInitialData(liveURL)
-	if err != nil {
-		log.Println("[youtube] parse error:", err)
-		youtubeConnected = false
-		return
+	ctx, cancel := context.WithCancel(context.Background())
+	ended := make(chan string)
+	if err := attachAll(ctx, cfg, ended); err != nil {
+		cancel()
+		return err
 	}
+	youtubeLock.Lock()
+	youtubeCancel = cancel
+	youtubeConnected = true
+	youtubeLock.Unlock()
 	youtubeWG.Add(1)
 	go func() {
 		defer youtubeWG.Done()
 		defer func() {
+			youtubeLock.Lock()
 			youtubeConnected = false
+			youtubeLock.Unlock()
 			log.Println("[youtube] disconnected")
-			twitchChat.Say("disconnected from youtube chat")
+			chatSource.SayAll("disconnected from youtube chat")
 		}()
-		log.Println("[youtube] connected")
-		videoID, err := youtubeApi.GetYoutubeLiveVideoID(youtubeID)
-		if err != nil {
-			log.Printf("[youtube] %s failed to find livestream: %v", youtubeID, err)
-		} else {
-			log.Printf("[youtube] %s connected to youtube chat: youtu.be/%s", youtubeID, videoID)
-			//delay the message a bit to allow the disconnect message to come thru first
-			twitchChat.SayLater("connected to youtube chat: youtu.be/" + videoID)
-		}
-
 		for {
 			select {
 			case <-ctx.Done():
 				log.Println("[youtube] canceled")
 				return
-			default:
+			case videoID := <-ended:
+				if removeStream(videoID) == 0 {
+					return
+				}
+				chatSource.SayAll("youtube stream ended: youtu.be/" + videoID)
+			case <-time.After(YOUTUBE_DISCOVER_INTERVAL):
+				// a second stream might have started, or one of the youtube_video_ids gone live
+				if err := attachAll(ctx, cfg, ended); err != nil && !errors.Is(err, youtubeApi.ErrStreamNotLive) {
+					log.Println("[youtube] error looking for more live videos:", err)
+				}
 			}
+		}
+	}()
+	return nil
+}
+
+// attachAll starts reading every live video that isn't being read yet. It fails if nothing is being read afterwards.
+func attachAll(ctx context.Context, cfg config, ended chan<- string) error {
+	videoIDs := cfg.videoIDs
+	if len(videoIDs) == 0 {
+		var err error
+		if videoIDs, err = liveVideos(ctx, cfg); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, videoID := range videoIDs {
+		if isAttached(videoID) {
+			continue
+		}
+		if err := attach(ctx, videoID, cfg.mode, ended); err != nil {
+			log.Printf("[youtube] youtu.be/%s: %v", videoID, err)
+			errs = append(errs, err)
+		}
+	}
+	if streamCount() == 0 {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// liveVideos lists the channel's live videos with the mode and then the other one. No live videos is not
+// a failure, the other mode would just say the same.
+func liveVideos(ctx context.Context, cfg config) ([]string, error) {
+	videoIDs, err := modes[cfg.mode].liveVideos(ctx, cfg.channelID)
+	if err != nil && !errors.Is(err, youtubeApi.ErrStreamNotLive) {
+		fallback := otherMode(cfg.mode)
+		log.Printf("[youtube] %s mode couldn't list live videos: %v, trying %s mode", cfg.mode, err, fallback)
+		var fallbackErr error
+		if videoIDs, fallbackErr = modes[fallback].liveVideos(ctx, cfg.channelID); fallbackErr != nil {
+			return nil, fmt.Errorf("%s mode: %v, %s mode: %w", cfg.mode, err, fallback, fallbackErr)
+		}
+	}
+	if len(videoIDs) == 0 {
+		return nil, fmt.Errorf("%s: %w", cfg.channelID, youtubeApi.ErrStreamNotLive)
+	}
+	return videoIDs, nil
+}
 
-			log.Println("[youtube] Fetching chat messages...")
+// attach connects to a video's chat, with the other mode if the first one fails, and reads it in the background until the stream ends
+func attach(ctx context.Context, videoID, modeName string, ended chan<- string) error {
+	liveChatID, poll, err := modes[modeName].connect(ctx, videoID)
+	if err != nil && !errors.Is(err, youtubeApi.ErrStreamNotLive) {
+		fallback := otherMode(modeName)
+		log.Printf("[youtube] %s mode failed: %v, trying %s mode", modeName, err, fallback)
+		var fallbackErr error
+		if liveChatID, poll, fallbackErr = modes[fallback].connect(ctx, videoID); fallbackErr != nil {
+			return fmt.Errorf("%s mode: %v, %s mode: %w", modeName, err, fallback, fallbackErr)
+		}
+		modeName, err = fallback, nil
+	}
+	if err != nil {
+		return err
+	}
+	setStream(videoID, &stream{liveChatID: liveChatID, mode: modeName})
+	log.Printf("[youtube] connected to youtube chat: youtu.be/%s in %s mode", videoID, modeName)
+	//delay the message a bit to allow the disconnect message to come thru first
+	chatSource.SayAllLater("connected to youtube chat: youtu.be/" + videoID)
 
-			chat, newCont, err := YtChat.FetchContinuationChat(continuation, cfg)
-			if err == YtChat.ErrLiveStreamOver {
-				log.Println("[youtube] stream over")
-				return
-			}
+	youtubeWG.Add(1)
+	go func() {
+		defer youtubeWG.Done()
+		read(ctx, videoID, modeName, poll)
+		select {
+		case ended <- videoID:
+		case <-ctx.Done():
+		}
+	}()
+	return nil
+}
+
+// read polls a video's chat until the stream ends or ctx is done
+func read(ctx context.Context, videoID, modeName string, poll pollFunc) {
+	failures := 0
+	for {
+		wait, err := poll(ctx)
+		if err == youtubeApi.ErrLiveStreamOver {
+			log.Printf("[youtube] youtu.be/%s stream over", videoID)
+			return
+		}
+		if err != nil {
+			log.Printf("[youtube] youtu.be/%s %s mode fetch error: %v", videoID, modeName, err)
+			wait = YOUTUBE_RETRY_DELAY
+			failures++
+		} else {
+			failures = 0
+		}
+		// the other mode might still work, e.g. youtube changed its page or the API quota ran out
+		if failures >= YOUTUBE_MAX_FETCH_ERRORS {
+			failures = 0
+			fallback := otherMode(modeName)
+			liveChatID, newPoll, err := modes[fallback].connect(ctx, videoID)
 			if err != nil {
-				log.Println("[youtube] fetch error:", err)
-				time.Sleep(5 * time.Second)
-				continue
+				log.Printf("[youtube] falling back to %s mode failed: %v", fallback, err)
+			} else {
+				log.Printf("[youtube] youtu.be/%s %s mode keeps failing, switched to %s mode", videoID, modeName, fallback)
+				modeName, poll = fallback, newPoll
+				setStream(videoID, &stream{liveChatID: liveChatID, mode: modeName})
 			}
-			log.Printf("[youtube] Retrieved %d messages\n", len(chat))
-
-			continuation = newCont
-			now := time.Now()
-			for _, msg := range chat {
-				log.Printf("[youtube] Processing message: %+v\n", msg)
-				if !youtubeConnected {
-					log.Println("[youtube] got message while not connected:", msg)
-					continue
-				}
-				if msg.Message == "" {
-					log.Println("[youtube] Skipping empty message")
-					continue
-				}
-				if now.Sub(msg.Timestamp) > YOUTUBE_MAX_MESSAGE_AGE {
-					log.Println("[youtube] Skipping old message")
-					continue
-				}
-				log.Printf("[youtube] %s: %s\n", msg.AuthorName, msg.Message)
-				multiChat.SendChat("youtube", msg.AuthorName, "", "", msg.Message, nil)
-
-				// Forward specific commands from YouTube to Twitch
-				fwdCmds := props.GetChannelProp(ctx, "fwd_cmds_yt_twitch").([]string)
-				for _, cmdStr := range fwdCmds {
-					if strings.HasPrefix(msg.Message, cmdStr) {
-						log.Println("[youtube] Forwarding command to Twitch:", msg.Message)
-						twitchChat.Say(goaway.Censor(msg.Message))
-					}
-				}
-			}
-			time.Sleep(2 * time.Second)
 		}
-	}()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func setStream(videoID string, s *stream) {
+	youtubeStreamsLock.Lock()
+	defer youtubeStreamsLock.Unlock()
+	youtubeStreams[videoID] = s
+}
+
+func isAttached(videoID string) bool {
+	youtubeStreamsLock.Lock()
+	defer youtubeStreamsLock.Unlock()
+	_, ok := youtubeStreams[videoID]
+	return ok
 }
 
-func DisconnectFromYouTube() {
-	if youtubeConnected {
-		log.Println("[youtube] disconnecting")
-		if youtubeCancel != nil {
-			youtubeCancel()
+// removeStream returns how many streams are left
+func removeStream(videoID string) int {
+	youtubeStreamsLock.Lock()
+	defer youtubeStreamsLock.Unlock()
+	delete(youtubeStreams, videoID)
+	return len(youtubeStreams)
+}
+
+func streamCount() int {
+	youtubeStreamsLock.Lock()
+	defer youtubeStreamsLock.Unlock()
+	return len(youtubeStreams)
+}
+
+func liveVideosScrape(ctx context.Context, channelID string) ([]string, error) {
+	return youtubeApi.GetYoutubeLiveVideoIDs(channelID)
+}
+
+// connectScrape reads the chat through the endpoint the youtube web page uses
+func connectScrape(ctx context.Context, videoID string) (string, pollFunc, error) {
+	liveURL := "https://www.youtube.com/watch?v=" + videoID
+	log.Println("[youtube] connecting to", liveURL)
+
+	continuation, cfg, err := youtubeApi.ParseLiveChatPage(liveURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse error: %w", err)
+	}
+	poll := func(ctx context.Context) (time.Duration, error) {
+		log.Println("[youtube] Fetching chat messages...")
+		actions, newCont, wait, err := youtubeApi.FetchLiveChat(continuation, cfg)
+		if err != nil {
+			return 0, err
 		}
-		youtubeWG.Wait()
-		youtubeConnected = false
+		log.Printf("[youtube] Retrieved %d actions\n", len(actions))
+		continuation = newCont
+		for _, action := range actions {
+			handleAction(ctx, action, videoID)
+		}
+		return wait, nil
+	}
+	return "", poll, nil
+}
+
+// handleAction handles a scraped chat action from the video
+func handleAction(ctx context.Context, action youtubeApi.LiveChatAction, videoID string) {
+	switch {
+	case action.MarkChatItemAsDeletedAction != nil:
+		multiChat.DeleteMessage("youtube", action.MarkChatItemAsDeletedAction.TargetItemID)
+	case action.MarkChatItemsByAuthorAsDeletedAction != nil:
+		multiChat.PurgeUser("youtube", action.MarkChatItemsByAuthorAsDeletedAction.ExternalChannelID, "")
+	case action.AddChatItemAction != nil:
+		handleItem(action.AddChatItemAction.Item, videoID)
 	}
 }
 
-func GetStatus() map[string]interface{} {
-	return map[string]interface{}{
-		"connected":     youtubeConnected,
-		"youtubeCancel": fmt.Sprintf("%#v", youtubeCancel),
+func handleItem(item youtubeApi.LiveChatItem, videoID string) {
+	switch {
+	case item.LiveChatTextMessageRenderer != nil:
+		msg := item.LiveChatTextMessageRenderer
+		text, emotes := youtubeApi.RunsToText(msg.Message.Runs)
+		author := msg.AuthorName.SimpleText
+		if text == "" {
+			log.Println("[youtube] Skipping empty message")
+			return
+		}
+		if isOld(msg.TimestampUsec) {
+			return
+		}
+		log.Printf("[youtube] %s: %s\n", author, text)
+		chatMsg := multiChat.ChatMessage{
+			PlatformID: msg.ID,
+			UserID:     msg.AuthorExternalChannelID,
+			Source:     "youtube",
+			Username:   author,
+			Emotes:     emotes,
+			Text:       text,
+			Role:       youtubeRoles[msg.Role()],
+			Stream:     videoID,
+		}
+		multiChat.SendChatMessage(chatMsg)
+
+	case item.LiveChatPaidMessageRenderer != nil:
+		msg := item.LiveChatPaidMessageRenderer
+		if isOld(msg.TimestampUsec) {
+			return
+		}
+		text, emotes := youtubeApi.RunsToText(msg.Message.Runs)
+		author := msg.AuthorName.String()
+		amount := msg.PurchaseAmountText.String()
+		sendEvent(videoID, &msg.LiveChatAuthor, text, emotes, &multiChat.Event{
+			Kind:          multiChat.EVENT_SUPER_CHAT,
+			User:          author,
+			Amount:        amount,
+			Currency:      currency(amount),
+			Color:         youtubeApi.ColorHex(msg.HeaderBackgroundColor),
+			SystemMessage: fmt.Sprintf("%s sent a %s Super Chat", author, amount),
+		})
+
+	case item.LiveChatPaidStickerRenderer != nil:
+		msg := item.LiveChatPaidStickerRenderer
+		if isOld(msg.TimestampUsec) {
+			return
+		}
+		author := msg.AuthorName.String()
+		amount := msg.PurchaseAmountText.String()
+		sendEvent(videoID, &msg.LiveChatAuthor, "", nil, &multiChat.Event{
+			Kind:          multiChat.EVENT_SUPER_STICKER,
+			User:          author,
+			Amount:        amount,
+			Currency:      currency(amount),
+			Color:         youtubeApi.ColorHex(msg.MoneyChipBackgroundColor),
+			Image:         msg.StickerURL(),
+			SystemMessage: fmt.Sprintf("%s sent a %s Super Sticker", author, amount),
+		})
+
+	case item.LiveChatMembershipItemRenderer != nil:
+		msg := item.LiveChatMembershipItemRenderer
+		if isOld(msg.TimestampUsec) {
+			return
+		}
+		text, emotes := youtubeApi.RunsToText(msg.Message.Runs)
+		author := msg.AuthorName.String()
+		event := &multiChat.Event{
+			Kind:          multiChat.EVENT_MEMBERSHIP,
+			User:          author,
+			SystemMessage: fmt.Sprintf("%s became a member: %s", author, msg.HeaderSubtext.String()),
+		}
+		// milestones say "Member for N months" in the primary text, new members don't have one
+		if milestone := msg.HeaderPrimaryText.String(); milestone != "" {
+			event.Kind = multiChat.EVENT_MEMBER_MILESTONE
+			event.Months = firstNumber(milestone)
+			event.SystemMessage = fmt.Sprintf("%s: %s", author, milestone)
+		}
+		sendEvent(videoID, &msg.LiveChatAuthor, text, emotes, event)
+
+	case item.LiveChatGiftPurchaseRenderer != nil:
+		msg := item.LiveChatGiftPurchaseRenderer
+		if isOld(msg.TimestampUsec) {
+			return
+		}
+		header := msg.Header.LiveChatSponsorshipsHeaderRenderer
+		author := header.AuthorName.String()
+		sendEvent(videoID, &youtubeApi.LiveChatAuthor{ID: msg.ID, AuthorName: header.AuthorName, AuthorExternalChannelID: msg.AuthorExternalChannelID}, "", nil, &multiChat.Event{
+			Kind:          multiChat.EVENT_MEMBER_GIFT,
+			User:          author,
+			Count:         firstNumber(header.PrimaryText.String()),
+			SystemMessage: fmt.Sprintf("%s: %s", author, header.PrimaryText.String()),
+		})
+	}
+}
+
+func sendEvent(videoID string, author *youtubeApi.LiveChatAuthor, text string, emotes map[string][]string, event *multiChat.Event) {
+	log.Printf("[youtube] %s event: %s", event.Kind, event.SystemMessage)
+	multiChat.SendEvent(multiChat.ChatMessage{
+		PlatformID: author.ID,
+		UserID:     author.AuthorExternalChannelID,
+		Source:     "youtube",
+		Username:   author.AuthorName.String(),
+		Emotes:     emotes,
+		Text:       text,
+		Role:       youtubeRoles[author.Role()],
+		Stream:     videoID,
+		Event:      event,
+	})
+}
+
+// the first poll after connecting returns the recent backlog, which was already shown or is too old to matter
+func isOld(timestampUsec string) bool {
+	return isOldTime(youtubeApi.ParseTimestampUsec(timestampUsec))
+}
+
+func isOldTime(t time.Time) bool {
+	if time.Since(t) > YOUTUBE_MAX_MESSAGE_AGE {
+		log.Println("[youtube] Skipping old message")
+		return true
+	}
+	return false
+}
+
+// currency is the non-number part of an amount like "$5.00", "CA$10.00" or "5,00 €"
+func currency(amount string) string {
+	return strings.TrimSpace(strings.Trim(amount, "0123456789.,\u00a0 "))
+}
+
+func firstNumber(s string) int {
+	n, _ := strconv.Atoi(numberRegex.FindString(strings.ReplaceAll(s, ",", "")))
+	return n
+}
+
+func (source) Stop() {
+	youtubeLock.Lock()
+	cancel := youtubeCancel
+	youtubeCancel = nil
+	youtubeLock.Unlock()
+	if cancel == nil {
+		return
+	}
+	log.Println("[youtube] disconnecting")
+	cancel()
+	// not under youtubeLock, the read goroutine takes it on the way out
+	youtubeWG.Wait()
+	youtubeLock.Lock()
+	youtubeConnected = false
+	youtubeLock.Unlock()
+	youtubeStreamsLock.Lock()
+	youtubeStreams = make(map[string]*stream)
+	youtubeStreamsLock.Unlock()
+}
+
+func connected() bool {
+	youtubeLock.Lock()
+	defer youtubeLock.Unlock()
+	return youtubeConnected
+}
+
+// Say posts into every live chat with the channel owner's account, which they connect on the bot page
+func (source) Say(text string) error {
+	if !connected() {
+		return fmt.Errorf("not connected")
+	}
+	tok, err := platformAuth.Token(nil, "youtube")
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	streams := streamsSnapshot()
+	if len(streams) == 0 {
+		return fmt.Errorf("no live video found")
+	}
+	var errs []error
+	for videoID, s := range streams {
+		liveChatID, err := liveChatIDFor(ctx, tok, videoID, s)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := youtubeApi.InsertLiveChatMessage(ctx, tok, liveChatID, text); err != nil {
+			errs = append(errs, fmt.Errorf("youtu.be/%s: %w", videoID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// streamsSnapshot copies the streams being read, so API calls for them don't hold youtubeStreamsLock
+func streamsSnapshot() map[string]stream {
+	youtubeStreamsLock.Lock()
+	defer youtubeStreamsLock.Unlock()
+	streams := make(map[string]stream, len(youtubeStreams))
+	for videoID, s := range youtubeStreams {
+		streams[videoID] = *s
+	}
+	return streams
+}
+
+// liveChatIDFor returns the stream's chat ID, and looks it up and remembers it if its mode didn't know it
+func liveChatIDFor(ctx context.Context, tok *oauth2.Token, videoID string, s stream) (string, error) {
+	if s.liveChatID != "" {
+		return s.liveChatID, nil
+	}
+	id, err := youtubeApi.GetActiveLiveChatID(ctx, youtubeApi.Auth{Token: tok}, videoID)
+	if err != nil {
+		return "", fmt.Errorf("youtu.be/%s live chat ID error: %w", videoID, err)
+	}
+	youtubeStreamsLock.Lock()
+	if current, ok := youtubeStreams[videoID]; ok && current.liveChatID == "" {
+		current.liveChatID = id
+	}
+	youtubeStreamsLock.Unlock()
+	return id, nil
+}
+
+// DeleteMessage deletes a message with the channel owner's account. Only messages read in api mode
+// can be deleted, the ids of scraped messages aren't the data API's.
+func (source) DeleteMessage(msg multiChat.ChatMessage) error {
+	// the stream's mode now, which is the one the message was read in unless the stream fell back since
+	s, ok := streamsSnapshot()[msg.Stream]
+	if !ok {
+		return fmt.Errorf("youtu.be/%s isn't being read anymore, so the message can't be deleted on youtube", msg.Stream)
+	}
+	if s.mode != YOUTUBE_MODE_API {
+		return fmt.Errorf("youtu.be/%s is read in %s mode, its messages can only be deleted on youtube in %s mode", msg.Stream, s.mode, YOUTUBE_MODE_API)
+	}
+	tok, err := platformAuth.Token(nil, "youtube")
+	if err != nil {
+		return err
+	}
+	return youtubeApi.DeleteLiveChatMessage(context.Background(), tok, msg.PlatformID)
+}
+
+// Ban bans the user from the chat of the stream the message came from, or from every live chat if it isn't known
+func (source) Ban(msg multiChat.ChatMessage, duration time.Duration, reason string) error {
+	if msg.UserID == "" {
+		return fmt.Errorf("no youtube channel ID")
+	}
+	tok, err := platformAuth.Token(nil, "youtube")
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var errs []error
+	banned := 0
+	for videoID, s := range streamsSnapshot() {
+		if msg.Stream != "" && msg.Stream != videoID {
+			continue
+		}
+		liveChatID, err := liveChatIDFor(ctx, tok, videoID, s)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := youtubeApi.BanLiveChatUser(ctx, tok, liveChatID, msg.UserID, duration); err != nil {
+			errs = append(errs, fmt.Errorf("youtu.be/%s: %w", videoID, err))
+			continue
+		}
+		banned++
+	}
+	if banned == 0 && len(errs) == 0 {
+		return fmt.Errorf("no live video found")
+	}
+	return errors.Join(errs...)
+}
+
+func (source) Status() chatSource.Status {
+	streams := map[string]string{} // video ID => mode
+	youtubeStreamsLock.Lock()
+	for videoID, s := range youtubeStreams {
+		streams[videoID] = s.mode
+	}
+	youtubeStreamsLock.Unlock()
+	return chatSource.Status{
+		Connected: connected(),
+		Details: map[string]any{
+			"logged_in":   platformAuth.IsLoggedIn(nil, "youtube"),
+			"streams":     streams,
+			"api_key_set": env.YOUTUBE_API_KEY != "",
+		},
 	}
 }
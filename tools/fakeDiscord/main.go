@@ -0,0 +1,131 @@
+// fakeDiscord is a tiny stand-in for the discord gateway and API, to test the discord connector locally.
+// Run it, set DISCORD_GATEWAY_URL=ws://127.0.0.1:8090 and DISCORD_API_URL=http://127.0.0.1:8090 on the
+// tenant container, save any bot token and the -channel ID on the bot page, then type "username: message"
+// lines here to send them to the bot. Messages the bot posts are printed.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	addr      = flag.String("addr", "127.0.0.1:8090", "address to listen on")
+	channelID = flag.String("channel", "123", "the discord channel ID the messages are sent in")
+
+	upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	clients  = make(map[*websocket.Conn]*sync.Mutex)
+	seq      int64
+	lock     sync.Mutex
+)
+
+func main() {
+	flag.Parse()
+	http.HandleFunc("/", gatewayHandler)
+	http.HandleFunc("/channels/", postMessageHandler)
+	go readStdin()
+	log.Printf("fake discord on %s, channel %s", *addr, *channelID)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// send writes a gateway payload, dispatches (op 0) get the next sequence number
+func send(c *websocket.Conn, op int, t string, d any) {
+	lock.Lock()
+	writeLock := clients[c]
+	p := map[string]any{"op": op, "d": d}
+	if op == 0 {
+		seq++
+		p["s"], p["t"] = seq, t
+	}
+	lock.Unlock()
+	if writeLock == nil {
+		return
+	}
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	c.WriteJSON(p)
+}
+
+func gatewayHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	lock.Lock()
+	clients[c] = &sync.Mutex{}
+	lock.Unlock()
+	defer func() {
+		lock.Lock()
+		delete(clients, c)
+		lock.Unlock()
+	}()
+	log.Println("bot connected")
+	send(c, 10, "", map[string]any{"heartbeat_interval": 41250})
+	for {
+		var p struct {
+			Op int `json:"op"`
+		}
+		if err := c.ReadJSON(&p); err != nil {
+			log.Println("bot disconnected:", err)
+			return
+		}
+		switch p.Op {
+		case 1:
+			send(c, 11, "", nil)
+		case 2:
+			send(c, 0, "READY", map[string]any{
+				"session_id":         "fake-session",
+				"resume_gateway_url": "ws://" + *addr,
+				"user":               map[string]any{"id": "1", "username": "fakebot"},
+			})
+		case 6:
+			send(c, 0, "RESUMED", map[string]any{})
+		}
+	}
+}
+
+// POST /channels/{id}/messages
+func postMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Content string `json:"content"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	fmt.Printf("[bot -> %s] %s\n", strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/channels/"), "/messages"), body.Content)
+	json.NewEncoder(w).Encode(map[string]any{"id": fmt.Sprint(time.Now().UnixNano())})
+}
+
+func readStdin() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		username, text, ok := strings.Cut(scanner.Text(), ": ")
+		if !ok {
+			username, text = "someone", scanner.Text()
+		}
+		msg := map[string]any{
+			"id":         fmt.Sprint(time.Now().UnixNano()),
+			"channel_id": *channelID,
+			"content":    text,
+			"author":     map[string]any{"id": "user-" + username, "username": username},
+		}
+		lock.Lock()
+		conns := make([]*websocket.Conn, 0, len(clients))
+		for c := range clients {
+			conns = append(conns, c)
+		}
+		lock.Unlock()
+		for _, c := range conns {
+			send(c, 0, "MESSAGE_CREATE", msg)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// tiktokDecode replays tiktok websocket frames recorded with TIKTOK_RECORD_DIR and prints the multichat
+// messages and events they decode to, one JSON object per line, without connecting to tiktok. Use it to
+// check the decoding after tiktok changes something: go run ./tools/tiktokDecode -host name frames/*.bin
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"multibot/tenant-container/src/tiktokChat"
+)
+
+var host = flag.String("host", "", "the streamer's tiktok username, their messages get the broadcaster role")
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatal("usage: tiktokDecode [-host username] frame.bin...")
+	}
+	failed := false
+	for _, name := range flag.Args() {
+		raw, err := os.ReadFile(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		msgs, err := tiktokChat.Decode(raw, tiktokChat.Username(*host))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			failed = true
+		}
+		for _, msg := range msgs {
+			line, _ := json.Marshal(msg)
+			fmt.Println(string(line))
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}